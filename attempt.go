@@ -0,0 +1,85 @@
+// Copyright 2025 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// AttemptHdr is the header carrying the attempt number of a message that
+// is republished, starting at 1.
+const AttemptHdr = "Nats-Attempt"
+
+// WithAttempt sets the AttemptHdr header of the published message to the
+// given attempt number. It applies to JetStream publish calls and to
+// Conn.PublishMsgWithOpts. Requires a server with headers support.
+func WithAttempt(n int) PubOpt {
+	return pubOptFn(func(opts *pubOpts) error {
+		if n < 1 {
+			return fmt.Errorf("%w: attempt should be at least 1", ErrInvalidArg)
+		}
+		opts.attempt = n
+		return nil
+	})
+}
+
+// Attempt returns the attempt number carried by the AttemptHdr header of
+// the message, or 1 if the header is missing or invalid.
+func (m *Msg) Attempt() int {
+	if m == nil {
+		return 1
+	}
+	n, err := strconv.Atoi(m.Header.Get(AttemptHdr))
+	if err != nil || n < 1 {
+		return 1
+	}
+	return n
+}
+
+// RequestWithRetry sends the request up to attempts times, each attempt
+// waiting up to perTry for the response and carrying its number in the
+// AttemptHdr header. A new attempt is made when the previous one fails with
+// ErrTimeout or ErrNoResponders, waiting for perTry to elapse in the latter
+// case since the server reports no responders right away. The first
+// response received is returned, otherwise the error of the last attempt.
+func (nc *Conn) RequestWithRetry(subj string, data []byte, attempts int, perTry time.Duration) (*Msg, error) {
+	if nc == nil {
+		return nil, ErrInvalidConnection
+	}
+	if attempts < 1 {
+		return nil, fmt.Errorf("%w: attempts should be at least 1", ErrInvalidArg)
+	}
+	var err error
+	for i := 1; i <= attempts; i++ {
+		start := time.Now()
+		m := &Msg{Subject: subj, Data: data, Header: Header{AttemptHdr: []string{strconv.Itoa(i)}}}
+		var resp *Msg
+		resp, err = nc.RequestMsg(m, perTry)
+		if err == nil {
+			return resp, nil
+		}
+		if !errors.Is(err, ErrTimeout) && !errors.Is(err, ErrNoResponders) {
+			return nil, err
+		}
+		if i < attempts && errors.Is(err, ErrNoResponders) {
+			if wait := perTry - time.Since(start); wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+	}
+	return nil, err
+}
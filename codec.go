@@ -0,0 +1,88 @@
+// Copyright 2025 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"sync"
+)
+
+// Codec is used to (de)serialize message payloads based on the
+// message's Content-Type header.
+type Codec interface {
+	Encode(v any) ([]byte, error)
+	Decode(data []byte, vPtr any) error
+}
+
+const (
+	// ContentTypeHdr is the header used to select the codec of a message.
+	ContentTypeHdr = "Content-Type"
+	// ContentTypeJSON is the content type used when a message has no Content-Type header.
+	ContentTypeJSON = "application/json"
+)
+
+var codecMap = map[string]Codec{
+	ContentTypeJSON: jsonCodec{},
+}
+var codecLock sync.RWMutex
+
+// RegisterCodec will register the codec for the given content type,
+// replacing any codec previously registered for it.
+func RegisterCodec(contentType string, codec Codec) {
+	codecLock.Lock()
+	defer codecLock.Unlock()
+	codecMap[contentType] = codec
+}
+
+// CodecForContentType will return the codec registered for the content type.
+// Media type parameters, such as "; charset=utf-8", are ignored.
+func CodecForContentType(contentType string) Codec {
+	if mt, _, err := mime.ParseMediaType(contentType); err == nil {
+		contentType = mt
+	}
+	codecLock.RLock()
+	defer codecLock.RUnlock()
+	return codecMap[contentType]
+}
+
+// UnmarshalInto decodes the message payload into vPtr, using the codec
+// registered for the message's Content-Type header, or JSON if the
+// header is not set. ErrUnknownContentType is returned if no codec is
+// registered for the content type.
+func (m *Msg) UnmarshalInto(vPtr any) error {
+	if m == nil {
+		return ErrInvalidMsg
+	}
+	contentType := m.Header.Get(ContentTypeHdr)
+	if contentType == _EMPTY_ {
+		contentType = ContentTypeJSON
+	}
+	codec := CodecForContentType(contentType)
+	if codec == nil {
+		return fmt.Errorf("%w: %q", ErrUnknownContentType, contentType)
+	}
+	return codec.Decode(m.Data, vPtr)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Encode(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Decode(data []byte, vPtr any) error {
+	return json.Unmarshal(data, vPtr)
+}
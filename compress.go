@@ -0,0 +1,195 @@
+// Copyright 2025 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/klauspost/compress/s2"
+)
+
+// CompressionHdr is the header set on compressed messages, its value
+// being the CompressAlgo used.
+const CompressionHdr = "Nats-Compression"
+
+// CompressAlgo is an algorithm used to compress message payloads.
+type CompressAlgo string
+
+const (
+	// CompressGzip compresses payloads with gzip.
+	CompressGzip CompressAlgo = "gzip"
+	// CompressS2 compresses payloads with S2.
+	CompressS2 CompressAlgo = "s2"
+	// CompressSnappy compresses payloads with Snappy.
+	CompressSnappy CompressAlgo = "snappy"
+)
+
+// DefaultMaxDecompressedSize is the default maximum size of a payload
+// decompressed by AutoDecompress, see MaxDecompressedSize.
+const DefaultMaxDecompressedSize = 8 * 1024 * 1024
+
+// ErrDecompression is reported to the error handler of a subscription
+// created with AutoDecompress when a message can't be decompressed.
+var ErrDecompression = errors.New("nats: message decompression failed")
+
+// Compress compresses the payload of the published message with the given
+// algorithm and sets the CompressionHdr header. It applies to JetStream
+// publish calls and to Conn.PublishMsgWithOpts.
+func Compress(algo CompressAlgo) PubOpt {
+	return pubOptFn(func(opts *pubOpts) error {
+		switch algo {
+		case CompressGzip, CompressS2, CompressSnappy:
+		default:
+			return fmt.Errorf("%w: unknown compression algorithm %q", ErrInvalidArg, algo)
+		}
+		opts.compress = algo
+		return nil
+	})
+}
+
+// AutoDecompress decompresses, before they are delivered, the messages with
+// a CompressionHdr header set by the Compress option publishing them, for
+// a subscription created with SubscribeWithOpts or QueueSubscribeWithOpts.
+// The header is removed from decompressed messages. Messages compressed with
+// an unknown algorithm are delivered unchanged. Messages that fail to be
+// decompressed are dropped and ErrDecompression is reported to the error
+// handler, as are messages that would decompress to more than
+// DefaultMaxDecompressedSize bytes, see MaxDecompressedSize. Messages are
+// decompressed by the go routine delivering them, so the pending limits and
+// the EndMarker function apply to the messages as received.
+func AutoDecompress() SubOpt {
+	return subOptFn(func(opts *subOpts) error {
+		opts.decompress = true
+		return nil
+	})
+}
+
+// MaxDecompressedSize sets the maximum size, in bytes, of a payload
+// decompressed by AutoDecompress, protecting the subscriber against
+// messages that decompress to huge payloads. Messages exceeding it are
+// dropped and ErrDecompression is reported to the error handler.
+// Defaults to DefaultMaxDecompressedSize.
+func MaxDecompressedSize(size int) SubOpt {
+	return subOptFn(func(opts *subOpts) error {
+		if size <= 0 {
+			return fmt.Errorf("%w: max decompressed size should be positive", ErrInvalidArg)
+		}
+		opts.maxDecompressed = size
+		return nil
+	})
+}
+
+// copyForPublish returns a copy of the message, with a copy of its header,
+// that can be modified before being published.
+func copyForPublish(m *Msg) *Msg {
+	cm := m.copyMsg(false)
+	if cm.Header == nil {
+		cm.Header = Header{}
+	}
+	return cm
+}
+
+// compressMsg compresses the payload of the message and sets the
+// CompressionHdr header. Header is expected to be non nil.
+func compressMsg(m *Msg, algo CompressAlgo) error {
+	var data []byte
+	switch algo {
+	case CompressGzip:
+		var b bytes.Buffer
+		w := gzip.NewWriter(&b)
+		if _, err := w.Write(m.Data); err != nil {
+			return err
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+		data = b.Bytes()
+	case CompressS2:
+		data = s2.Encode(nil, m.Data)
+	case CompressSnappy:
+		data = s2.EncodeSnappy(nil, m.Data)
+	default:
+		return nil
+	}
+	m.Data = data
+	m.Header.Set(CompressionHdr, string(algo))
+	return nil
+}
+
+// decompressMsg decompresses the payload of a message compressed with a
+// known algorithm and removes the CompressionHdr header. An error is
+// returned if the payload would decompress to more than max bytes.
+func decompressMsg(m *Msg, max int) error {
+	var data []byte
+	var err error
+	switch algo := CompressAlgo(m.Header.Get(CompressionHdr)); algo {
+	case CompressGzip:
+		var r *gzip.Reader
+		if r, err = gzip.NewReader(bytes.NewReader(m.Data)); err == nil {
+			data, err = io.ReadAll(io.LimitReader(r, int64(max)+1))
+			if err == nil && len(data) > max {
+				err = fmt.Errorf("decompressed size exceeds %d bytes", max)
+			}
+		}
+	case CompressS2, CompressSnappy:
+		// S2 decodes Snappy blocks as well.
+		var n int
+		if n, err = s2.DecodedLen(m.Data); err == nil {
+			if n > max {
+				err = fmt.Errorf("decompressed size of %d bytes exceeds %d bytes", n, max)
+			} else {
+				data, err = s2.Decode(nil, m.Data)
+			}
+		}
+	default:
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrDecompression, err)
+	}
+	m.Data = data
+	m.Header.Del(CompressionHdr)
+	return nil
+}
+
+// PublishMsgWithOpts is like PublishMsg, but is configured with options
+// such as Compress or WithAttempt. Options specific to JetStream are
+// ignored. The given message is left untouched.
+func (nc *Conn) PublishMsgWithOpts(m *Msg, opts ...PubOpt) error {
+	if m == nil {
+		return ErrInvalidMsg
+	}
+	var o pubOpts
+	for _, opt := range opts {
+		if err := opt.configurePublish(&o); err != nil {
+			return err
+		}
+	}
+	if o.compress != _EMPTY_ || o.attempt > 0 {
+		cm := copyForPublish(m)
+		if o.attempt > 0 {
+			cm.Header.Set(AttemptHdr, strconv.Itoa(o.attempt))
+		}
+		if err := compressMsg(cm, o.compress); err != nil {
+			return err
+		}
+		m = cm
+	}
+	return nc.PublishMsg(m)
+}
@@ -92,6 +92,7 @@ func (nc *Conn) oldRequestWithContext(ctx context.Context, subj string, hdr, dat
 	if err != nil {
 		return nil, err
 	}
+	s.markInternal()
 	s.AutoUnsubscribe(1)
 	defer s.Unsubscribe()
 
@@ -123,8 +124,17 @@ func (s *Subscription) nextMsgWithContext(ctx context.Context, pullSubInternal,
 
 	// snapshot
 	mch := s.mch
+	resumeCh := s.resumeCh
 	s.mu.Unlock()
 
+	if resumeCh != nil {
+		select {
+		case <-resumeCh:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
 	var ok bool
 	var msg *Msg
 
@@ -168,6 +178,100 @@ func (s *Subscription) NextMsgWithContext(ctx context.Context) (*Msg, error) {
 	return s.nextMsgWithContext(ctx, false, true)
 }
 
+// WaitForStatus blocks until the subscription reaches one of the given
+// statuses, returning it, or until the context is done, in which case
+// ctx.Err() is returned. If the subscription is already in one of the
+// statuses, it is returned right away. ErrBadSubscription is returned if
+// the subscription is, or gets, closed and SubscriptionClosed is not
+// awaited.
+func (s *Subscription) WaitForStatus(ctx context.Context, statuses ...SubStatus) (SubStatus, error) {
+	if ctx == nil {
+		return 0, ErrInvalidContext
+	}
+	if s == nil {
+		return 0, ErrBadSubscription
+	}
+	if len(statuses) == 0 {
+		return 0, ErrInvalidArg
+	}
+
+	s.mu.Lock()
+	status := s.status
+	if containsStatus(statuses, status) {
+		s.mu.Unlock()
+		return status, nil
+	}
+	if status == SubscriptionClosed {
+		s.mu.Unlock()
+		return status, ErrBadSubscription
+	}
+	ch := make(chan SubStatus, len(statuses)+1)
+	for _, st := range statuses {
+		s.registerStatusChangeListener(st, ch)
+	}
+	// Always listen for the subscription being closed, so that the wait
+	// does not outlive it.
+	waitClosed := containsStatus(statuses, SubscriptionClosed)
+	if !waitClosed {
+		s.registerStatusChangeListener(SubscriptionClosed, ch)
+	}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.statListeners, ch)
+		s.mu.Unlock()
+	}()
+
+	select {
+	case status, ok := <-ch:
+		if !ok {
+			status = SubscriptionClosed
+		}
+		if status == SubscriptionClosed && !waitClosed {
+			return status, ErrBadSubscription
+		}
+		return status, nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		status = s.status
+		s.mu.Unlock()
+		return status, ctx.Err()
+	}
+}
+
+// DrainWithContext will drain the subscription like Drain, but blocks
+// until the subscription has been removed from the server and all its
+// pending messages have been delivered, or until the context is done.
+// If the context is done first, ctx.Err() is returned and the drain
+// continues in the background.
+// ErrConnectionClosed is returned if the connection is closed before the
+// drain completes.
+func (s *Subscription) DrainWithContext(ctx context.Context) error {
+	if ctx == nil {
+		return ErrInvalidContext
+	}
+	if s == nil {
+		return ErrBadSubscription
+	}
+	closed := s.StatusChanged(SubscriptionClosed)
+	if err := s.Drain(); err != nil {
+		return err
+	}
+	select {
+	case <-closed:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	s.mu.Lock()
+	connClosed := s.connClosed
+	s.mu.Unlock()
+	if connClosed {
+		return ErrConnectionClosed
+	}
+	return nil
+}
+
 // FlushWithContext will allow a context to control the duration
 // of a Flush() call. This context should be non-nil and should
 // have a deadline set. We will return an error if none is present.
@@ -244,3 +348,37 @@ func (c *EncodedConn) RequestWithContext(ctx context.Context, subject string, v
 
 	return nil
 }
+
+// CloseGracefully drains the connection, as Drain does, and waits for it to
+// be closed. The DrainTimeout option bounds the draining of subscriptions,
+// while the context bounds the whole operation: once it is done, the
+// connection is closed right away.
+// It returns nil if the connection was drained before being closed. If it
+// had to be force-closed, the context error, ErrDrainTimeout, or the error
+// that interrupted draining is returned.
+func (nc *Conn) CloseGracefully(ctx context.Context) error {
+	if nc == nil {
+		return ErrInvalidConnection
+	}
+	if ctx == nil {
+		return ErrInvalidContext
+	}
+	// Listen for the close before draining, so that it can't be missed.
+	closedCh := make(chan Status, 1)
+	nc.mu.Lock()
+	nc.registerStatusChangeListener(CLOSED, closedCh)
+	nc.mu.Unlock()
+	if err := nc.Drain(); err != nil {
+		return err
+	}
+
+	select {
+	case <-closedCh:
+	case <-ctx.Done():
+		nc.Close()
+		return ctx.Err()
+	}
+	nc.mu.RLock()
+	defer nc.mu.RUnlock()
+	return nc.drainErr
+}
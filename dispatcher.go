@@ -0,0 +1,268 @@
+// Copyright 2025 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrDispatcherClosed is returned when subscribing with a Dispatcher that
+// is closed or draining.
+var ErrDispatcherClosed = errors.New("nats: dispatcher closed")
+
+// Dispatcher delivers the messages of several asynchronous subscriptions
+// from a single go routine. Callbacks of all its subscriptions are invoked
+// one at a time, in the order the messages were received from the server,
+// so they never interleave.
+//
+// Messages queued in the dispatcher count toward the pending limits of their
+// subscription, and a subscription exceeding them is a slow consumer, as for
+// any asynchronous subscription.
+type Dispatcher struct {
+	nc       *Conn
+	mu       sync.Mutex
+	cond     *sync.Cond
+	queue    []dispatchMsg
+	subs     map[*Subscription]struct{}
+	started  bool
+	draining bool
+	closed   bool
+}
+
+// dispatchMsg is a message queued for a subscription of a dispatcher.
+type dispatchMsg struct {
+	sub *Subscription
+	m   *Msg
+}
+
+// NewDispatcher returns a Dispatcher to create subscriptions whose
+// callbacks are invoked serially.
+func (nc *Conn) NewDispatcher() *Dispatcher {
+	d := &Dispatcher{nc: nc, subs: make(map[*Subscription]struct{})}
+	d.cond = sync.NewCond(&d.mu)
+	return d
+}
+
+// Subscribe will express interest in the given subject. Messages will be
+// delivered to the callback from the dispatcher's go routine.
+func (d *Dispatcher) Subscribe(subj string, cb MsgHandler) (*Subscription, error) {
+	return d.subscribe(subj, _EMPTY_, cb)
+}
+
+// QueueSubscribe creates a queue subscriber whose messages are delivered
+// to the callback from the dispatcher's go routine.
+func (d *Dispatcher) QueueSubscribe(subj, queue string, cb MsgHandler) (*Subscription, error) {
+	return d.subscribe(subj, queue, cb)
+}
+
+func (d *Dispatcher) subscribe(subj, queue string, cb MsgHandler) (*Subscription, error) {
+	if d == nil || d.nc == nil {
+		return nil, ErrInvalidConnection
+	}
+	if cb == nil {
+		return nil, ErrBadSubscription
+	}
+	nc := d.nc
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	// Holding the dispatcher lock is safe, no message can be delivered to
+	// the new subscription before the connection lock is released.
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed || d.draining {
+		return nil, ErrDispatcherClosed
+	}
+	sub, err := nc.subscribeToDispatcherLocked(subj, queue, cb, d)
+	if err != nil {
+		return nil, err
+	}
+	d.subs[sub] = struct{}{}
+	if !d.started {
+		d.started = true
+		go d.run()
+	}
+	return sub, nil
+}
+
+// Close unsubscribes all subscriptions of the dispatcher and stops its go
+// routine once the callback in progress, if any, returns. Messages still
+// queued are discarded.
+func (d *Dispatcher) Close() error {
+	if d == nil {
+		return ErrInvalidConnection
+	}
+	subs := d.close()
+	var err error
+	for _, s := range subs {
+		if uerr := s.Unsubscribe(); uerr != nil && err == nil && !errors.Is(uerr, ErrBadSubscription) && !errors.Is(uerr, ErrConnectionClosed) {
+			err = uerr
+		}
+	}
+	return err
+}
+
+// Drain drains all subscriptions of the dispatcher. Once all of them are
+// drained, that is all their pending messages have been delivered, the
+// dispatcher is closed. Subscribing with the dispatcher fails after this
+// call.
+func (d *Dispatcher) Drain() error {
+	if d == nil {
+		return ErrInvalidConnection
+	}
+	d.mu.Lock()
+	if d.closed || d.draining {
+		d.mu.Unlock()
+		return nil
+	}
+	d.draining = true
+	if len(d.subs) == 0 {
+		d.closed = true
+		d.cond.Signal()
+		d.mu.Unlock()
+		return nil
+	}
+	subs := make([]*Subscription, 0, len(d.subs))
+	for s := range d.subs {
+		subs = append(subs, s)
+	}
+	d.mu.Unlock()
+
+	var err error
+	for _, s := range subs {
+		if derr := s.Drain(); derr != nil && err == nil {
+			err = derr
+		}
+	}
+	return err
+}
+
+// close marks the dispatcher as closed, signaling its go routine to exit,
+// and returns the subscriptions it had.
+func (d *Dispatcher) close() []*Subscription {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return nil
+	}
+	d.closed = true
+	subs := make([]*Subscription, 0, len(d.subs))
+	for s := range d.subs {
+		subs = append(subs, s)
+	}
+	d.cond.Signal()
+	return subs
+}
+
+// push queues a message, or a barrier, for the subscription.
+// Subscription lock is held on entry.
+func (d *Dispatcher) push(s *Subscription, m *Msg) {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		// The go routine may be gone, release the barrier here.
+		if m.barrier != nil && atomic.AddInt64(&m.barrier.refs, -1) == 0 {
+			go m.barrier.f()
+		}
+		return
+	}
+	d.queue = append(d.queue, dispatchMsg{s, m})
+	if len(d.queue) == 1 {
+		d.cond.Signal()
+	}
+	d.mu.Unlock()
+}
+
+// remove is invoked when a subscription of the dispatcher is removed. The
+// dispatcher is closed when draining and this was the last subscription.
+// Connection and subscription locks are held on entry.
+func (d *Dispatcher) remove(s *Subscription) {
+	d.mu.Lock()
+	delete(d.subs, s)
+	if d.draining && len(d.subs) == 0 && !d.closed {
+		d.closed = true
+		d.cond.Signal()
+	}
+	d.mu.Unlock()
+}
+
+func (d *Dispatcher) run() {
+	var queue []dispatchMsg
+	for {
+		d.mu.Lock()
+		for len(d.queue) == 0 && !d.closed {
+			d.cond.Wait()
+		}
+		closed := d.closed
+		// Swap the queues to deliver the pending messages without the lock.
+		queue, d.queue = d.queue, queue[:0]
+		d.mu.Unlock()
+
+		for i, dm := range queue {
+			if dm.m.barrier != nil {
+				if atomic.AddInt64(&dm.m.barrier.refs, -1) == 0 {
+					dm.m.barrier.f()
+				}
+			} else if !closed {
+				d.deliver(dm.sub, dm.m)
+			}
+			queue[i] = dispatchMsg{}
+		}
+		if closed {
+			return
+		}
+	}
+}
+
+// deliver invokes the callback of the subscription for the message, doing
+// the same accounting as waitForMsgs.
+func (d *Dispatcher) deliver(s *Subscription, m *Msg) {
+	nc := d.nc
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	mcb := s.mcb
+	if s.draining && s.drainCB != nil {
+		mcb = drainMsgHandler(mcb, s.drainCB, s.drainWithCB)
+	}
+	s.delivered++
+	delivered, max := s.delivered, s.max
+	maxBytes := s.addDeliveredBytes(m)
+	if s.capture != nil {
+		s.capture[s.captured%uint64(len(s.capture))] = m
+		s.captured++
+	}
+	s.mu.Unlock()
+
+	if max == 0 || delivered <= max {
+		nc.invokeMsgHandler(s, mcb, m)
+	}
+
+	s.mu.Lock()
+	s.pMsgs--
+	s.addPendingBytes(-len(m.Data))
+	s.mu.Unlock()
+
+	// If we have hit the max for delivered msgs, remove sub.
+	if max > 0 && delivered >= max {
+		nc.mu.Lock()
+		nc.removeSub(s, ClosedReasonMaxMessages)
+		nc.mu.Unlock()
+	} else if maxBytes {
+		nc.unsubscribeAndRemove(s, ClosedReasonMaxBytes)
+	}
+}
@@ -258,7 +258,7 @@ func (c *EncodedConn) subscribe(subject, queue string, cb Handler) (*Subscriptio
 		cbValue.Call(oV)
 	}
 
-	return c.Conn.subscribe(subject, queue, natsCB, nil, nil, false, nil)
+	return c.Conn.subscribe(subject, queue, c.Conn.userHandler(natsCB), nil, nil, false, nil)
 }
 
 // FlushTimeout allows a Flush operation to have an associated timeout.
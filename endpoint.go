@@ -0,0 +1,110 @@
+// Copyright 2025 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// EndpointQueueGroup is the queue group joined by the endpoints added with
+// AddEndpoint, the same as the default queue group of the micro package.
+const EndpointQueueGroup = "q"
+
+// Request is a request received by an endpoint added with AddEndpoint.
+type Request struct {
+	msg *Msg
+}
+
+// AddEndpoint adds an endpoint handling the requests sent to the subject.
+// Endpoints join the EndpointQueueGroup queue group, so that requests are
+// load balanced between the instances of a service. This is a lightweight
+// alternative to the micro package, without service discovery or stats.
+func (nc *Conn) AddEndpoint(subject string, handler func(*Request)) (*Subscription, error) {
+	if handler == nil {
+		return nil, ErrBadSubscription
+	}
+	return nc.QueueSubscribe(subject, EndpointQueueGroup, func(m *Msg) {
+		handler(&Request{msg: m})
+	})
+}
+
+// Msg returns the message of the request.
+func (r *Request) Msg() *Msg {
+	return r.msg
+}
+
+// Subject returns the subject the request was sent to.
+func (r *Request) Subject() string {
+	return r.msg.Subject
+}
+
+// Data returns the payload of the request.
+func (r *Request) Data() []byte {
+	return r.msg.Data
+}
+
+// Header returns the headers of the request.
+func (r *Request) Header() Header {
+	return r.msg.Header
+}
+
+// Respond sends the response to the request.
+func (r *Request) Respond(data []byte) error {
+	return r.msg.Respond(data)
+}
+
+// RespondError sends an error response to the request, with the
+// ServiceErrorHdr and ServiceErrorCodeHdr headers set as services built
+// with the micro package do. Requestors can get it as a ServiceError with
+// the ParseServiceErrors option. Requires a server with headers support.
+func (r *Request) RespondError(code int, description string) error {
+	if description == _EMPTY_ {
+		return fmt.Errorf("%w: empty error description", ErrInvalidArg)
+	}
+	return r.msg.RespondWithHeaders(nil, Header{
+		ServiceErrorHdr:     []string{description},
+		ServiceErrorCodeHdr: []string{strconv.Itoa(code)},
+	})
+}
+
+// ServeQueue joins the queue group, running up to workers handlers
+// concurrently, and replies to each request with what the handler returns.
+// When the handler returns an error, the reply is an error response as sent
+// by RespondError, with the code and description of a *ServiceError, or
+// code 500 and the error text for other errors. Handlers of messages
+// without a reply subject are still invoked. Messages being handled when
+// the subscription is drained are replied to before the drain completes.
+func (nc *Conn) ServeQueue(subj, queue string, workers int, handler func(*Msg) ([]byte, error)) (*Subscription, error) {
+	if handler == nil {
+		return nil, ErrBadSubscription
+	}
+	return nc.QueueSubscribeWithOpts(subj, queue, func(m *Msg) {
+		data, err := handler(m)
+		if m.Reply == _EMPTY_ {
+			return
+		}
+		r := &Request{msg: m}
+		if err == nil {
+			r.Respond(data)
+			return
+		}
+		var se *ServiceError
+		if !errors.As(err, &se) {
+			se = &ServiceError{Code: 500, Description: err.Error()}
+		}
+		r.RespondError(se.Code, se.Description)
+	}, SubscribeConcurrency(workers))
+}
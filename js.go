@@ -484,6 +484,12 @@ type pubOpts struct {
 	// stallWait is the max wait of a async pub ack.
 	stallWait time.Duration
 
+	// Compression of the payload.
+	compress CompressAlgo
+
+	// Attempt number set in the AttemptHdr header.
+	attempt int
+
 	// internal option to re-use existing paf in case of retry.
 	pafRetry *pubAckFuture
 }
@@ -573,6 +579,16 @@ func (js *js) PublishMsg(m *Msg, opts ...PubOpt) (*PubAck, error) {
 	if o.msgTTL > 0 {
 		m.Header.Set(MsgTTLHdr, o.msgTTL.String())
 	}
+	if o.attempt > 0 {
+		m.Header.Set(AttemptHdr, strconv.Itoa(o.attempt))
+	}
+	if o.compress != _EMPTY_ {
+		// Compress a copy, so that the caller's message can be reused.
+		m = copyForPublish(m)
+		if err := compressMsg(m, o.compress); err != nil {
+			return nil, err
+		}
+	}
 
 	var resp *Msg
 	var err error
@@ -706,11 +722,12 @@ func (js *js) newAsyncReply() string {
 			b[i] = rdigits[int(b[i]%base)]
 		}
 		js.rpre = fmt.Sprintf("%s%s.", js.replyPrefix, b[:aReplyTokensize])
-		sub, err := js.nc.Subscribe(fmt.Sprintf("%s*", js.rpre), js.handleAsyncReply)
+		sub, err := js.nc.subscribe(fmt.Sprintf("%s*", js.rpre), _EMPTY_, js.handleAsyncReply, nil, nil, false, nil)
 		if err != nil {
 			js.mu.Unlock()
 			return _EMPTY_
 		}
+		sub.markInternal()
 		js.rsub = sub
 		js.rr = rand.New(rand.NewSource(time.Now().UnixNano()))
 	}
@@ -1054,6 +1071,16 @@ func (js *js) PublishMsgAsync(m *Msg, opts ...PubOpt) (PubAckFuture, error) {
 	if o.msgTTL > 0 {
 		m.Header.Set(MsgTTLHdr, o.msgTTL.String())
 	}
+	if o.attempt > 0 {
+		m.Header.Set(AttemptHdr, strconv.Itoa(o.attempt))
+	}
+	if o.compress != _EMPTY_ {
+		// Compress a copy, so that the caller's message can be reused.
+		m = copyForPublish(m)
+		if err := compressMsg(m, o.compress); err != nil {
+			return nil, err
+		}
+	}
 
 	// Reply
 	paf := o.pafRetry
@@ -1222,10 +1249,14 @@ func StallWait(ttl time.Duration) PubOpt {
 	})
 }
 
-// MsgTTL sets per msg TTL.
+// MsgTTL sets per msg TTL, sent to the server in the Nats-TTL header
+// using the Go duration format, for instance "1m30s".
 // Requires [StreamConfig.AllowMsgTTL] to be enabled.
 func MsgTTL(dur time.Duration) PubOpt {
 	return pubOptFn(func(opts *pubOpts) error {
+		if dur <= 0 {
+			return errors.New("nats: message TTL should be more than 0")
+		}
 		opts.msgTTL = dur
 		return nil
 	})
@@ -1235,6 +1266,7 @@ type ackOpts struct {
 	ttl      time.Duration
 	ctx      context.Context
 	nakDelay time.Duration
+	hdr      Header
 }
 
 // AckOpt are the options that can be passed when acknowledge a message.
@@ -1316,6 +1348,19 @@ func (d nakDelay) configureAck(opts *ackOpts) error {
 	return nil
 }
 
+type ackHeaders Header
+
+func (h ackHeaders) configureAck(opts *ackOpts) error {
+	opts.hdr = Header(h)
+	return nil
+}
+
+// AckHeaders sets the headers of the acknowledgement of core NATS messages,
+// see Msg.Ack. It is ignored when acknowledging JetStream messages.
+func AckHeaders(h Header) AckOpt {
+	return ackHeaders(h)
+}
+
 // Subscribe
 
 // ConsumerConfig is the configuration of a JetStream consumer.
@@ -1694,6 +1739,34 @@ func (js *js) subscribe(subj, queue string, cb MsgHandler, ch chan *Msg, isSync,
 		return nil, errors.New("nats: subject required")
 	}
 
+	if o.concurrency > 0 {
+		return nil, fmt.Errorf("%w: concurrency is not supported for JetStream subscriptions", ErrInvalidArg)
+	}
+	if o.dedupHdr != _EMPTY_ {
+		return nil, fmt.Errorf("%w: deduplication is not supported for JetStream subscriptions", ErrInvalidArg)
+	}
+	if o.capture > 0 {
+		return nil, fmt.Errorf("%w: capture is not supported for JetStream subscriptions", ErrInvalidArg)
+	}
+	if o.noEcho {
+		return nil, fmt.Errorf("%w: echo suppression is not supported for JetStream subscriptions", ErrInvalidArg)
+	}
+	if o.decompress {
+		return nil, fmt.Errorf("%w: decompression is not supported for JetStream subscriptions", ErrInvalidArg)
+	}
+	if o.deadLetter != _EMPTY_ {
+		return nil, fmt.Errorf("%w: dead letter republishing is not supported for JetStream subscriptions", ErrInvalidArg)
+	}
+	if o.drainFlush {
+		return nil, fmt.Errorf("%w: waiting for replies on drain is not supported for JetStream subscriptions", ErrInvalidArg)
+	}
+	if o.endMarker != nil {
+		return nil, fmt.Errorf("%w: end marker is not supported for JetStream subscriptions", ErrInvalidArg)
+	}
+	if o.maxAge > 0 {
+		return nil, fmt.Errorf("%w: maximum message age is not supported for JetStream subscriptions", ErrInvalidArg)
+	}
+
 	// Note that these may change based on the consumer info response we may get.
 	hasHeartbeats := o.cfg.Heartbeat > 0
 	hasFC := o.cfg.FlowControl
@@ -1919,6 +1992,10 @@ func (js *js) subscribe(subj, queue string, cb MsgHandler, ch chan *Msg, isSync,
 		ctx:      o.ctx,
 	}
 
+	// Interceptors only wrap the callbacks of user subscriptions.
+	if !o.internal {
+		cb = nc.userHandler(cb)
+	}
 	// Auto acknowledge unless manual ack is set or policy is set to AckNonePolicy
 	if cb != nil && !o.mack && o.cfg.AckPolicy != AckNonePolicy {
 		ocb := cb
@@ -2366,7 +2443,7 @@ func (sub *Subscription) activityCheck() {
 	if !active {
 		if !jsi.ordered || nc.Status() != CONNECTED {
 			nc.mu.Lock()
-			if errCB := nc.Opts.AsyncErrorCB; errCB != nil {
+			if errCB := nc.subErrorHandler(sub); errCB != nil {
 				nc.ach.push(func() { errCB(nc, sub, ErrConsumerNotActive) })
 			}
 			nc.mu.Unlock()
@@ -2399,7 +2476,7 @@ func (sub *Subscription) scheduleHeartbeatCheck() {
 // handleConsumerSequenceMismatch will send an async error that can be used to restart a push based consumer.
 func (nc *Conn) handleConsumerSequenceMismatch(sub *Subscription, err error) {
 	nc.mu.Lock()
-	errCB := nc.Opts.AsyncErrorCB
+	errCB := nc.subErrorHandler(sub)
 	if errCB != nil {
 		nc.ach.push(func() { errCB(nc, sub, err) })
 	}
@@ -2477,6 +2554,49 @@ type subOpts struct {
 
 	// To disable calling ConsumerInfo
 	skipCInfo bool
+
+	// For concurrent delivery in core async subscriptions.
+	concurrency    int
+	concurrencyKey func(*Msg) string
+
+	// For client side deduplication in core subscriptions.
+	dedupHdr    string
+	dedupWindow time.Duration
+
+	// Number of delivered messages kept in core subscriptions.
+	capture int
+
+	// For client side echo suppression in core subscriptions.
+	noEcho bool
+
+	// For decompression of messages in core subscriptions.
+	decompress      bool
+	maxDecompressed int
+
+	// For flushing replies when draining core subscriptions.
+	drainFlush bool
+
+	// Subject dropped messages of core subscriptions are republished to.
+	deadLetter string
+
+	// For ending core subscriptions on a given message.
+	endMarker  func(*Msg) bool
+	endDeliver bool
+
+	// Maximum age of messages delivered to core subscriptions.
+	maxAge time.Duration
+
+	// For subscriptions used internally by the library.
+	internal bool
+}
+
+// internalSub marks the subscription as used internally by the library,
+// so that it is not wrapped by the connection interceptors.
+func internalSub() SubOpt {
+	return subOptFn(func(opts *subOpts) error {
+		opts.internal = true
+		return nil
+	})
 }
 
 // SkipConsumerLookup will omit looking up consumer when [Bind], [Durable]
@@ -3656,10 +3776,49 @@ func (m *Msg) ackReply(ackType []byte, sync bool, opts ...AckOpt) error {
 
 // Ack acknowledges a message. This tells the server that the message was
 // successfully processed and it can move on to the next message.
+//
+// For core NATS messages, that is messages of subscriptions not created
+// with the JetStream context, an empty-bodied acknowledgement, with the headers set with
+// AckHeaders if any, is published to the reply subject. ErrMsgNoReply is
+// returned if the message has no reply subject.
 func (m *Msg) Ack(opts ...AckOpt) error {
+	if m != nil && m.Sub != nil {
+		m.Sub.mu.Lock()
+		core := m.Sub.jsi == nil
+		m.Sub.mu.Unlock()
+		if core {
+			return m.ackCore(opts)
+		}
+	}
 	return m.ackReply(ackAck, false, opts...)
 }
 
+// ackCore acknowledges a message of a core NATS subscription with an empty
+// message, carrying the headers set with AckHeaders, if any.
+func (m *Msg) ackCore(opts []AckOpt) error {
+	var o ackOpts
+	for _, opt := range opts {
+		if err := opt.configureAck(&o); err != nil {
+			return err
+		}
+	}
+	if m.Reply == _EMPTY_ {
+		return ErrMsgNoReply
+	}
+	if atomic.LoadUint32(&m.ackd) == 1 {
+		return ErrMsgAlreadyAckd
+	}
+	m.Sub.mu.Lock()
+	nc := m.Sub.conn
+	m.Sub.mu.Unlock()
+
+	err := nc.PublishMsg(&Msg{Subject: m.Reply, Header: o.hdr})
+	if err == nil {
+		atomic.StoreUint32(&m.ackd, 1)
+	}
+	return err
+}
+
 // AckSync is the synchronous version of Ack. This indicates successful message
 // processing.
 func (m *Msg) AckSync(opts ...AckOpt) error {
@@ -1094,7 +1094,7 @@ func (kv *kvs) WatchFiltered(keys []string, opts ...WatchOpt) (KeyWatcher, error
 	}
 
 	// Used ordered consumer to deliver results.
-	subOpts := []SubOpt{BindStream(kv.stream), OrderedConsumer()}
+	subOpts := []SubOpt{BindStream(kv.stream), OrderedConsumer(), internalSub()}
 	if !o.includeHistory {
 		subOpts = append(subOpts, DeliverLastPerSubject())
 	}
@@ -1123,6 +1123,7 @@ func (kv *kvs) WatchFiltered(keys []string, opts ...WatchOpt) (KeyWatcher, error
 	if err != nil {
 		return nil, err
 	}
+	sub.markInternal()
 	sub.mu.Lock()
 	// If there were no pending messages at the time of the creation
 	// of the consumer, send the marker.
@@ -0,0 +1,119 @@
+// Copyright 2025 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// latencySubBits is the number of bits used to split each power of two
+	// into linear sub-buckets, which bounds the relative error to 1/4.
+	latencySubBits    = 2
+	latencySubBuckets = 1 << latencySubBits
+	latencyBuckets    = (64 - latencySubBits + 1) * latencySubBuckets
+)
+
+// latencyHistogram is a log-linear histogram of durations, safe for a
+// concurrent use without locking.
+type latencyHistogram struct {
+	counts [latencyBuckets]atomic.Uint64
+	total  atomic.Uint64
+	max    atomic.Int64
+}
+
+// latencyIndex returns the bucket of a duration in nanoseconds.
+func latencyIndex(v uint64) int {
+	if v < latencySubBuckets {
+		return int(v)
+	}
+	exp := bits.Len64(v) - latencySubBits
+	return exp*latencySubBuckets + int(v>>(exp-1)) - latencySubBuckets
+}
+
+// latencyBound returns the largest duration falling in the given bucket.
+func latencyBound(i int) time.Duration {
+	if i < latencySubBuckets {
+		return time.Duration(i)
+	}
+	exp := i/latencySubBuckets - 1
+	sub := uint64(i%latencySubBuckets + latencySubBuckets)
+	return time.Duration((sub+1)<<exp - 1)
+}
+
+func (h *latencyHistogram) record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	h.counts[latencyIndex(uint64(d))].Add(1)
+	h.total.Add(1)
+	for {
+		m := h.max.Load()
+		if int64(d) <= m || h.max.CompareAndSwap(m, int64(d)) {
+			return
+		}
+	}
+}
+
+// quantile returns an upper bound of the q quantile, 0 if nothing was
+// recorded.
+func (h *latencyHistogram) quantile(q float64) time.Duration {
+	total := h.total.Load()
+	if total == 0 {
+		return 0
+	}
+	target := uint64(q*float64(total) + 0.5)
+	if target == 0 {
+		target = 1
+	}
+	max := time.Duration(h.max.Load())
+	var seen uint64
+	for i := range h.counts {
+		if seen += h.counts[i].Load(); seen >= target {
+			return min(latencyBound(i), max)
+		}
+	}
+	return max
+}
+
+// CallbackLatency returns the 50th and 99th percentiles, approximated within
+// 25%, and the maximum of the time spent in the message handler of an
+// asynchronous subscription. To not cost anything to the subscriptions that
+// are not observed, callbacks are only timed once CallbackLatency has been
+// called a first time, which returns zero durations. Subscriptions used
+// internally by the library are never timed.
+func (s *Subscription) CallbackLatency() (p50, p99, max time.Duration, err error) {
+	if s == nil {
+		return 0, 0, 0, ErrBadSubscription
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil || s.closed {
+		return 0, 0, 0, ErrBadSubscription
+	}
+	if s.typ != AsyncSubscription {
+		return 0, 0, 0, ErrTypeSubscription
+	}
+	if s.internal {
+		return 0, 0, 0, nil
+	}
+	h := s.latency.Load()
+	if h == nil {
+		h = &latencyHistogram{}
+		s.latency.Store(h)
+	}
+	return h.quantile(0.50), h.quantile(0.99), time.Duration(h.max.Load()), nil
+}
@@ -139,8 +139,8 @@ func (r *request) Error(code, description string, data []byte, opts ...RespondOp
 	}
 	response := &nats.Msg{
 		Header: nats.Header{
-			ErrorHeader:     []string{description},
-			ErrorCodeHeader: []string{code},
+			nats.ServiceErrorHdr:     []string{description},
+			nats.ServiceErrorCodeHdr: []string{code},
 		},
 	}
 	for _, opt := range opts {
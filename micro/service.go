@@ -263,9 +263,11 @@ const (
 )
 
 // Service Error headers
+//
+// Deprecated: use nats.ServiceErrorHdr and nats.ServiceErrorCodeHdr.
 const (
-	ErrorHeader     = "Nats-Service-Error"
-	ErrorCodeHeader = "Nats-Service-Error-Code"
+	ErrorHeader     = nats.ServiceErrorHdr
+	ErrorCodeHeader = nats.ServiceErrorCodeHdr
 )
 
 // Verbs being used to set up a specific control subject.
@@ -16,6 +16,7 @@
 package nats
 
 import (
+	"context"
 	"errors"
 	"iter"
 	"time"
@@ -70,3 +71,190 @@ func (sub *Subscription) MsgsTimeout(timeout time.Duration) iter.Seq2[*Msg, erro
 		}
 	}
 }
+
+// RequestManyOpt is used to configure RequestMany.
+type RequestManyOpt func(*requestManyOpts) error
+
+type requestManyOpts struct {
+	maxWait  time.Duration
+	maxMsgs  int
+	stall    time.Duration
+	sentinel func(*Msg) bool
+}
+
+// RequestManyMaxWait sets the maximum time to wait for responses.
+// Defaults to the connection's Timeout option.
+func RequestManyMaxWait(timeout time.Duration) RequestManyOpt {
+	return func(o *requestManyOpts) error {
+		if timeout <= 0 {
+			return ErrInvalidArg
+		}
+		o.maxWait = timeout
+		return nil
+	}
+}
+
+// RequestManyMaxMsgs sets the maximum number of responses to receive.
+func RequestManyMaxMsgs(max int) RequestManyOpt {
+	return func(o *requestManyOpts) error {
+		if max <= 0 {
+			return ErrInvalidArg
+		}
+		o.maxMsgs = max
+		return nil
+	}
+}
+
+// RequestManyStall sets the maximum time to wait between responses,
+// once the first response has been received.
+func RequestManyStall(stall time.Duration) RequestManyOpt {
+	return func(o *requestManyOpts) error {
+		if stall <= 0 {
+			return ErrInvalidArg
+		}
+		o.stall = stall
+		return nil
+	}
+}
+
+// requestManyChanLen is the maximum number of responses buffered by
+// RequestMany.
+const requestManyChanLen = 1024
+
+// RequestManySentinel sets a function that is called for each response.
+// If it returns true, the iteration ends and the response is not
+// returned. For instance, to stop on an empty message:
+//
+//	nats.RequestManySentinel(func(m *nats.Msg) bool { return len(m.Data) == 0 })
+func RequestManySentinel(f func(*Msg) bool) RequestManyOpt {
+	return func(o *requestManyOpts) error {
+		o.sentinel = f
+		return nil
+	}
+}
+
+// RequestMany will send a request payload and return an iter.Seq2[*Msg, error]
+// over the responses, for when multiple responders are expected to answer.
+// The iteration ends when the maximum wait, the maximum number of messages
+// or the stall time is reached, or when the sentinel matches. ErrNoResponders
+// is returned if there are no responders for the request.
+//
+// The responses are received by the connection's response subscription,
+// shared with Request. Up to 1024 responses not yet iterated over, or the
+// maximum number of messages if lower, are buffered, the following ones
+// being dropped.
+func (nc *Conn) RequestMany(subj string, data []byte, opts ...RequestManyOpt) iter.Seq2[*Msg, error] {
+	return nc.RequestManyWithContext(context.Background(), subj, data, opts...)
+}
+
+// RequestManyWithContext is like RequestMany but the iteration also ends,
+// with ctx.Err(), when the context is done.
+func (nc *Conn) RequestManyWithContext(ctx context.Context, subj string, data []byte, opts ...RequestManyOpt) iter.Seq2[*Msg, error] {
+	return func(yield func(*Msg, error) bool) {
+		if ctx == nil {
+			yield(nil, ErrInvalidContext)
+			return
+		}
+		if nc == nil {
+			yield(nil, ErrInvalidConnection)
+			return
+		}
+		o := requestManyOpts{maxWait: nc.Opts.Timeout}
+		for _, opt := range opts {
+			if err := opt(&o); err != nil {
+				yield(nil, err)
+				return
+			}
+		}
+
+		chLen := requestManyChanLen
+		if o.maxMsgs > 0 && o.maxMsgs < chLen {
+			chLen = o.maxMsgs
+		}
+		mch := make(chan *Msg, chLen)
+		token, err := nc.sendNewRequest(subj, nil, data, mch, true)
+		defer nc.removeRequest(token)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+
+		wctx, cancel := context.WithTimeout(ctx, o.maxWait)
+		defer cancel()
+		for received := 0; o.maxMsgs == 0 || received < o.maxMsgs; received++ {
+			mctx := wctx
+			var mcancel context.CancelFunc
+			if o.stall > 0 && received > 0 {
+				mctx, mcancel = context.WithTimeout(wctx, o.stall)
+			}
+			var msg *Msg
+			var err error
+			select {
+			case m, ok := <-mch:
+				if !ok {
+					err = ErrConnectionClosed
+				} else if len(m.Data) == 0 && m.Header.Get(statusHdr) == noResponders {
+					err = ErrNoResponders
+				}
+				msg = m
+			case <-mctx.Done():
+				err = mctx.Err()
+			}
+			if mcancel != nil {
+				mcancel()
+			}
+			if err != nil {
+				// The maximum wait or the stall time have been reached.
+				if ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+					return
+				}
+				if ctx.Err() != nil {
+					err = ctx.Err()
+				}
+				yield(nil, err)
+				return
+			}
+			if o.sentinel != nil && o.sentinel(msg) {
+				return
+			}
+			if !yield(msg, nil) {
+				return
+			}
+		}
+	}
+}
+
+// ResponderInfo is a response returned by RequestWithResponderInfo.
+type ResponderInfo struct {
+	// Responder is the identity set by the responder in the
+	// ResponderHdr header, if any.
+	Responder string
+	// Latency is the time between sending the request and
+	// receiving this response.
+	Latency time.Duration
+	// Msg is the response.
+	Msg *Msg
+}
+
+// RequestWithResponderInfo is like RequestMany but returns, for each
+// response, the responder's identity and the latency of the response.
+// Responders identify themselves by setting the ResponderHdr header.
+func (nc *Conn) RequestWithResponderInfo(subj string, data []byte, opts ...RequestManyOpt) iter.Seq2[ResponderInfo, error] {
+	return func(yield func(ResponderInfo, error) bool) {
+		start := time.Now()
+		for msg, err := range nc.RequestMany(subj, data, opts...) {
+			if err != nil {
+				yield(ResponderInfo{}, err)
+				return
+			}
+			ri := ResponderInfo{
+				Responder: msg.Responder(),
+				Latency:   time.Since(start),
+				Msg:       msg,
+			}
+			if !yield(ri, nil) {
+				return
+			}
+		}
+	}
+}
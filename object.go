@@ -698,11 +698,13 @@ func (obs *obs) Get(name string, opts ...GetObjectOpt) (ObjectResult, error) {
 	subscribeOpts := []SubOpt{
 		OrderedConsumer(),
 		BindStream(streamName),
+		internalSub(),
 	}
-	_, err = obs.js.Subscribe(chunkSubj, processChunk, subscribeOpts...)
+	sub, err := obs.js.Subscribe(chunkSubj, processChunk, subscribeOpts...)
 	if err != nil {
 		return nil, err
 	}
+	sub.markInternal()
 
 	return result, nil
 }
@@ -1116,7 +1118,7 @@ func (obs *obs) Watch(opts ...WatchOpt) (ObjectWatcher, error) {
 
 	// Used ordered consumer to deliver results.
 	streamName := fmt.Sprintf(objNameTmpl, obs.name)
-	subOpts := []SubOpt{OrderedConsumer(), BindStream(streamName)}
+	subOpts := []SubOpt{OrderedConsumer(), BindStream(streamName), internalSub()}
 	if !o.includeHistory {
 		subOpts = append(subOpts, DeliverLastPerSubject())
 	}
@@ -1127,6 +1129,7 @@ func (obs *obs) Watch(opts ...WatchOpt) (ObjectWatcher, error) {
 	if err != nil {
 		return nil, err
 	}
+	sub.markInternal()
 	// Set us up to close when the waitForMessages func returns.
 	sub.pDone = func(_ string) {
 		close(w.updates)
@@ -0,0 +1,174 @@
+// Copyright 2025 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+const (
+	// outboxFile is the name of the outbox file in the OutboxDir.
+	outboxFile = "outbox"
+	// outboxFrameHdr is the size of the length prefix of each frame.
+	outboxFrameHdr = 4
+)
+
+// outbox is an append-only file holding the messages published while
+// disconnected. Each frame is a big-endian uint32 length followed by the
+// PUB or HPUB protocol message, as it is sent to the server.
+// Connection lock should be held when using it.
+type outbox struct {
+	f     *os.File
+	max   int64
+	count int
+	bytes int64
+	// Set while being sent by Conn.replayOutbox, roff being the offset of
+	// the next frame to send.
+	replaying bool
+	roff      int64
+}
+
+// openOutbox opens, or creates, the outbox file in dir. Frames left by a
+// previous process are counted, and a partially written last frame, as can
+// result from a crash, is discarded.
+func openOutbox(dir string, max int64) (*outbox, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, outboxFile), os.O_RDWR|os.O_CREATE, 0o640)
+	if err != nil {
+		return nil, err
+	}
+	o := &outbox{f: f, max: max}
+	br := bufio.NewReader(f)
+	var lb [outboxFrameHdr]byte
+	for {
+		if _, err := io.ReadFull(br, lb[:]); err != nil {
+			break
+		}
+		l := int64(binary.BigEndian.Uint32(lb[:]))
+		if n, _ := br.Discard(int(l)); int64(n) != l {
+			break
+		}
+		o.count++
+		o.bytes += outboxFrameHdr + l
+	}
+	if err := f.Truncate(o.bytes); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(o.bytes, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return o, nil
+}
+
+// append writes a frame made of the given buffers. ErrReconnectBufExceeded
+// is returned if this would take the outbox over its maximum size.
+func (o *outbox) append(bufs ...[]byte) error {
+	var l int
+	for _, b := range bufs {
+		l += len(b)
+	}
+	if o.max > 0 && o.bytes+outboxFrameHdr+int64(l) > o.max {
+		return ErrReconnectBufExceeded
+	}
+	frame := make([]byte, outboxFrameHdr, outboxFrameHdr+l)
+	binary.BigEndian.PutUint32(frame, uint32(l))
+	for _, b := range bufs {
+		frame = append(frame, b...)
+	}
+	if _, err := o.f.Write(frame); err != nil {
+		// Do not leave a partial frame behind.
+		o.f.Truncate(o.bytes)
+		o.f.Seek(o.bytes, io.SeekStart)
+		return err
+	}
+	o.count++
+	o.bytes += int64(len(frame))
+	return nil
+}
+
+// startReplay prepares to send the messages from the first one, and reports
+// whether there are any. Until the replay is finished, publish operations
+// keep storing their messages after the ones of the outbox.
+func (o *outbox) startReplay() bool {
+	if o == nil || o.count == 0 {
+		return false
+	}
+	o.replaying, o.roff = true, 0
+	return true
+}
+
+// next returns the protocol messages of the frames following the ones
+// already returned, up to max bytes but at least one frame, and their count.
+func (o *outbox) next(max int) ([]byte, int, error) {
+	buf := make([]byte, 0, max)
+	var lb [outboxFrameHdr]byte
+	var n int
+	for o.roff < o.bytes {
+		if _, err := o.f.ReadAt(lb[:], o.roff); err != nil {
+			return nil, 0, err
+		}
+		l := int(binary.BigEndian.Uint32(lb[:]))
+		if n > 0 && len(buf)+l > max {
+			break
+		}
+		start := len(buf)
+		buf = slices.Grow(buf, l)[:start+l]
+		if _, err := o.f.ReadAt(buf[start:], o.roff+outboxFrameHdr); err != nil {
+			return nil, 0, err
+		}
+		o.roff += outboxFrameHdr + int64(l)
+		n++
+	}
+	return buf, n, nil
+}
+
+// done reports whether all frames were returned by next.
+func (o *outbox) done() bool {
+	return o.roff >= o.bytes
+}
+
+// stopReplay stops the replay, the frames are kept and sent again, from the
+// first one, on the next replay.
+func (o *outbox) stopReplay() {
+	o.replaying, o.roff = false, 0
+}
+
+// finishReplay empties the outbox once all messages were sent. On failure
+// the frames are kept, so they may be sent again on the next replay.
+func (o *outbox) finishReplay() error {
+	o.stopReplay()
+	if err := o.f.Truncate(0); err != nil {
+		return err
+	}
+	o.count, o.bytes = 0, 0
+	_, err := o.f.Seek(0, io.SeekStart)
+	return err
+}
+
+// close closes the outbox file, messages not replayed are kept in it.
+func (o *outbox) close() error {
+	if o == nil {
+		return nil
+	}
+	return o.f.Close()
+}
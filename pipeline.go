@@ -0,0 +1,175 @@
+// Copyright 2025 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nuid"
+)
+
+// ErrPipelineClosed is returned when submitting a request to a
+// RequestPipeline that is closed.
+var ErrPipelineClosed = errors.New("nats: request pipeline closed")
+
+// RequestPipeline sends requests whose replies are all received on a single
+// inbox subscription, each request being correlated with its reply by the
+// last token of its reply subject. The number of requests in flight is
+// capped, Submit blocking until a slot is available.
+type RequestPipeline struct {
+	nc      *Conn
+	sub     *Subscription
+	prefix  string
+	timeout time.Duration
+	slots   chan struct{}
+	done    chan struct{}
+
+	mu      sync.Mutex
+	pending map[string]*pipelineReq
+	closed  bool
+}
+
+// pipelineReq is a request of a pipeline waiting for its reply.
+type pipelineReq struct {
+	ch    chan *Msg
+	timer *time.Timer
+}
+
+// NewRequestPipeline creates a RequestPipeline with at most maxInFlight
+// requests waiting for their reply. Requests not replied to within the
+// connection's Timeout option are given up.
+func (nc *Conn) NewRequestPipeline(maxInFlight int) (*RequestPipeline, error) {
+	if nc == nil {
+		return nil, ErrInvalidConnection
+	}
+	if maxInFlight <= 0 {
+		return nil, ErrInvalidArg
+	}
+	p := &RequestPipeline{
+		nc:      nc,
+		prefix:  nc.NewInbox() + ".",
+		timeout: nc.Opts.Timeout,
+		slots:   make(chan struct{}, maxInFlight),
+		done:    make(chan struct{}),
+		pending: make(map[string]*pipelineReq),
+	}
+	sub, err := nc.subscribe(p.prefix+"*", _EMPTY_, p.processReply, nil, nil, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	sub.markInternal()
+	p.sub = sub
+	return p, nil
+}
+
+// Submit sends a request and returns the channel its reply is delivered
+// to, which is then closed. The channel is closed without a reply if none
+// is received in time or if the pipeline is closed. If there are no
+// responders, the reply is the status message sent by the server, see
+// ErrNoResponders. Submit blocks while the maximum number of requests is
+// in flight.
+func (p *RequestPipeline) Submit(subj string, data []byte) (<-chan *Msg, error) {
+	if p == nil {
+		return nil, ErrInvalidConnection
+	}
+	select {
+	case p.slots <- struct{}{}:
+	case <-p.done:
+		return nil, ErrPipelineClosed
+	}
+	token := nuid.Next()
+	req := &pipelineReq{ch: make(chan *Msg, 1)}
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		<-p.slots
+		return nil, ErrPipelineClosed
+	}
+	p.pending[token] = req
+	if p.timeout > 0 {
+		req.timer = time.AfterFunc(p.timeout, func() { p.complete(token, nil) })
+	}
+	p.mu.Unlock()
+
+	if err := p.nc.PublishRequest(subj, p.prefix+token, data); err != nil {
+		p.complete(token, nil)
+		return nil, err
+	}
+	return req.ch, nil
+}
+
+// InFlight returns the number of requests waiting for their reply.
+func (p *RequestPipeline) InFlight() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.pending)
+}
+
+// Close unsubscribes the inbox subscription of the pipeline and closes the
+// channels of the requests still waiting for a reply.
+func (p *RequestPipeline) Close() error {
+	if p == nil {
+		return ErrInvalidConnection
+	}
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+	pending := p.pending
+	p.pending = make(map[string]*pipelineReq)
+	p.mu.Unlock()
+
+	for _, req := range pending {
+		if req.timer != nil {
+			req.timer.Stop()
+		}
+		close(req.ch)
+	}
+	err := p.sub.Unsubscribe()
+	if errors.Is(err, ErrConnectionClosed) || errors.Is(err, ErrBadSubscription) {
+		err = nil
+	}
+	return err
+}
+
+func (p *RequestPipeline) processReply(m *Msg) {
+	p.complete(m.Subject[len(p.prefix):], m)
+}
+
+// complete delivers the reply, if any, of the request with the given token
+// and releases its slot. Late or unknown replies are ignored.
+func (p *RequestPipeline) complete(token string, m *Msg) {
+	p.mu.Lock()
+	req, ok := p.pending[token]
+	if ok {
+		delete(p.pending, token)
+	}
+	p.mu.Unlock()
+	if !ok {
+		return
+	}
+	if req.timer != nil {
+		req.timer.Stop()
+	}
+	if m != nil {
+		req.ch <- m
+	}
+	close(req.ch)
+	<-p.slots
+}
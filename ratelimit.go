@@ -0,0 +1,114 @@
+// Copyright 2025 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket pacing events to a given rate. The bucket
+// holds a tenth of a second worth of events, at least one, so that short
+// bursts are not delayed. Waiters reserve their token ahead of time, so
+// they are served in order.
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	burst    float64
+	tokens   float64
+	last     time.Time
+}
+
+func newRateLimiter(perSec int) *rateLimiter {
+	burst := float64(max(1, perSec/10))
+	return &rateLimiter{
+		interval: time.Second / time.Duration(perSec),
+		burst:    burst,
+		tokens:   burst,
+		last:     time.Now(),
+	}
+}
+
+// wait blocks until a token is available. It returns false if done is
+// closed before that, giving the reserved token back.
+func (r *rateLimiter) wait(done <-chan struct{}) bool {
+	r.mu.Lock()
+	now := time.Now()
+	r.tokens = min(r.burst, r.tokens+float64(now.Sub(r.last))/float64(r.interval))
+	r.last = now
+	r.tokens--
+	d := time.Duration(-r.tokens * float64(r.interval))
+	r.mu.Unlock()
+	if d <= 0 {
+		return true
+	}
+	t := globalTimerPool.Get(d)
+	defer globalTimerPool.Put(t)
+	select {
+	case <-t.C:
+		return true
+	case <-done:
+		r.mu.Lock()
+		r.tokens++
+		r.mu.Unlock()
+		return false
+	}
+}
+
+// InboundRateLimit is an Option to limit the rate, in messages per second,
+// at which messages are delivered to the callbacks of the asynchronous
+// subscriptions of the connection, all subscriptions combined. It is a
+// token bucket allowing bursts of a tenth of a second worth of messages.
+// Messages waiting to be delivered stay in the pending buffers of their
+// subscription, so a subscription receiving messages faster than the rate
+// may become a slow consumer. Synchronous and channel subscriptions are not
+// limited, nor are the ones used internally by the library, such as the
+// request, JetStream publish reply or KeyValue watcher ones. Messages of a
+// subscription closed while waiting are not delivered. Zero means no limit.
+func InboundRateLimit(msgsPerSec int) Option {
+	return func(o *Options) error {
+		if msgsPerSec < 0 {
+			return fmt.Errorf("%w: inbound rate limit can't be negative", ErrInvalidArg)
+		}
+		o.InboundRateLimit = msgsPerSec
+		return nil
+	}
+}
+
+// SetInboundRateLimit changes the rate limit set with the InboundRateLimit
+// option, zero removing the limit.
+func (nc *Conn) SetInboundRateLimit(msgsPerSec int) error {
+	if nc == nil {
+		return ErrInvalidConnection
+	}
+	if msgsPerSec < 0 {
+		return fmt.Errorf("%w: inbound rate limit can't be negative", ErrInvalidArg)
+	}
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	nc.Opts.InboundRateLimit = msgsPerSec
+	nc.setInboundLimiter()
+	return nil
+}
+
+// setInboundLimiter installs the limiter matching Opts.InboundRateLimit.
+// Connection lock is held on entry, or the connection is not yet shared.
+func (nc *Conn) setInboundLimiter() {
+	if nc.Opts.InboundRateLimit > 0 {
+		nc.inLimiter.Store(newRateLimiter(nc.Opts.InboundRateLimit))
+	} else {
+		nc.inLimiter.Store(nil)
+	}
+}
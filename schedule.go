@@ -0,0 +1,127 @@
+// Copyright 2025 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// scheduledPub is a message published by PublishAfter once its timer fires.
+type scheduledPub struct {
+	subj  string
+	data  []byte
+	due   time.Time
+	timer *time.Timer
+}
+
+// FlushScheduledOnClose is an Option to publish, when the connection is
+// closed or drained, the messages scheduled with PublishAfter that are still
+// waiting, instead of discarding them. They are published in the order they
+// were due, provided the connection is connected at that time.
+func FlushScheduledOnClose() Option {
+	return func(o *Options) error {
+		o.FlushScheduledOnClose = true
+		return nil
+	}
+}
+
+// PublishAfter publishes the data argument to the given subject once the
+// delay elapses. The data is copied, so the caller can reuse it. The
+// returned function cancels the publish if it did not happen yet.
+//
+// Timers are client-side only: the message is published with Publish when
+// the timer fires, so it is buffered if the connection is reconnecting at
+// that time. Errors publishing it are reported to the async error handler.
+// Messages still scheduled when the connection is closed or drained are
+// discarded, unless the FlushScheduledOnClose option is set.
+func (nc *Conn) PublishAfter(d time.Duration, subj string, data []byte) (cancel func(), err error) {
+	if nc == nil {
+		return nil, ErrInvalidConnection
+	}
+	if d < 0 {
+		return nil, fmt.Errorf("%w: delay can't be negative", ErrInvalidArg)
+	}
+	if subj == _EMPTY_ {
+		return nil, ErrBadSubject
+	}
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	if nc.isClosed() {
+		return nil, ErrConnectionClosed
+	}
+	if nc.isDraining() {
+		return nil, ErrConnectionDraining
+	}
+	sp := &scheduledPub{subj: subj, data: bytes.Clone(data), due: time.Now().Add(d)}
+	if nc.scheduled == nil {
+		nc.scheduled = make(map[*scheduledPub]struct{})
+	}
+	nc.scheduled[sp] = struct{}{}
+	// The timer can't fire the publish before the lock is released.
+	sp.timer = time.AfterFunc(d, func() { nc.publishScheduled(sp) })
+	return func() { nc.cancelScheduled(sp) }, nil
+}
+
+// publishScheduled publishes the message once its timer fired, unless it
+// was canceled in the meantime.
+func (nc *Conn) publishScheduled(sp *scheduledPub) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	if _, ok := nc.scheduled[sp]; !ok {
+		return
+	}
+	delete(nc.scheduled, sp)
+	err := nc.publishLocked(sp.subj, _EMPTY_, nil, sp.data)
+	if err == nil {
+		if len(nc.fch) == 0 {
+			nc.kickFlusher()
+		}
+	} else if nc.Opts.AsyncErrorCB != nil {
+		nc.ach.push(func() { nc.Opts.AsyncErrorCB(nc, nil, err) })
+	}
+}
+
+func (nc *Conn) cancelScheduled(sp *scheduledPub) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	if _, ok := nc.scheduled[sp]; ok {
+		delete(nc.scheduled, sp)
+		sp.timer.Stop()
+	}
+}
+
+// settleScheduled stops the timers of the scheduled messages and, with the
+// FlushScheduledOnClose option, publishes them right away.
+// Connection lock is held on entry.
+func (nc *Conn) settleScheduled() {
+	if len(nc.scheduled) == 0 {
+		return
+	}
+	pending := make([]*scheduledPub, 0, len(nc.scheduled))
+	for sp := range nc.scheduled {
+		sp.timer.Stop()
+		pending = append(pending, sp)
+	}
+	nc.scheduled = nil
+	if !nc.Opts.FlushScheduledOnClose || !nc.isConnected() {
+		return
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].due.Before(pending[j].due) })
+	for _, sp := range pending {
+		nc.publishLocked(sp.subj, _EMPTY_, nil, sp.data)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2025 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Subject is a validated subject, possibly containing wildcards.
+type Subject string
+
+// NewSubject builds a subject from its tokens. Tokens must not be empty or
+// contain whitespace or dots, and the '*' and '>' wildcards must be whole
+// tokens, '>' being only allowed as the last one. An error wrapping
+// ErrBadSubject is returned otherwise.
+func NewSubject(tokens ...string) (Subject, error) {
+	if len(tokens) == 0 {
+		return _EMPTY_, fmt.Errorf("%w: no tokens", ErrBadSubject)
+	}
+	for i, t := range tokens {
+		if err := validateToken(t, i == len(tokens)-1); err != nil {
+			return _EMPTY_, err
+		}
+	}
+	return Subject(strings.Join(tokens, ".")), nil
+}
+
+// ParseSubject validates a subject, see NewSubject for the rules.
+func ParseSubject(subj string) (Subject, error) {
+	return NewSubject(strings.Split(subj, ".")...)
+}
+
+func validateToken(t string, last bool) error {
+	if t == _EMPTY_ {
+		return fmt.Errorf("%w: empty token", ErrBadSubject)
+	}
+	if strings.ContainsAny(t, " \t\r\n.") {
+		return fmt.Errorf("%w: invalid token %q", ErrBadSubject, t)
+	}
+	if t == ">" && !last {
+		return fmt.Errorf("%w: '>' must be the last token", ErrBadSubject)
+	}
+	if t != "*" && t != ">" && strings.ContainsAny(t, "*>") {
+		return fmt.Errorf("%w: wildcard in token %q", ErrBadSubject, t)
+	}
+	return nil
+}
+
+// validateSubject validates the subject for the ValidateSubjects option,
+// wildcards being allowed or not.
+func validateSubject(subj string, wildcards bool) error {
+	s, err := ParseSubject(subj)
+	if err == nil && !wildcards && s.HasWildcards() {
+		err = fmt.Errorf("%w: wildcards not allowed", ErrBadSubject)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %q: %w", ErrInvalidSubject, subj, err)
+	}
+	return nil
+}
+
+// validatePublishSubjects validates the subject and the reply subject, if
+// any, of a message to publish for the ValidateSubjects option.
+func validatePublishSubjects(subj, reply string) error {
+	if err := validateSubject(subj, false); err != nil {
+		return err
+	}
+	if reply != _EMPTY_ {
+		return validateSubject(reply, false)
+	}
+	return nil
+}
+
+// String returns the subject as a string.
+func (s Subject) String() string {
+	return string(s)
+}
+
+// Tokens returns the tokens of the subject.
+func (s Subject) Tokens() []string {
+	return strings.Split(string(s), ".")
+}
+
+// HasWildcards returns true if the subject contains wildcards.
+func (s Subject) HasWildcards() bool {
+	for _, t := range s.Tokens() {
+		if t == "*" || t == ">" {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches returns true if the concrete subject, which must be valid and
+// without wildcards, is matched by this subject, following the same rules
+// as the server.
+func (s Subject) Matches(concrete string) bool {
+	cs, err := ParseSubject(concrete)
+	if err != nil || cs.HasWildcards() {
+		return false
+	}
+	return subjectMatchesFilter(concrete, string(s))
+}
+
+// PublishSubject publishes the data argument to the given subject.
+func (nc *Conn) PublishSubject(subj Subject, data []byte) error {
+	return nc.Publish(string(subj), data)
+}
+
+// SubscribeSubject will express interest in the given subject, see Subscribe.
+func (nc *Conn) SubscribeSubject(subj Subject, cb MsgHandler) (*Subscription, error) {
+	return nc.Subscribe(string(subj), cb)
+}
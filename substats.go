@@ -0,0 +1,136 @@
+// Copyright 2025 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"container/list"
+	"sync"
+)
+
+// SubjectStatsMaxEntries is the maximum number of subjects tracked with the
+// TrackSubjectStats option. Past it, the least recently used subject is
+// evicted and its counters are lost.
+const SubjectStatsMaxEntries = 1024
+
+// SubjectStat holds the counters of a subject tracked with the
+// TrackSubjectStats option. Bytes include the headers.
+type SubjectStat struct {
+	InMsgs   uint64
+	InBytes  uint64
+	OutMsgs  uint64
+	OutBytes uint64
+}
+
+// TrackSubjectStats is an Option to maintain per subject counters of the
+// messages published and received by the connection, see SubjectStats.
+// This is meant for debugging: every message then costs a map lookup under
+// a lock, and a new subject an allocation. At most SubjectStatsMaxEntries
+// subjects are tracked, so subjects with high cardinality, such as reply
+// inboxes, evict each other.
+func TrackSubjectStats(track bool) Option {
+	return func(o *Options) error {
+		o.TrackSubjectStats = track
+		return nil
+	}
+}
+
+// SubjectStats returns a copy of the per subject counters maintained with
+// the TrackSubjectStats option, or nil if the option is not set. Received
+// messages are counted on the subject they were published to, not the
+// subscription's subject.
+func (nc *Conn) SubjectStats() map[string]SubjectStat {
+	if nc == nil || nc.subjStats == nil {
+		return nil
+	}
+	return nc.subjStats.snapshot()
+}
+
+// subjectStats keeps the counters of the most recently used subjects.
+type subjectStats struct {
+	mu      sync.Mutex
+	max     int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type subjectStatEntry struct {
+	subj string
+	stat SubjectStat
+}
+
+func newSubjectStats() *subjectStats {
+	return &subjectStats{
+		max:     SubjectStatsMaxEntries,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// touch marks the entry as the most recently used and returns its counters.
+// Lock is held on entry.
+func (ss *subjectStats) touch(e *list.Element) *SubjectStat {
+	ss.order.MoveToBack(e)
+	return &e.Value.(*subjectStatEntry).stat
+}
+
+// add tracks a new subject, evicting the least recently used one if needed,
+// and returns its counters.
+// Lock is held on entry.
+func (ss *subjectStats) add(subj string) *SubjectStat {
+	if ss.order.Len() >= ss.max {
+		e := ss.order.Front()
+		ss.order.Remove(e)
+		delete(ss.entries, e.Value.(*subjectStatEntry).subj)
+	}
+	se := &subjectStatEntry{subj: subj}
+	ss.entries[subj] = ss.order.PushBack(se)
+	return &se.stat
+}
+
+func (ss *subjectStats) addIn(subj []byte, bytes int) {
+	ss.mu.Lock()
+	var st *SubjectStat
+	// The conversion does not allocate for the lookup.
+	if e, ok := ss.entries[string(subj)]; ok {
+		st = ss.touch(e)
+	} else {
+		st = ss.add(string(subj))
+	}
+	st.InMsgs++
+	st.InBytes += uint64(bytes)
+	ss.mu.Unlock()
+}
+
+func (ss *subjectStats) addOut(subj string, bytes int) {
+	ss.mu.Lock()
+	var st *SubjectStat
+	if e, ok := ss.entries[subj]; ok {
+		st = ss.touch(e)
+	} else {
+		st = ss.add(subj)
+	}
+	st.OutMsgs++
+	st.OutBytes += uint64(bytes)
+	ss.mu.Unlock()
+}
+
+func (ss *subjectStats) snapshot() map[string]SubjectStat {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	m := make(map[string]SubjectStat, len(ss.entries))
+	for subj, e := range ss.entries {
+		m[subj] = e.Value.(*subjectStatEntry).stat
+	}
+	return m
+}
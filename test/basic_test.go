@@ -16,10 +16,13 @@ package test
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"math"
+	"reflect"
 	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -166,6 +169,51 @@ func TestConnectedServer(t *testing.T) {
 	}
 }
 
+func TestConnFeatures(t *testing.T) {
+	t.Run("default server", func(t *testing.T) {
+		s := RunDefaultServer()
+		defer s.Shutdown()
+
+		nc := NewDefaultConnection(t)
+		defer nc.Close()
+
+		f := nc.Features()
+		if !f.Headers || !f.NoResponders {
+			t.Fatalf("Expected headers and no responders support, got %+v", f)
+		}
+		if f.ServerVersion == "" || f.ServerVersion != nc.ConnectedServerVersion() {
+			t.Fatalf("Unexpected server version %q", f.ServerVersion)
+		}
+		if f.Proto < 1 {
+			t.Fatalf("Expected protocol level of at least 1, got %d", f.Proto)
+		}
+		if f.TLS {
+			t.Fatal("Expected non TLS connection")
+		}
+
+		nc.Close()
+		if f := nc.Features(); f != (nats.ConnFeatures{}) {
+			t.Fatalf("Expected no features once closed, got %+v", f)
+		}
+	})
+
+	t.Run("tls server", func(t *testing.T) {
+		s, opts := RunServerWithConfig("./configs/tls.conf")
+		defer s.Shutdown()
+
+		secureURL := fmt.Sprintf("nats://%s:%s@%s:%d/", opts.Username, opts.Password, opts.Host, opts.Port)
+		nc, err := nats.Connect(secureURL, nats.RootCAs("./configs/certs/ca.pem"))
+		if err != nil {
+			t.Fatalf("Failed to create secure (TLS) connection: %v", err)
+		}
+		defer nc.Close()
+
+		if f := nc.Features(); !f.TLS || !f.Headers || f.ServerVersion == "" {
+			t.Fatalf("Unexpected features %+v", f)
+		}
+	})
+}
+
 func TestMultipleClose(t *testing.T) {
 	s := RunDefaultServer()
 	defer s.Shutdown()
@@ -221,6 +269,69 @@ func TestSimplePublishNoData(t *testing.T) {
 	}
 }
 
+func TestPublishMsgBatch(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	sub, err := nc.SubscribeSync("foo.*")
+	if err != nil {
+		t.Fatalf("Error subscribing: %v", err)
+	}
+
+	msgs := make([]*nats.Msg, 0, 100)
+	for i := 0; i < 100; i++ {
+		m := nats.NewMsg(fmt.Sprintf("foo.%d", i))
+		m.Data = []byte("hello")
+		if i%2 == 0 {
+			m.Header.Set("Index", fmt.Sprintf("%d", i))
+		}
+		msgs = append(msgs, m)
+	}
+	if err := nc.PublishMsgBatch(msgs); err != nil {
+		t.Fatalf("Error publishing batch: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		m, err := sub.NextMsg(time.Second)
+		if err != nil {
+			t.Fatalf("Error getting message %d: %v", i, err)
+		}
+		if m.Subject != fmt.Sprintf("foo.%d", i) {
+			t.Fatalf("Unexpected subject %q at %d", m.Subject, i)
+		}
+		if i%2 == 0 && m.Header.Get("Index") != fmt.Sprintf("%d", i) {
+			t.Fatalf("Unexpected header %q at %d", m.Header.Get("Index"), i)
+		}
+	}
+
+	// First invalid message stops the batch, previous ones are sent.
+	msgs = []*nats.Msg{
+		{Subject: "foo.a", Data: []byte("ok")},
+		{Subject: "foo.b", Data: []byte("ok")},
+		{Subject: "", Data: []byte("bad")},
+		{Subject: "foo.c", Data: []byte("not sent")},
+	}
+	err = nc.PublishMsgBatch(msgs)
+	var batchErr *nats.PublishBatchError
+	if !errors.As(err, &batchErr) || batchErr.Index != 2 || !errors.Is(err, nats.ErrBadSubject) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, subj := range []string{"foo.a", "foo.b"} {
+		if m, err := sub.NextMsg(time.Second); err != nil || m.Subject != subj {
+			t.Fatalf("Expected message on %q, got %v - %v", subj, m, err)
+		}
+	}
+	if _, err := sub.NextMsg(100 * time.Millisecond); err != nats.ErrTimeout {
+		t.Fatalf("Expected no more messages, got %v", err)
+	}
+
+	err = nc.PublishMsgBatch([]*nats.Msg{nil})
+	if !errors.As(err, &batchErr) || batchErr.Index != 0 || !errors.Is(err, nats.ErrInvalidMsg) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
 func TestPublishDoesNotFailOnSlowConsumer(t *testing.T) {
 	s := RunDefaultServer()
 	defer s.Shutdown()
@@ -427,6 +538,41 @@ func TestMsgRespond(t *testing.T) {
 	}
 }
 
+func TestMsgRespondWithHeaders(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	m := &nats.Msg{}
+	if err := m.RespondWithHeaders(nil, nil); err != nats.ErrMsgNotBound {
+		t.Fatal("Expected ErrMsgNotBound error")
+	}
+
+	sub, err := nc.Subscribe("req", func(msg *nats.Msg) {
+		msg.RespondWithHeaders([]byte("42"), nats.Header{"Status": []string{"ok"}, "Multi": []string{"a", "b"}})
+	})
+	if err != nil {
+		t.Fatal("Failed to subscribe: ", err)
+	}
+
+	m.Sub = sub
+	if err := m.RespondWithHeaders(nil, nil); err != nats.ErrMsgNoReply {
+		t.Fatal("Expected ErrMsgNoReply error")
+	}
+
+	response, err := nc.Request("req", []byte("help"), time.Second)
+	if err != nil {
+		t.Fatal("Request Failed: ", err)
+	}
+	if string(response.Data) != "42" {
+		t.Fatalf("Expected '42', got %q", response.Data)
+	}
+	if response.Header.Get("Status") != "ok" || strings.Join(response.Header.Values("Multi"), ",") != "a,b" {
+		t.Fatalf("Unexpected headers: %v", response.Header)
+	}
+}
+
 func TestFlush(t *testing.T) {
 	s := RunDefaultServer()
 	defer s.Shutdown()
@@ -453,6 +599,46 @@ func TestFlush(t *testing.T) {
 	}
 }
 
+func TestFlushWithStats(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	if _, err := nc.FlushWithStats(0); err != nats.ErrBadTimeout {
+		t.Fatalf("Expected %v, got %v", nats.ErrBadTimeout, err)
+	}
+
+	omsg := []byte("Hello World")
+	// PUB flush 11\r\nHello World\r\n
+	protoLen := len("PUB flush 11\r\n") + len(omsg) + 2
+	total := 10000
+	for i := 0; i < total; i++ {
+		nc.Publish("flush", omsg)
+	}
+	// The flusher may have written some of it already.
+	flushed, err := nc.FlushWithStats(time.Second)
+	if err != nil {
+		t.Fatalf("Received error from flush: %s\n", err)
+	}
+	if flushed < 0 || flushed > total*protoLen {
+		t.Fatalf("Unexpected flushed bytes: %d", flushed)
+	}
+	if nb, _ := nc.Buffered(); nb > 0 {
+		t.Fatalf("Outbound buffer not empty: %d bytes\n", nb)
+	}
+
+	// Nothing buffered.
+	if flushed, err := nc.FlushWithStats(time.Second); err != nil || flushed != 0 {
+		t.Fatalf("Expected nothing flushed, got %d - %v", flushed, err)
+	}
+
+	nc.Close()
+	if _, err := nc.FlushWithStats(time.Second); err != nats.ErrConnectionClosed {
+		t.Fatalf("Expected %v, got %v", nats.ErrConnectionClosed, err)
+	}
+}
+
 func TestQueueSubscriber(t *testing.T) {
 	s := RunDefaultServer()
 	defer s.Shutdown()
@@ -652,6 +838,139 @@ func TestBasicNoRespondersSupport(t *testing.T) {
 	}
 }
 
+func TestRequestError(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	for _, test := range []struct {
+		name     string
+		oldStyle bool
+	}{
+		{"new request style", false},
+		{"old request style", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			nc.Opts.UseOldRequestStyle = test.oldStyle
+
+			// Without the option, errors are not wrapped.
+			if _, err := nc.Request("foo", nil, time.Second); err != nats.ErrNoResponders {
+				t.Fatalf("Expected %v, got %v", nats.ErrNoResponders, err)
+			}
+			if _, err := nc.RequestWithOpts("foo", nil); err != nats.ErrNoResponders {
+				t.Fatalf("Expected %v, got %v", nats.ErrNoResponders, err)
+			}
+
+			// No subscriber, the server reports no responders.
+			_, err := nc.RequestWithOpts("foo", nil, nats.TypedRequestErrors())
+			var reqErr *nats.RequestError
+			if !errors.As(err, &reqErr) {
+				t.Fatalf("Expected a RequestError, got %v", err)
+			}
+			if !reqErr.NoResponders || reqErr.Subject != "foo" || !errors.Is(err, nats.ErrNoResponders) {
+				t.Fatalf("Unexpected error: %+v", reqErr)
+			}
+
+			// Slow subscriber, the request times out.
+			sub, err := nc.Subscribe("bar", func(m *nats.Msg) {
+				time.Sleep(100 * time.Millisecond)
+				m.Respond(nil)
+			})
+			if err != nil {
+				t.Fatalf("Error on subscribe: %v", err)
+			}
+			defer sub.Unsubscribe()
+			_, err = nc.RequestWithOpts("bar", nil, nats.TypedRequestErrors(), nats.RequestTimeout(10*time.Millisecond))
+			if !errors.As(err, &reqErr) {
+				t.Fatalf("Expected a RequestError, got %v", err)
+			}
+			if reqErr.NoResponders || reqErr.Subject != "bar" || !errors.Is(err, nats.ErrTimeout) {
+				t.Fatalf("Unexpected error: %+v", reqErr)
+			}
+			if err.Error() != nats.ErrTimeout.Error() {
+				t.Fatalf("Unexpected error message: %q", err)
+			}
+		})
+	}
+}
+
+func TestPublishAndWaitAck(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	received := make(chan []byte, 1)
+	sub, err := nc.Subscribe("work", func(m *nats.Msg) {
+		received <- m.Data
+		if err := m.Ack(); err != nil {
+			t.Errorf("Error on ack: %v", err)
+		}
+	})
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	if err := nc.PublishAndWaitAck("work", []byte("job"), time.Second); err != nil {
+		t.Fatalf("Error waiting for ack: %v", err)
+	}
+	if data := <-received; string(data) != "job" {
+		t.Fatalf("Unexpected data: %q", data)
+	}
+
+	if err := nc.PublishAndWaitAck("nobody", nil, time.Second); !errors.Is(err, nats.ErrNoResponders) {
+		t.Fatalf("Expected %v, got %v", nats.ErrNoResponders, err)
+	}
+
+	sub.Unsubscribe()
+	// A worker that does not acknowledge.
+	nc.Subscribe("work", func(_ *nats.Msg) {})
+	if err := nc.PublishAndWaitAck("work", nil, 50*time.Millisecond); !errors.Is(err, nats.ErrTimeout) {
+		t.Fatalf("Expected %v, got %v", nats.ErrTimeout, err)
+	}
+}
+
+func TestPublishSync(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	sub, err := nc.SubscribeSync("foo")
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := nc.PublishSync("foo", []byte(fmt.Sprint(i))); err != nil {
+			t.Fatalf("Error on publish: %v", err)
+		}
+		// The message is received before the flush completes.
+		m, err := sub.TryNextMsg()
+		if err != nil {
+			t.Fatalf("Error on next msg: %v", err)
+		}
+		if string(m.Data) != fmt.Sprint(i) {
+			t.Fatalf("Expected message %d, got %q", i, m.Data)
+		}
+	}
+
+	if err := nc.PublishSync("", nil); err != nats.ErrBadSubject {
+		t.Fatalf("Expected %v, got %v", nats.ErrBadSubject, err)
+	}
+	nc.Close()
+	if err := nc.PublishSync("foo", nil); err != nats.ErrConnectionClosed {
+		t.Fatalf("Expected %v, got %v", nats.ErrConnectionClosed, err)
+	}
+}
+
 func TestOldRequest(t *testing.T) {
 	s := RunDefaultServer()
 	defer s.Shutdown()
@@ -757,101 +1076,894 @@ func TestSimultaneousRequests(t *testing.T) {
 	checkErrChannel(t, errCh)
 }
 
-func TestRequestClose(t *testing.T) {
+func TestRequestWithOpts(t *testing.T) {
 	s := RunDefaultServer()
 	defer s.Shutdown()
-
-	nc := NewDefaultConnection(t)
-	defer nc.Close()
-
-	wg := &sync.WaitGroup{}
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		time.Sleep(100 * time.Millisecond)
-		nc.Close()
-	}()
-	nc.SubscribeSync("foo")
-	if _, err := nc.Request("foo", []byte("help"), 2*time.Second); err != nats.ErrInvalidConnection && err != nats.ErrConnectionClosed {
-		t.Fatalf("Expected connection error: got %v", err)
+	nc, err := nats.Connect(nats.DefaultURL, nats.CustomInboxPrefix("_CONN"))
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
 	}
-	wg.Wait()
-}
-
-func TestRequestCloseTimeout(t *testing.T) {
-	// Make sure we return a timeout when we close
-	// the connection even if response is queued.
-
-	s := RunDefaultServer()
-	defer s.Shutdown()
-
-	nc := NewDefaultConnection(t)
 	defer nc.Close()
 
+	replies := make(chan string, 10)
 	response := []byte("I will help you")
 	nc.Subscribe("foo", func(m *nats.Msg) {
-		nc.Publish(m.Reply, response)
-		nc.Close()
+		replies <- m.Reply
+		m.Respond(response)
 	})
-	if _, err := nc.Request("foo", nil, 1*time.Second); err == nil {
-		t.Fatalf("Expected to receive a timeout error")
+
+	msg, err := nc.RequestWithOpts("foo", []byte("help"),
+		nats.RequestInboxPrefix("_TENANT.acme"), nats.RequestTimeout(time.Second))
+	if err != nil {
+		t.Fatalf("Received an error on request: %v", err)
+	}
+	if !bytes.Equal(msg.Data, response) {
+		t.Fatalf("Received invalid response")
+	}
+	if reply := <-replies; !strings.HasPrefix(reply, "_TENANT.acme.") {
+		t.Fatalf("Expected reply with tenant prefix, got %q", reply)
 	}
-}
 
-func TestFlushInCB(t *testing.T) {
-	s := RunDefaultServer()
-	defer s.Shutdown()
-	nc := NewDefaultConnection(t)
-	defer nc.Close()
+	// Inboxes are unique.
+	nc.RequestWithOpts("foo", nil, nats.RequestInboxPrefix("_TENANT.acme"))
+	r1 := <-replies
+	nc.RequestWithOpts("foo", nil, nats.RequestInboxPrefix("_TENANT.acme"))
+	r2 := <-replies
+	if r1 == r2 {
+		t.Fatalf("Expected unique inboxes, got %q twice", r1)
+	}
 
-	ch := make(chan bool)
+	// Without a prefix the connection's one is used.
+	if _, err := nc.RequestWithOpts("foo", nil); err != nil {
+		t.Fatalf("Received an error on request: %v", err)
+	}
+	if reply := <-replies; !strings.HasPrefix(reply, "_CONN.") {
+		t.Fatalf("Expected reply with connection prefix, got %q", reply)
+	}
 
-	nc.Subscribe("foo", func(_ *nats.Msg) {
-		nc.Flush()
-		ch <- true
-	})
-	nc.Publish("foo", []byte("Hello"))
-	if e := Wait(ch); e != nil {
-		t.Fatal("Flush did not return properly in callback")
+	if _, err := nc.RequestWithOpts("bar", nil, nats.RequestInboxPrefix("_TENANT.acme")); err != nats.ErrNoResponders {
+		t.Fatalf("Expected %v, got %v", nats.ErrNoResponders, err)
+	}
+	for _, p := range []string{"", "_TENANT.*", "_TENANT.>", "_TENANT."} {
+		if _, err := nc.RequestWithOpts("foo", nil, nats.RequestInboxPrefix(p)); err == nil {
+			t.Fatalf("Expected error for prefix %q", p)
+		}
+	}
+	if _, err := nc.RequestWithOpts("foo", nil, nats.RequestTimeout(0)); err != nats.ErrBadTimeout {
+		t.Fatalf("Expected %v, got %v", nats.ErrBadTimeout, err)
 	}
 }
 
-func TestReleaseFlush(t *testing.T) {
+func TestRequestWithReplyInbox(t *testing.T) {
 	s := RunDefaultServer()
 	defer s.Shutdown()
+
 	nc := NewDefaultConnection(t)
+	defer nc.Close()
 
-	for i := 0; i < 1000; i++ {
-		nc.Publish("foo", []byte("Hello"))
+	replies := make(chan string, 1)
+	nc.Subscribe("foo", func(m *nats.Msg) {
+		replies <- m.Reply
+		m.Respond([]byte("reply"))
+	})
+
+	inbox := nc.NewRespInbox()
+	mine, err := nc.SubscribeSync(inbox)
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
 	}
-	go nc.Close()
-	nc.Flush()
-}
 
-func TestInbox(t *testing.T) {
-	inbox := nats.NewInbox()
-	if matched, _ := regexp.Match(`_INBOX.\S`, []byte(inbox)); !matched {
-		t.Fatal("Bad INBOX format")
+	msg, err := nc.RequestWithOpts("foo", []byte("help"), nats.WithReplyInbox(inbox))
+	if err != nil {
+		t.Fatalf("Error on request: %v", err)
+	}
+	if string(msg.Data) != "reply" || msg.Subject != inbox {
+		t.Fatalf("Unexpected response %q on %q", msg.Data, msg.Subject)
+	}
+	if reply := <-replies; reply != inbox {
+		t.Fatalf("Expected reply subject %q, got %q", inbox, reply)
+	}
+	// The response landed on the application's subscription as well.
+	if m, err := mine.NextMsg(time.Second); err != nil || string(m.Data) != "reply" {
+		t.Fatalf("Expected the response on the custom inbox, got %v, %v", m, err)
+	}
+
+	for _, subj := range []string{"", "bad.*", "bad..inbox"} {
+		if _, err := nc.RequestWithOpts("foo", nil, nats.WithReplyInbox(subj)); !errors.Is(err, nats.ErrBadSubject) {
+			t.Fatalf("Expected %v for %q, got %v", nats.ErrBadSubject, subj, err)
+		}
+	}
+	_, err = nc.RequestWithOpts("foo", nil, nats.WithReplyInbox(inbox), nats.RequestInboxPrefix("_TENANT"))
+	if !errors.Is(err, nats.ErrInvalidArg) {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
 	}
 }
 
-func TestStats(t *testing.T) {
+func TestRequestWithRetry(t *testing.T) {
 	s := RunDefaultServer()
 	defer s.Shutdown()
+
 	nc := NewDefaultConnection(t)
 	defer nc.Close()
 
-	data := []byte("The quick brown fox jumped over the lazy dog")
-	iter := 10
-
-	for i := 0; i < iter; i++ {
-		nc.Publish("foo", data)
-	}
+	t.Run("attempt header", func(t *testing.T) {
+		sub, err := nc.SubscribeSync("attempt")
+		if err != nil {
+			t.Fatalf("Error on subscribe: %v", err)
+		}
+		defer sub.Unsubscribe()
 
-	if nc.OutMsgs != uint64(iter) {
-		t.Fatalf("Not properly tracking OutMsgs: received %d, wanted %d\n", nc.OutMsgs, iter)
-	}
-	obb := uint64(iter * len(data))
+		if err := nc.PublishMsgWithOpts(nats.NewMsg("attempt"), nats.WithAttempt(3)); err != nil {
+			t.Fatalf("Error on publish: %v", err)
+		}
+		if err := nc.Publish("attempt", []byte("first")); err != nil {
+			t.Fatalf("Error on publish: %v", err)
+		}
+		for _, expected := range []int{3, 1} {
+			m, err := sub.NextMsg(time.Second)
+			if err != nil {
+				t.Fatalf("Error getting message: %v", err)
+			}
+			if n := m.Attempt(); n != expected {
+				t.Fatalf("Expected attempt %d, got %d", expected, n)
+			}
+		}
+		if err := nc.PublishMsgWithOpts(nats.NewMsg("attempt"), nats.WithAttempt(0)); !errors.Is(err, nats.ErrInvalidArg) {
+			t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+		}
+	})
+
+	t.Run("retry on timeout", func(t *testing.T) {
+		var mu sync.Mutex
+		var attempts []int
+		sub, err := nc.Subscribe("retry", func(m *nats.Msg) {
+			mu.Lock()
+			attempts = append(attempts, m.Attempt())
+			mu.Unlock()
+			// Let the first attempts time out.
+			if m.Attempt() == 3 {
+				m.Respond([]byte("ok"))
+			}
+		})
+		if err != nil {
+			t.Fatalf("Error on subscribe: %v", err)
+		}
+		defer sub.Unsubscribe()
+
+		resp, err := nc.RequestWithRetry("retry", []byte("req"), 5, 100*time.Millisecond)
+		if err != nil {
+			t.Fatalf("Error on request: %v", err)
+		}
+		if string(resp.Data) != "ok" {
+			t.Fatalf("Unexpected response %q", resp.Data)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if !reflect.DeepEqual(attempts, []int{1, 2, 3}) {
+			t.Fatalf("Unexpected attempts %v", attempts)
+		}
+	})
+
+	t.Run("retry on no responders", func(t *testing.T) {
+		errCh := make(chan error, 1)
+		time.AfterFunc(150*time.Millisecond, func() {
+			_, err := nc.Subscribe("late", func(m *nats.Msg) {
+				m.Respond([]byte(strconv.Itoa(m.Attempt())))
+			})
+			errCh <- err
+		})
+		resp, err := nc.RequestWithRetry("late", nil, 10, 100*time.Millisecond)
+		if err != nil {
+			t.Fatalf("Error on request: %v", err)
+		}
+		if err := <-errCh; err != nil {
+			t.Fatalf("Error on subscribe: %v", err)
+		}
+		if n, _ := strconv.Atoi(string(resp.Data)); n < 2 {
+			t.Fatalf("Expected response to a later attempt, got %q", resp.Data)
+		}
+	})
+
+	t.Run("last error returned", func(t *testing.T) {
+		start := time.Now()
+		_, err := nc.RequestWithRetry("none", nil, 3, 50*time.Millisecond)
+		if !errors.Is(err, nats.ErrNoResponders) {
+			t.Fatalf("Expected %v, got %v", nats.ErrNoResponders, err)
+		}
+		if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+			t.Fatalf("Expected attempts to be spread over time, took %v", elapsed)
+		}
+
+		sub, err := nc.SubscribeSync("slow")
+		if err != nil {
+			t.Fatalf("Error on subscribe: %v", err)
+		}
+		defer sub.Unsubscribe()
+		_, err = nc.RequestWithRetry("slow", nil, 2, 50*time.Millisecond)
+		if !errors.Is(err, nats.ErrTimeout) {
+			t.Fatalf("Expected %v, got %v", nats.ErrTimeout, err)
+		}
+		if _, err := sub.NextMsg(time.Second); err != nil {
+			t.Fatalf("Error getting first attempt: %v", err)
+		}
+		m, err := sub.NextMsg(time.Second)
+		if err != nil {
+			t.Fatalf("Error getting second attempt: %v", err)
+		}
+		if m.Attempt() != 2 {
+			t.Fatalf("Expected attempt 2, got %d", m.Attempt())
+		}
+	})
+
+	t.Run("invalid or fatal", func(t *testing.T) {
+		if _, err := nc.RequestWithRetry("foo", nil, 0, time.Second); !errors.Is(err, nats.ErrInvalidArg) {
+			t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+		}
+		nc2 := NewDefaultConnection(t)
+		nc2.Close()
+		start := time.Now()
+		if _, err := nc2.RequestWithRetry("foo", nil, 5, time.Second); !errors.Is(err, nats.ErrConnectionClosed) {
+			t.Fatalf("Expected %v, got %v", nats.ErrConnectionClosed, err)
+		}
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Fatalf("Expected no retry, took %v", elapsed)
+		}
+	})
+}
+
+func TestPublishAfter(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	checkNoMsg := func(t *testing.T, sub *nats.Subscription, wait time.Duration) {
+		t.Helper()
+		if m, err := sub.NextMsg(wait); err != nats.ErrTimeout {
+			t.Fatalf("Expected no message, got %v, %v", m, err)
+		}
+	}
+
+	t.Run("publish after delay", func(t *testing.T) {
+		sub, err := nc.SubscribeSync("delayed")
+		if err != nil {
+			t.Fatalf("Error on subscribe: %v", err)
+		}
+		defer sub.Unsubscribe()
+
+		data := []byte("hello")
+		start := time.Now()
+		if _, err := nc.PublishAfter(200*time.Millisecond, "delayed", data); err != nil {
+			t.Fatalf("Error scheduling publish: %v", err)
+		}
+		// The data is copied.
+		copy(data, "HELLO")
+		checkNoMsg(t, sub, 100*time.Millisecond)
+		m, err := sub.NextMsg(time.Second)
+		if err != nil {
+			t.Fatalf("Error getting message: %v", err)
+		}
+		if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+			t.Fatalf("Message published too early, after %v", elapsed)
+		}
+		if string(m.Data) != "hello" {
+			t.Fatalf("Unexpected message %q", m.Data)
+		}
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		sub, err := nc.SubscribeSync("canceled")
+		if err != nil {
+			t.Fatalf("Error on subscribe: %v", err)
+		}
+		defer sub.Unsubscribe()
+
+		cancel, err := nc.PublishAfter(100*time.Millisecond, "canceled", []byte("hello"))
+		if err != nil {
+			t.Fatalf("Error scheduling publish: %v", err)
+		}
+		cancel()
+		checkNoMsg(t, sub, 300*time.Millisecond)
+		// Canceling again, or after the publish, is a no-op.
+		cancel()
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		if _, err := nc.PublishAfter(-time.Second, "foo", nil); !errors.Is(err, nats.ErrInvalidArg) {
+			t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+		}
+		if _, err := nc.PublishAfter(time.Second, "", nil); err != nats.ErrBadSubject {
+			t.Fatalf("Expected %v, got %v", nats.ErrBadSubject, err)
+		}
+	})
+
+	t.Run("close", func(t *testing.T) {
+		for _, flush := range []bool{false, true} {
+			for _, drain := range []bool{false, true} {
+				sub, err := nc.SubscribeSync("closing")
+				if err != nil {
+					t.Fatalf("Error on subscribe: %v", err)
+				}
+				var opts []nats.Option
+				if flush {
+					opts = append(opts, nats.FlushScheduledOnClose())
+				}
+				closed := make(chan struct{})
+				opts = append(opts, nats.ClosedHandler(func(*nats.Conn) { close(closed) }))
+				nc2, err := nats.Connect(nats.DefaultURL, opts...)
+				if err != nil {
+					t.Fatalf("Error on connect: %v", err)
+				}
+				for _, d := range []time.Duration{time.Hour, time.Minute} {
+					if _, err := nc2.PublishAfter(d, "closing", []byte(d.String())); err != nil {
+						t.Fatalf("Error scheduling publish: %v", err)
+					}
+				}
+				if drain {
+					nc2.Drain()
+				} else {
+					nc2.Close()
+				}
+				WaitOnChannel(t, closed, struct{}{})
+				if _, err := nc2.PublishAfter(time.Second, "closing", nil); err != nats.ErrConnectionClosed {
+					t.Fatalf("Expected %v, got %v", nats.ErrConnectionClosed, err)
+				}
+				if !flush {
+					checkNoMsg(t, sub, 100*time.Millisecond)
+					sub.Unsubscribe()
+					continue
+				}
+				// Published in the order they were due.
+				for _, expected := range []string{"1m0s", "1h0m0s"} {
+					m, err := sub.NextMsg(time.Second)
+					if err != nil {
+						t.Fatalf("Error getting message: %v", err)
+					}
+					if string(m.Data) != expected {
+						t.Fatalf("Expected %q, got %q", expected, m.Data)
+					}
+				}
+				sub.Unsubscribe()
+			}
+		}
+	})
+}
+
+func TestPublishAfterReconnect(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	reconnected := make(chan struct{}, 1)
+	nc, err := nats.Connect(nats.DefaultURL,
+		nats.ReconnectWait(50*time.Millisecond),
+		nats.ReconnectHandler(func(*nats.Conn) { reconnected <- struct{}{} }))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer nc.Close()
+
+	sub, err := nc.SubscribeSync("delayed")
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	nc.Flush()
+	if _, err := nc.PublishAfter(200*time.Millisecond, "delayed", []byte("hello")); err != nil {
+		t.Fatalf("Error scheduling publish: %v", err)
+	}
+
+	// The timer fires while disconnected, the message is buffered.
+	s.Shutdown()
+	time.Sleep(400 * time.Millisecond)
+	s = RunDefaultServer()
+	defer s.Shutdown()
+	WaitOnChannel(t, reconnected, struct{}{})
+
+	if _, err := sub.NextMsg(2 * time.Second); err != nil {
+		t.Fatalf("Error getting message: %v", err)
+	}
+}
+
+func TestRequestPipeline(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	if _, err := nc.NewRequestPipeline(0); err != nats.ErrInvalidArg {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+	}
+
+	t.Run("correlation", func(t *testing.T) {
+		// Replies are sent out of order by concurrent callbacks.
+		sub, err := nc.SubscribeWithOpts("echo", func(m *nats.Msg) {
+			time.Sleep(time.Duration(len(m.Data)%5) * time.Millisecond)
+			m.Respond(m.Data)
+		}, nats.SubscribeConcurrency(8))
+		if err != nil {
+			t.Fatalf("Error on subscribe: %v", err)
+		}
+		defer sub.Unsubscribe()
+
+		p, err := nc.NewRequestPipeline(16)
+		if err != nil {
+			t.Fatalf("Error creating pipeline: %v", err)
+		}
+		defer p.Close()
+
+		var wg sync.WaitGroup
+		errCh := make(chan error, 10)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 50; j++ {
+					data := strings.Repeat("x", j) + fmt.Sprintf("-%d-%d", i, j)
+					ch, err := p.Submit("echo", []byte(data))
+					if err != nil {
+						errCh <- err
+						return
+					}
+					m, ok := <-ch
+					if !ok {
+						errCh <- fmt.Errorf("no reply for %q", data)
+						return
+					}
+					if string(m.Data) != data {
+						errCh <- fmt.Errorf("expected reply %q, got %q", data, m.Data)
+						return
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+		checkErrChannel(t, errCh)
+		if n := p.InFlight(); n != 0 {
+			t.Fatalf("Expected no request in flight, got %d", n)
+		}
+	})
+
+	t.Run("max in flight and close", func(t *testing.T) {
+		// Requests are replied to one at a time by the test.
+		responder, err := nc.SubscribeSync("slow")
+		if err != nil {
+			t.Fatalf("Error on subscribe: %v", err)
+		}
+		defer responder.Unsubscribe()
+		respond := func() {
+			t.Helper()
+			m, err := responder.NextMsg(time.Second)
+			if err != nil {
+				t.Fatalf("Error receiving request: %v", err)
+			}
+			m.Respond([]byte("ok"))
+		}
+
+		p, err := nc.NewRequestPipeline(2)
+		if err != nil {
+			t.Fatalf("Error creating pipeline: %v", err)
+		}
+		first, _ := p.Submit("slow", nil)
+		second, err := p.Submit("slow", nil)
+		if err != nil {
+			t.Fatalf("Error on submit: %v", err)
+		}
+		submitted := make(chan error, 1)
+		go func() {
+			_, err := p.Submit("slow", nil)
+			submitted <- err
+		}()
+		select {
+		case <-submitted:
+			t.Fatal("Submit should block while the maximum is in flight")
+		case <-time.After(100 * time.Millisecond):
+		}
+		if n := p.InFlight(); n != 2 {
+			t.Fatalf("Expected 2 requests in flight, got %d", n)
+		}
+
+		// A reply frees a slot.
+		respond()
+		if m := <-first; m == nil || string(m.Data) != "ok" {
+			t.Fatalf("Unexpected reply %v", m)
+		}
+		if err := <-submitted; err != nil {
+			t.Fatalf("Error on submit: %v", err)
+		}
+
+		// Closing gives up the requests in flight and unblocks Submit.
+		go func() {
+			_, err := p.Submit("slow", nil)
+			submitted <- err
+		}()
+		time.Sleep(50 * time.Millisecond)
+		if err := p.Close(); err != nil {
+			t.Fatalf("Error on close: %v", err)
+		}
+		if err := <-submitted; err != nats.ErrPipelineClosed {
+			t.Fatalf("Expected %v, got %v", nats.ErrPipelineClosed, err)
+		}
+		if _, err := p.Submit("slow", nil); err != nats.ErrPipelineClosed {
+			t.Fatalf("Expected %v, got %v", nats.ErrPipelineClosed, err)
+		}
+		if m, ok := <-second; ok {
+			t.Fatalf("Expected channel to be closed without reply, got %v", m)
+		}
+		if n := p.InFlight(); n != 0 {
+			t.Fatalf("Expected no request in flight, got %d", n)
+		}
+	})
+}
+
+func TestRequestParseServiceErrors(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	nc.Subscribe("svc", func(m *nats.Msg) {
+		resp := nats.NewMsg(m.Reply)
+		if string(m.Data) == "fail" {
+			resp.Header.Set(nats.ServiceErrorHdr, "bad request")
+			resp.Header.Set(nats.ServiceErrorCodeHdr, "400")
+		}
+		resp.Data = []byte("body")
+		m.RespondMsg(resp)
+	})
+
+	// Default behavior is unchanged.
+	msg, err := nc.RequestWithOpts("svc", []byte("fail"))
+	if err != nil || msg.Header.Get(nats.ServiceErrorHdr) != "bad request" {
+		t.Fatalf("Unexpected response %v, %v", msg, err)
+	}
+
+	msg, err = nc.RequestWithOpts("svc", []byte("fail"), nats.ParseServiceErrors())
+	var svcErr *nats.ServiceError
+	if !errors.As(err, &svcErr) {
+		t.Fatalf("Expected a ServiceError, got %v", err)
+	}
+	if svcErr.Code != 400 || svcErr.Description != "bad request" {
+		t.Fatalf("Unexpected service error: %+v", svcErr)
+	}
+	if msg == nil || string(msg.Data) != "body" {
+		t.Fatalf("Expected the response along with the error, got %v", msg)
+	}
+
+	msg, err = nc.RequestWithOpts("svc", []byte("ok"), nats.ParseServiceErrors(), nats.RequestInboxPrefix("_TENANT"))
+	if err != nil || string(msg.Data) != "body" {
+		t.Fatalf("Unexpected response %v, %v", msg, err)
+	}
+}
+
+func TestAddEndpoint(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	if _, err := nc.AddEndpoint("svc", nil); err != nats.ErrBadSubscription {
+		t.Fatalf("Expected %v, got %v", nats.ErrBadSubscription, err)
+	}
+	sub, err := nc.AddEndpoint("svc", func(r *nats.Request) {
+		if r.Subject() != "svc" {
+			r.RespondError(500, "unexpected subject")
+			return
+		}
+		if string(r.Data()) == "fail" {
+			r.RespondError(400, "bad request")
+			return
+		}
+		r.Respond(append([]byte("hello "), r.Data()...))
+	})
+	if err != nil {
+		t.Fatalf("Error adding endpoint: %v", err)
+	}
+	if sub.Queue != nats.EndpointQueueGroup {
+		t.Fatalf("Expected queue group %q, got %q", nats.EndpointQueueGroup, sub.Queue)
+	}
+
+	msg, err := nc.Request("svc", []byte("world"), time.Second)
+	if err != nil {
+		t.Fatalf("Error on request: %v", err)
+	}
+	if string(msg.Data) != "hello world" || msg.Header.Get(nats.ServiceErrorHdr) != "" {
+		t.Fatalf("Unexpected response %q, headers %v", msg.Data, msg.Header)
+	}
+
+	msg, err = nc.Request("svc", []byte("fail"), time.Second)
+	if err != nil {
+		t.Fatalf("Error on request: %v", err)
+	}
+	if desc := msg.Header.Get(nats.ServiceErrorHdr); desc != "bad request" {
+		t.Fatalf("Expected error description %q, got %q", "bad request", desc)
+	}
+	if code := msg.Header.Get(nats.ServiceErrorCodeHdr); code != "400" {
+		t.Fatalf("Expected error code %q, got %q", "400", code)
+	}
+	if len(msg.Data) != 0 {
+		t.Fatalf("Expected no payload, got %q", msg.Data)
+	}
+
+	_, err = nc.RequestWithOpts("svc", []byte("fail"), nats.ParseServiceErrors())
+	var svcErr *nats.ServiceError
+	if !errors.As(err, &svcErr) || svcErr.Code != 400 || svcErr.Description != "bad request" {
+		t.Fatalf("Expected a ServiceError, got %v", err)
+	}
+}
+
+func TestServeQueue(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	if _, err := nc.ServeQueue("svc", "workers", 0, func(_ *nats.Msg) ([]byte, error) { return nil, nil }); err != nats.ErrInvalidArg {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+	}
+
+	t.Run("concurrency", func(t *testing.T) {
+		const workers = 4
+		var active, maxActive int32
+		release := make(chan struct{})
+		sub, err := nc.ServeQueue("svc.work", "workers", workers, func(m *nats.Msg) ([]byte, error) {
+			n := atomic.AddInt32(&active, 1)
+			for {
+				cur := atomic.LoadInt32(&maxActive)
+				if n <= cur || atomic.CompareAndSwapInt32(&maxActive, cur, n) {
+					break
+				}
+			}
+			<-release
+			atomic.AddInt32(&active, -1)
+			return append([]byte("done "), m.Data...), nil
+		})
+		if err != nil {
+			t.Fatalf("Error on serve: %v", err)
+		}
+		defer sub.Unsubscribe()
+
+		replies, _ := nc.SubscribeSync(nats.NewInbox())
+		for i := 0; i < 2*workers; i++ {
+			nc.PublishRequest("svc.work", replies.Subject, []byte(fmt.Sprint(i)))
+		}
+		nc.Flush()
+		waitFor(t, time.Second, 15*time.Millisecond, func() error {
+			if n := atomic.LoadInt32(&active); n != workers {
+				return fmt.Errorf("expected %d active handlers, got %d", workers, n)
+			}
+			return nil
+		})
+		close(release)
+		for i := 0; i < 2*workers; i++ {
+			m, err := replies.NextMsg(time.Second)
+			if err != nil {
+				t.Fatalf("Error receiving reply %d: %v", i, err)
+			}
+			if !strings.HasPrefix(string(m.Data), "done ") {
+				t.Fatalf("Unexpected reply %q", m.Data)
+			}
+		}
+		if n := atomic.LoadInt32(&maxActive); n != workers {
+			t.Fatalf("Expected at most %d concurrent handlers, got %d", workers, n)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		sub, err := nc.ServeQueue("svc.err", "workers", 2, func(m *nats.Msg) ([]byte, error) {
+			if string(m.Data) == "missing" {
+				return nil, &nats.ServiceError{Code: 404, Description: "not found"}
+			}
+			return nil, errors.New("boom")
+		})
+		if err != nil {
+			t.Fatalf("Error on serve: %v", err)
+		}
+		defer sub.Unsubscribe()
+
+		for _, test := range []struct {
+			data string
+			code string
+			desc string
+		}{
+			{"missing", "404", "not found"},
+			{"other", "500", "boom"},
+		} {
+			msg, err := nc.Request("svc.err", []byte(test.data), time.Second)
+			if err != nil {
+				t.Fatalf("Error on request: %v", err)
+			}
+			if code := msg.Header.Get(nats.ServiceErrorCodeHdr); code != test.code {
+				t.Fatalf("Expected error code %q, got %q", test.code, code)
+			}
+			if desc := msg.Header.Get(nats.ServiceErrorHdr); desc != test.desc {
+				t.Fatalf("Expected error description %q, got %q", test.desc, desc)
+			}
+		}
+	})
+
+	t.Run("drain and unsubscribe", func(t *testing.T) {
+		started := make(chan struct{}, 1)
+		release := make(chan struct{})
+		sub, err := nc.ServeQueue("svc.drain", "workers", 2, func(_ *nats.Msg) ([]byte, error) {
+			started <- struct{}{}
+			<-release
+			return []byte("ok"), nil
+		})
+		if err != nil {
+			t.Fatalf("Error on serve: %v", err)
+		}
+
+		replies, _ := nc.SubscribeSync(nats.NewInbox())
+		nc.PublishRequest("svc.drain", replies.Subject, nil)
+		WaitOnChannel(t, started, struct{}{})
+		if err := sub.Drain(); err != nil {
+			t.Fatalf("Error on drain: %v", err)
+		}
+		close(release)
+		if _, err := replies.NextMsg(time.Second); err != nil {
+			t.Fatalf("Reply should be sent while draining: %v", err)
+		}
+		waitFor(t, time.Second, 15*time.Millisecond, func() error {
+			if sub.IsValid() {
+				return errors.New("subscription should be closed")
+			}
+			return nil
+		})
+		if _, err := nc.Request("svc.drain", nil, 250*time.Millisecond); !errors.Is(err, nats.ErrNoResponders) {
+			t.Fatalf("Expected %v, got %v", nats.ErrNoResponders, err)
+		}
+	})
+}
+
+func TestRequestCorrelationID(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	ids := make(chan string, 2)
+	if _, err := nc.Subscribe("svc.msg", func(m *nats.Msg) {
+		ids <- m.CorrelationID()
+		m.RespondMsg(&nats.Msg{Data: []byte("reply")})
+	}); err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	if _, err := nc.Subscribe("svc.data", func(m *nats.Msg) {
+		ids <- m.CorrelationID()
+		m.Respond([]byte("reply"))
+	}); err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+
+	if _, err := nc.RequestWithOpts("svc.msg", nil, nats.WithCorrelationID("")); !errors.Is(err, nats.ErrInvalidArg) {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+	}
+
+	for _, subj := range []string{"svc.msg", "svc.data"} {
+		t.Run(subj, func(t *testing.T) {
+			resp, err := nc.RequestWithOpts(subj, []byte("req"), nats.WithCorrelationID("abc"))
+			if err != nil {
+				t.Fatalf("Error on request: %v", err)
+			}
+			if id := <-ids; id != "abc" {
+				t.Fatalf("Expected handler to get correlation ID %q, got %q", "abc", id)
+			}
+			if id := resp.CorrelationID(); id != "abc" || string(resp.Data) != "reply" {
+				t.Fatalf("Unexpected reply %q with correlation ID %q", resp.Data, id)
+			}
+
+			// Without correlation ID, the reply has none.
+			resp, err = nc.Request(subj, []byte("req"), time.Second)
+			if err != nil {
+				t.Fatalf("Error on request: %v", err)
+			}
+			<-ids
+			if resp.Header != nil {
+				t.Fatalf("Expected no headers, got %v", resp.Header)
+			}
+		})
+	}
+}
+
+func TestRequestClose(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		time.Sleep(100 * time.Millisecond)
+		nc.Close()
+	}()
+	nc.SubscribeSync("foo")
+	if _, err := nc.Request("foo", []byte("help"), 2*time.Second); err != nats.ErrInvalidConnection && err != nats.ErrConnectionClosed {
+		t.Fatalf("Expected connection error: got %v", err)
+	}
+	wg.Wait()
+}
+
+func TestRequestCloseTimeout(t *testing.T) {
+	// Make sure we return a timeout when we close
+	// the connection even if response is queued.
+
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	response := []byte("I will help you")
+	nc.Subscribe("foo", func(m *nats.Msg) {
+		nc.Publish(m.Reply, response)
+		nc.Close()
+	})
+	if _, err := nc.Request("foo", nil, 1*time.Second); err == nil {
+		t.Fatalf("Expected to receive a timeout error")
+	}
+}
+
+func TestFlushInCB(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	ch := make(chan bool)
+
+	nc.Subscribe("foo", func(_ *nats.Msg) {
+		nc.Flush()
+		ch <- true
+	})
+	nc.Publish("foo", []byte("Hello"))
+	if e := Wait(ch); e != nil {
+		t.Fatal("Flush did not return properly in callback")
+	}
+}
+
+func TestReleaseFlush(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+	nc := NewDefaultConnection(t)
+
+	for i := 0; i < 1000; i++ {
+		nc.Publish("foo", []byte("Hello"))
+	}
+	go nc.Close()
+	nc.Flush()
+}
+
+func TestInbox(t *testing.T) {
+	inbox := nats.NewInbox()
+	if matched, _ := regexp.Match(`_INBOX.\S`, []byte(inbox)); !matched {
+		t.Fatal("Bad INBOX format")
+	}
+}
+
+func TestStats(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	data := []byte("The quick brown fox jumped over the lazy dog")
+	iter := 10
+
+	for i := 0; i < iter; i++ {
+		nc.Publish("foo", data)
+	}
+
+	if nc.OutMsgs != uint64(iter) {
+		t.Fatalf("Not properly tracking OutMsgs: received %d, wanted %d\n", nc.OutMsgs, iter)
+	}
+	obb := uint64(iter * len(data))
 	if nc.OutBytes != obb {
 		t.Fatalf("Not properly tracking OutBytes: received %d, wanted %d\n", nc.OutBytes, obb)
 	}
@@ -878,6 +1990,65 @@ func TestStats(t *testing.T) {
 	}
 }
 
+func TestSubjectStats(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+	if stats := nc.SubjectStats(); stats != nil {
+		t.Fatalf("Expected no subject stats without the option, got %v", stats)
+	}
+
+	nc, err := nats.Connect(nats.DefaultURL, nats.TrackSubjectStats(true))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer nc.Close()
+
+	sub, err := nc.SubscribeSync("foo.*")
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		nc.Publish("foo.bar", []byte("hello"))
+	}
+	nc.Publish("foo.baz", []byte("hi"))
+	nc.Publish("other", []byte("world"))
+	for i := 0; i < 4; i++ {
+		if _, err := sub.NextMsg(time.Second); err != nil {
+			t.Fatalf("Error getting message: %v", err)
+		}
+	}
+
+	expected := map[string]nats.SubjectStat{
+		"foo.bar": {InMsgs: 3, InBytes: 15, OutMsgs: 3, OutBytes: 15},
+		"foo.baz": {InMsgs: 1, InBytes: 2, OutMsgs: 1, OutBytes: 2},
+		"other":   {OutMsgs: 1, OutBytes: 5},
+	}
+	if stats := nc.SubjectStats(); !reflect.DeepEqual(stats, expected) {
+		t.Fatalf("Expected %v, got %v", expected, stats)
+	}
+
+	// Least recently used subjects are evicted past the limit.
+	nc.Publish("foo.bar", nil)
+	for i := 0; i < nats.SubjectStatsMaxEntries-1; i++ {
+		nc.Publish(fmt.Sprintf("bar.%d", i), nil)
+	}
+	stats := nc.SubjectStats()
+	if len(stats) != nats.SubjectStatsMaxEntries {
+		t.Fatalf("Expected %d subjects, got %d", nats.SubjectStatsMaxEntries, len(stats))
+	}
+	for _, subj := range []string{"foo.baz", "other"} {
+		if _, ok := stats[subj]; ok {
+			t.Fatalf("Expected subject %q to be evicted", subj)
+		}
+	}
+	if st := stats["foo.bar"]; st.OutMsgs != 4 {
+		t.Fatalf("Expected 4 messages published on foo.bar, got %d", st.OutMsgs)
+	}
+}
+
 func TestRaceSafeStats(t *testing.T) {
 	s := RunDefaultServer()
 	defer s.Shutdown()
@@ -894,6 +2065,39 @@ func TestRaceSafeStats(t *testing.T) {
 	}
 }
 
+func TestWriteStats(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	nc.ResetWriteStats()
+	total := 100
+	for i := 0; i < total; i++ {
+		nc.Publish("foo", []byte("hello"))
+	}
+	// Adds a PING.
+	if err := nc.Flush(); err != nil {
+		t.Fatalf("Error on flush: %v", err)
+	}
+	flushes, written, msgs := nc.WriteStats()
+	if msgs != int64(total+1) {
+		t.Fatalf("Expected %d messages, got %d", total+1, msgs)
+	}
+	expected := int64(total*len("PUB foo 5\r\nhello\r\n") + len("PING\r\n"))
+	if written != expected {
+		t.Fatalf("Expected %d bytes written, got %d", expected, written)
+	}
+	if flushes == 0 || msgs/flushes < 2 {
+		t.Fatalf("Expected messages to be coalesced, got %d messages in %d flushes", msgs, flushes)
+	}
+
+	nc.ResetWriteStats()
+	if flushes, written, msgs := nc.WriteStats(); flushes != 0 || written != 0 || msgs != 0 {
+		t.Fatalf("Expected stats to be reset, got %d, %d, %d", flushes, written, msgs)
+	}
+}
+
 func TestBadSubject(t *testing.T) {
 	s := RunDefaultServer()
 	defer s.Shutdown()
@@ -909,6 +2113,140 @@ func TestBadSubject(t *testing.T) {
 	}
 }
 
+func TestSubjectAllowlist(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(nats.DefaultURL,
+		nats.SubjectAllowlist([]string{"orders.*.created", "events.>"}, []string{"orders.>", "svc.*"}))
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer nc.Close()
+
+	for _, subj := range []string{"orders.1.created", "events.a", "events.a.b.c"} {
+		if err := nc.Publish(subj, nil); err != nil {
+			t.Fatalf("Expected publish on %q to be allowed, got %v", subj, err)
+		}
+	}
+	for _, subj := range []string{"orders.1.deleted", "orders.created", "events", "foo"} {
+		if err := nc.Publish(subj, nil); err != nats.ErrSubjectNotAllowed {
+			t.Fatalf("Expected publish on %q to fail with %v, got %v", subj, nats.ErrSubjectNotAllowed, err)
+		}
+	}
+
+	for _, subj := range []string{"orders.1", "orders.*.created", "orders.>", "svc.a", "svc.*"} {
+		sub, err := nc.SubscribeSync(subj)
+		if err != nil {
+			t.Fatalf("Expected subscribe on %q to be allowed, got %v", subj, err)
+		}
+		sub.Unsubscribe()
+	}
+	for _, subj := range []string{"orders", "svc.a.b", "svc.>", ">", "foo"} {
+		if _, err := nc.SubscribeSync(subj); err != nats.ErrSubjectNotAllowed {
+			t.Fatalf("Expected subscribe on %q to fail with %v, got %v", subj, nats.ErrSubjectNotAllowed, err)
+		}
+	}
+
+	// Inbox subscriptions created for requests are not affected.
+	sub, err := nc.Subscribe("svc.echo", func(m *nats.Msg) {
+		m.Respond(m.Data)
+	})
+	if err != nil {
+		t.Fatalf("Error subscribing: %v", err)
+	}
+	defer sub.Unsubscribe()
+	nc2, err := nats.Connect(nats.DefaultURL, nats.SubjectAllowlist([]string{"svc.*"}, []string{}))
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer nc2.Close()
+	if _, err := nc2.Request("svc.echo", []byte("hello"), time.Second); err != nil {
+		t.Fatalf("Expected request to succeed, got %v", err)
+	}
+	if _, err := nc2.SubscribeSync("svc.echo"); err != nats.ErrSubjectNotAllowed {
+		t.Fatalf("Expected %v, got %v", nats.ErrSubjectNotAllowed, err)
+	}
+
+	if _, err := nats.Connect(nats.DefaultURL, nats.SubjectAllowlist([]string{"foo..bar"}, nil)); err != nats.ErrBadSubject {
+		t.Fatalf("Expected %v, got %v", nats.ErrBadSubject, err)
+	}
+}
+
+func TestValidateSubjects(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(nats.DefaultURL, nats.ValidateSubjects(true))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer nc.Close()
+
+	for _, subj := range []string{"foo bar", "foo\tbar", "foo..bar", ".foo", "foo.", "foo.>.bar", "foo*", "foo.ba>r", "*bar"} {
+		if err := nc.Publish(subj, nil); !errors.Is(err, nats.ErrInvalidSubject) {
+			t.Fatalf("Expected %v publishing to %q, got %v", nats.ErrInvalidSubject, subj, err)
+		}
+		if _, err := nc.SubscribeSync(subj); err == nil {
+			t.Fatalf("Expected error subscribing to %q", subj)
+		}
+	}
+	// Misplaced wildcards are only caught by the validation.
+	for _, subj := range []string{"foo.>.bar", "foo*", "foo.ba>r"} {
+		if _, err := nc.SubscribeSync(subj); !errors.Is(err, nats.ErrInvalidSubject) {
+			t.Fatalf("Expected %v subscribing to %q, got %v", nats.ErrInvalidSubject, subj, err)
+		}
+	}
+	// Wildcards are not allowed for publishing.
+	for _, subj := range []string{"foo.*", "foo.>", "*"} {
+		if err := nc.Publish(subj, nil); !errors.Is(err, nats.ErrInvalidSubject) {
+			t.Fatalf("Expected %v publishing to %q, got %v", nats.ErrInvalidSubject, subj, err)
+		}
+	}
+	if err := nc.PublishRequest("foo", "reply.*", nil); !errors.Is(err, nats.ErrInvalidSubject) {
+		t.Fatalf("Expected %v for wildcard reply, got %v", nats.ErrInvalidSubject, err)
+	}
+	if _, err := nc.Request("foo.>", nil, time.Second); !errors.Is(err, nats.ErrInvalidSubject) {
+		t.Fatalf("Expected %v for request, got %v", nats.ErrInvalidSubject, err)
+	}
+
+	sub, err := nc.SubscribeSync("foo.*.>")
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	if err := nc.Publish("foo.bar.baz", []byte("hello")); err != nil {
+		t.Fatalf("Error on publish: %v", err)
+	}
+	if _, err := sub.NextMsg(time.Second); err != nil {
+		t.Fatalf("Error getting message: %v", err)
+	}
+	// Resubscribe validates the new subject the same way.
+	if err := sub.Resubscribe("foo.>.bar"); !errors.Is(err, nats.ErrInvalidSubject) {
+		t.Fatalf("Expected %v on resubscribe, got %v", nats.ErrInvalidSubject, err)
+	}
+	if err := sub.Resubscribe("bar.*"); err != nil {
+		t.Fatalf("Error on resubscribe: %v", err)
+	}
+	sub.Unsubscribe()
+
+	nc.Subscribe("service", func(m *nats.Msg) {
+		m.Respond([]byte("ok"))
+	})
+	if _, err := nc.Request("service", nil, time.Second); err != nil {
+		t.Fatalf("Error on request: %v", err)
+	}
+
+	// Off by default, the server is left to reject invalid subjects.
+	nc2 := NewDefaultConnection(t)
+	defer nc2.Close()
+	if err := nc2.Publish("foo.*", nil); err != nil {
+		t.Fatalf("Expected no error without validation, got %v", err)
+	}
+	if _, err := nc2.SubscribeSync("foo.>.bar"); err != nil {
+		t.Fatalf("Expected no error without validation, got %v", err)
+	}
+}
+
 func TestOptions(t *testing.T) {
 	s := RunDefaultServer()
 	defer s.Shutdown()
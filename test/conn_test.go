@@ -16,10 +16,17 @@ package test
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"errors"
 	"fmt"
+	"io"
+	"math/big"
 	"net"
 	"os"
 	"path/filepath"
@@ -516,6 +523,126 @@ func TestClientCertificateReloadOnServerRestart(t *testing.T) {
 	}
 }
 
+func TestTLSCertCBRotation(t *testing.T) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("Error creating CA: %v", err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	serial := int64(1)
+	newCert := func(cn string, usage x509.ExtKeyUsage) tls.Certificate {
+		t.Helper()
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("Error generating key: %v", err)
+		}
+		serial++
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: cn},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatalf("Error creating certificate: %v", err)
+		}
+		return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	}
+
+	opts := test.DefaultTestOptions
+	opts.Port = -1
+	opts.TLS = true
+	opts.TLSVerify = true
+	opts.TLSTimeout = 2
+	opts.TLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{newCert("server", x509.ExtKeyUsageServerAuth)},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+		MinVersion:   tls.VersionTLS12,
+	}
+	s := RunServerWithOptions(&opts)
+	defer s.Shutdown()
+
+	if _, err := nats.Connect(s.ClientURL(), nats.TLSCertCB(nil)); err != nats.ErrClientCertOrRootCAsRequired {
+		t.Fatalf("Expected error %v, got %v", nats.ErrClientCertOrRootCAsRequired, err)
+	}
+
+	var mu sync.Mutex
+	var calls int
+	current := newCert("client-1", x509.ExtKeyUsageClientAuth)
+	certCB := func() (tls.Certificate, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		return current, nil
+	}
+	rch := make(chan bool, 1)
+	nc, err := nats.Connect(s.ClientURL(),
+		nats.ClientTLSConfig(nil, func() (*x509.CertPool, error) { return pool, nil }),
+		nats.TLSCertCB(certCB),
+		nats.ReconnectWait(50*time.Millisecond),
+		nats.ReconnectHandler(func(_ *nats.Conn) { rch <- true }))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer nc.Close()
+
+	peerCN := func() string {
+		t.Helper()
+		cid, err := nc.GetClientID()
+		if err != nil {
+			t.Fatalf("Error getting client ID: %v", err)
+		}
+		connz, err := s.Connz(&server.ConnzOptions{CID: cid, Username: true})
+		if err != nil || len(connz.Conns) != 1 || len(connz.Conns[0].TLSPeerCerts) == 0 {
+			t.Fatalf("Unable to get peer certificates: %v", err)
+		}
+		return connz.Conns[0].TLSPeerCerts[0].Subject
+	}
+	if cn := peerCN(); !strings.Contains(cn, "client-1") {
+		t.Fatalf("Expected the first certificate, got %q", cn)
+	}
+
+	// Rotate the certificate, the new one is presented on reconnect.
+	mu.Lock()
+	current = newCert("client-2", x509.ExtKeyUsageClientAuth)
+	before := calls
+	mu.Unlock()
+	if err := nc.ForceReconnect(); err != nil {
+		t.Fatalf("Error on reconnect: %v", err)
+	}
+	WaitOnChannel(t, rch, true)
+	mu.Lock()
+	after := calls
+	mu.Unlock()
+	if after <= before {
+		t.Fatal("Certificate callback should have been invoked on reconnect")
+	}
+	if cn := peerCN(); !strings.Contains(cn, "client-2") {
+		t.Fatalf("Expected the rotated certificate, got %q", cn)
+	}
+}
+
 func TestServerTLSHintConnections(t *testing.T) {
 	s, opts := RunServerWithConfig("./configs/tls.conf")
 	defer s.Shutdown()
@@ -831,6 +958,66 @@ func TestErrOnMaxPayloadLimit(t *testing.T) {
 	checkErrChannel(t, errCh)
 }
 
+func TestMaxPayloadChangedHandler(t *testing.T) {
+	opts := test.DefaultTestOptions
+	opts.Port = -1
+	opts.MaxPayload = 1024 * 1024
+	s := RunServerWithOptions(&opts)
+	defer func() { s.Shutdown() }()
+
+	type change struct{ old, new int64 }
+	changes := make(chan change, 10)
+	rch := make(chan bool, 1)
+	nc, err := nats.Connect(s.ClientURL(),
+		nats.ReconnectWait(50*time.Millisecond),
+		nats.ReconnectJitter(0, 0),
+		nats.ReconnectHandler(func(_ *nats.Conn) { rch <- true }),
+		nats.MaxPayloadChangedHandler(func(old, new int64) { changes <- change{old, new} }))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer nc.Close()
+
+	// Not invoked on the initial connect.
+	select {
+	case c := <-changes:
+		t.Fatalf("Unexpected change: %+v", c)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	restart := func(maxPayload int32) {
+		t.Helper()
+		opts.Port = s.Addr().(*net.TCPAddr).Port
+		s.Shutdown()
+		opts.MaxPayload = maxPayload
+		s = RunServerWithOptions(&opts)
+		if err := Wait(rch); err != nil {
+			t.Fatal("Failed to get the reconnect cb")
+		}
+	}
+
+	restart(512 * 1024)
+	select {
+	case c := <-changes:
+		if c.old != 1024*1024 || c.new != 512*1024 {
+			t.Fatalf("Unexpected change: %+v", c)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Max payload change handler was not invoked")
+	}
+	if mp := nc.MaxPayload(); mp != 512*1024 {
+		t.Fatalf("Expected max payload to be %d, got %d", 512*1024, mp)
+	}
+
+	// Not invoked if the max payload is unchanged.
+	restart(512 * 1024)
+	select {
+	case c := <-changes:
+		t.Fatalf("Unexpected change: %+v", c)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
 func TestConnectVerbose(t *testing.T) {
 	s := RunDefaultServer()
 	defer s.Shutdown()
@@ -1346,6 +1533,116 @@ func TestFlushReleaseOnClose(t *testing.T) {
 	checkErrChannel(t, errCh)
 }
 
+func TestFlushChannel(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	base := getStableNumGoroutine(t)
+
+	nc.Publish("foo", []byte("hello"))
+	ch, err := nc.FlushChannel()
+	if err != nil {
+		t.Fatalf("Error on flush: %v", err)
+	}
+	select {
+	case err, ok := <-ch:
+		if !ok || err != nil {
+			t.Fatalf("Expected successful flush, got %v (%v)", err, ok)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Flush did not complete")
+	}
+	if _, ok := <-ch; ok {
+		t.Fatal("Expected channel to be closed")
+	}
+	checkNoGoroutineLeak(t, base, "flush channel")
+
+	nc.Close()
+	if _, err := nc.FlushChannel(); err != nats.ErrConnectionClosed {
+		t.Fatalf("Expected %v, got %v", nats.ErrConnectionClosed, err)
+	}
+}
+
+func TestFlushChannelReleaseOnClose(t *testing.T) {
+	serverInfo := "INFO {\"server_id\":\"foobar\",\"host\":\"%s\",\"port\":%d,\"auth_required\":false,\"tls_required\":false,\"max_payload\":1048576}\r\n"
+
+	l, e := net.Listen("tcp", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal("Could not listen on an ephemeral port")
+	}
+	tl := l.(*net.TCPListener)
+	defer tl.Close()
+
+	addr := tl.Addr().(*net.TCPAddr)
+	done := make(chan bool)
+
+	errCh := make(chan error, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			errCh <- fmt.Errorf("error accepting client connection: %v", err)
+			return
+		}
+		defer conn.Close()
+		info := fmt.Sprintf(serverInfo, addr.IP, addr.Port)
+		conn.Write([]byte(info))
+
+		// Read connect and ping commands sent from the client
+		br := bufio.NewReaderSize(conn, 1024)
+		if _, err := br.ReadString('\n'); err != nil {
+			errCh <- fmt.Errorf("expected CONNECT from client, got: %s", err)
+			return
+		}
+		if _, err := br.ReadString('\n'); err != nil {
+			errCh <- fmt.Errorf("expected PING from client, got: %s", err)
+			return
+		}
+		conn.Write([]byte("PONG\r\n"))
+
+		// Do not respond to further pings until asked to quit
+		<-done
+	}()
+
+	natsURL := fmt.Sprintf("nats://%s:%d", addr.IP, addr.Port)
+	opts := nats.GetDefaultOptions()
+	opts.AllowReconnect = false
+	opts.Servers = []string{natsURL}
+	nc, err := opts.Connect()
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer nc.Close()
+
+	base := getStableNumGoroutine(t)
+
+	ch, err := nc.FlushChannel()
+	if err != nil {
+		t.Fatalf("Error on flush: %v", err)
+	}
+	select {
+	case err := <-ch:
+		t.Fatalf("Unexpected flush result: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	nc.Close()
+	select {
+	case err := <-ch:
+		if err != nats.ErrConnectionClosed {
+			t.Fatalf("Expected %v, got %v", nats.ErrConnectionClosed, err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Flush was not released by Close()")
+	}
+	checkNoGoroutineLeak(t, base, "flush channel released by close")
+
+	close(done)
+	checkErrChannel(t, errCh)
+}
+
 func TestMaxPendingOut(t *testing.T) {
 	serverInfo := "INFO {\"server_id\":\"foobar\",\"host\":\"%s\",\"port\":%d,\"auth_required\":false,\"tls_required\":false,\"max_payload\":1048576}\r\n"
 
@@ -1866,6 +2163,54 @@ func TestUseCustomDialer(t *testing.T) {
 	}
 }
 
+func TestCustomDialerFunc(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	var mu sync.Mutex
+	var addrs []string
+	var deadlines int
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		mu.Lock()
+		addrs = append(addrs, addr)
+		if _, ok := ctx.Deadline(); ok {
+			deadlines++
+		}
+		mu.Unlock()
+		var d net.Dialer
+		return d.DialContext(ctx, network, addr)
+	}
+	reconnected := make(chan struct{}, 1)
+	nc, err := nats.Connect(nats.DefaultURL,
+		nats.CustomDialerFunc(dial),
+		nats.SetCustomDialer(&customDialer{ch: make(chan bool, 10)}),
+		nats.ReconnectWait(10*time.Millisecond),
+		nats.ReconnectHandler(func(_ *nats.Conn) { reconnected <- struct{}{} }))
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer nc.Close()
+
+	s.Shutdown()
+	s = RunDefaultServer()
+	defer s.Shutdown()
+	WaitOnChannel(t, reconnected, struct{}{})
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(addrs) < 2 {
+		t.Fatalf("Expected the dialer to be used on connect and reconnect, got %v", addrs)
+	}
+	for _, addr := range addrs {
+		if addr != "127.0.0.1:4222" {
+			t.Fatalf("Unexpected address: %q", addr)
+		}
+	}
+	if deadlines != len(addrs) {
+		t.Fatalf("Expected all attempts to have a deadline, got %v out of %v", deadlines, len(addrs))
+	}
+}
+
 func TestDefaultOptionsDialer(t *testing.T) {
 	s := RunDefaultServer()
 	defer s.Shutdown()
@@ -2075,6 +2420,82 @@ forLoop:
 	}
 }
 
+type readCountingConn struct {
+	net.Conn
+	reads *int64
+}
+
+func (c *readCountingConn) Read(b []byte) (int, error) {
+	atomic.AddInt64(c.reads, 1)
+	return c.Conn.Read(b)
+}
+
+type readCountingDialer struct {
+	reads int64
+}
+
+func (d *readCountingDialer) Dial(network, address string) (net.Conn, error) {
+	c, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return &readCountingConn{Conn: c, reads: &d.reads}, nil
+}
+
+func TestReaderBufferSize(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	if _, err := nats.Connect(nats.DefaultURL, nats.ReaderBufferSize(100)); !errors.Is(err, nats.ErrInvalidArg) {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+	}
+
+	pub := NewDefaultConnection(t)
+	defer pub.Close()
+
+	const toSend = 5000
+	payload := make([]byte, 512)
+
+	countReads := func(size int) int64 {
+		t.Helper()
+		d := &readCountingDialer{}
+		nc, err := nats.Connect(nats.DefaultURL, nats.SetCustomDialer(d), nats.ReaderBufferSize(size))
+		if err != nil {
+			t.Fatalf("Error on connect: %v", err)
+		}
+		defer nc.Close()
+
+		sub, err := nc.SubscribeSync("foo")
+		if err != nil {
+			t.Fatalf("Error on subscribe: %v", err)
+		}
+		sub.SetPendingLimits(-1, -1)
+		nc.Flush()
+		start := atomic.LoadInt64(&d.reads)
+		for i := 0; i < toSend; i++ {
+			pub.Publish("foo", payload)
+		}
+		pub.Flush()
+		waitFor(t, 5*time.Second, 15*time.Millisecond, func() error {
+			if n, _, _ := sub.Pending(); n != toSend {
+				return fmt.Errorf("Expected %d pending messages, got %d", toSend, n)
+			}
+			return nil
+		})
+		return atomic.LoadInt64(&d.reads) - start
+	}
+
+	small := countReads(512)
+	large := countReads(1024 * 1024)
+	// With a 512 bytes buffer, there is at least one read per message.
+	if small < toSend {
+		t.Fatalf("Expected at least %d reads with a small buffer, got %d", toSend, small)
+	}
+	if large >= small {
+		t.Fatalf("Expected fewer reads with a large buffer, got %d vs %d", large, small)
+	}
+}
+
 func TestNewServers(t *testing.T) {
 	s1Opts := test.DefaultTestOptions
 	s1Opts.Host = "127.0.0.1"
@@ -2364,6 +2785,56 @@ func TestBarrier(t *testing.T) {
 	}
 }
 
+func TestBarrierAfterInFlightCallback(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	var done int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	if _, err := nc.Subscribe("foo", func(_ *nats.Msg) {
+		close(started)
+		<-release
+		atomic.StoreInt32(&done, 1)
+	}); err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	// Another subscription so that the barrier needs to be seen by both.
+	if _, err := nc.Subscribe("bar", func(_ *nats.Msg) {}); err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	if err := nc.Publish("foo", []byte("hello")); err != nil {
+		t.Fatalf("Error on publish: %v", err)
+	}
+	select {
+	case <-started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Callback was not invoked")
+	}
+
+	ch := make(chan int32, 1)
+	if err := nc.Barrier(func() { ch <- atomic.LoadInt32(&done) }); err != nil {
+		t.Fatalf("Error on barrier: %v", err)
+	}
+	select {
+	case <-ch:
+		t.Fatal("Barrier function invoked while callback is in progress")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case d := <-ch:
+		if d != 1 {
+			t.Fatal("Barrier function invoked before the callback returned")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Barrier function was not invoked")
+	}
+}
+
 func TestReceiveInfoRightAfterFirstPong(t *testing.T) {
 	l, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
@@ -2967,6 +3438,32 @@ func TestRetryOnFailedConnectWithTLSError(t *testing.T) {
 	}
 }
 
+func TestFailFast(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	servers := "nats://127.0.0.1:4223, " + nats.DefaultURL
+	nc, err := nats.Connect(servers, nats.DontRandomize())
+	if err != nil {
+		t.Fatalf("Expected to connect to the second server: %v", err)
+	}
+	nc.Close()
+
+	nc, err = nats.Connect(servers, nats.DontRandomize(), nats.FailFast())
+	if err == nil {
+		nc.Close()
+		t.Fatal("Expected connect to fail")
+	}
+	if errors.Is(err, nats.ErrNoServers) || !strings.Contains(err.Error(), "connection refused") {
+		t.Fatalf("Expected the dial error, got %v", err)
+	}
+
+	_, err = nats.Connect(servers, nats.FailFast(), nats.RetryOnFailedConnect(true))
+	if !errors.Is(err, nats.ErrInvalidArg) {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+	}
+}
+
 func TestConnStatusChangedEvents(t *testing.T) {
 	t.Run("default events", func(t *testing.T) {
 		s := RunDefaultServer()
@@ -3057,6 +3554,42 @@ func TestConnStatusChangedEvents(t *testing.T) {
 		}
 		time.Sleep(100 * time.Millisecond)
 	})
+
+	t.Run("draining events", func(t *testing.T) {
+		s := RunDefaultServer()
+		defer s.Shutdown()
+		nc, err := nats.Connect(s.ClientURL())
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		defer nc.Close()
+		newStatus := nc.StatusChanged(nats.DRAINING_SUBS, nats.DRAINING_PUBS, nats.CLOSED)
+
+		// A slow subscriber keeps the connection in the draining state.
+		release := make(chan struct{})
+		if _, err := nc.Subscribe("foo", func(_ *nats.Msg) { <-release }); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		nc.Publish("foo", []byte("msg"))
+		nc.Flush()
+
+		if err := nc.Drain(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		WaitOnChannel(t, newStatus, nats.DRAINING_SUBS)
+		if st := nc.Status(); st != nats.DRAINING_SUBS {
+			t.Fatalf("Expected status %v, got %v", nats.DRAINING_SUBS, st)
+		}
+		if !nc.IsDraining() {
+			t.Fatal("Expected connection to be draining")
+		}
+		close(release)
+		WaitOnChannel(t, newStatus, nats.DRAINING_PUBS)
+		WaitOnChannel(t, newStatus, nats.CLOSED)
+		if nc.IsDraining() {
+			t.Fatal("Expected connection to not be draining once closed")
+		}
+	})
 }
 
 func TestRemoveStatusListener(t *testing.T) {
@@ -3225,3 +3758,89 @@ func TestTLSHandshakeFirst(t *testing.T) {
 		t.Fatal("Server did not exit")
 	}
 }
+
+func TestConnPendingOutbound(t *testing.T) {
+	t.Run("buffered while reconnecting", func(t *testing.T) {
+		connected := make(chan bool, 1)
+		nc, err := nats.Connect(nats.DefaultURL,
+			nats.RetryOnFailedConnect(true),
+			nats.ReconnectWait(50*time.Millisecond),
+			nats.ConnectHandler(func(_ *nats.Conn) { connected <- true }))
+		if err != nil {
+			t.Fatalf("Error on connect: %v", err)
+		}
+		defer nc.Close()
+
+		if n := nc.PendingOutboundMsgs(); n != 0 {
+			t.Fatalf("Expected no pending messages, got %d", n)
+		}
+		var size int
+		for i := 0; i < 10; i++ {
+			msg := fmt.Sprintf("msg-%d", i)
+			if err := nc.Publish("foo", []byte(msg)); err != nil {
+				t.Fatalf("Error on publish: %v", err)
+			}
+			size += len(fmt.Sprintf("PUB foo %d\r\n%s\r\n", len(msg), msg))
+			if n := nc.PendingOutboundMsgs(); n != i+1 {
+				t.Fatalf("Expected %d pending messages, got %d", i+1, n)
+			}
+		}
+		if n := nc.PendingOutboundBytes(); n != size {
+			t.Fatalf("Expected %d pending bytes, got %d", size, n)
+		}
+
+		s := RunDefaultServer()
+		defer s.Shutdown()
+		WaitOnChannel(t, connected, true)
+		if err := nc.Flush(); err != nil {
+			t.Fatalf("Error on flush: %v", err)
+		}
+		if n, b := nc.PendingOutboundMsgs(), nc.PendingOutboundBytes(); n != 0 || b != 0 {
+			t.Fatalf("Expected nothing pending, got %d messages and %d bytes", n, b)
+		}
+	})
+
+	t.Run("pings without pong", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Could not listen on an ephemeral port: %v", err)
+		}
+		defer l.Close()
+
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			conn.Write([]byte("INFO {\"server_id\":\"foobar\"}\r\n"))
+			br := bufio.NewReader(conn)
+			br.ReadLine()
+			br.ReadLine()
+			conn.Write([]byte("PONG\r\n"))
+			// Never answer PINGs from now on.
+			go io.Copy(io.Discard, conn)
+			<-done
+		}()
+
+		nc, err := nats.Connect(fmt.Sprintf("nats://%s", l.Addr().String()))
+		if err != nil {
+			t.Fatalf("Error on connect: %v", err)
+		}
+		defer nc.Close()
+
+		if n := nc.OutstandingPings(); n != 0 {
+			t.Fatalf("Expected no outstanding pings, got %d", n)
+		}
+		for i := 1; i <= 2; i++ {
+			if err := nc.FlushTimeout(50 * time.Millisecond); err != nats.ErrTimeout {
+				t.Fatalf("Expected error %v, got %v", nats.ErrTimeout, err)
+			}
+			if n := nc.OutstandingPings(); n != i {
+				t.Fatalf("Expected %d outstanding pings, got %d", i, n)
+			}
+		}
+	})
+}
@@ -806,3 +806,82 @@ func TestContextInvalid(t *testing.T) {
 		t.Errorf("Expected request to fail with connection closed error: %s", err)
 	}
 }
+
+func TestSubscriptionWaitForStatus(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	// disable slow consumer prints
+	nc.SetErrorHandler(func(c *nats.Conn, s *nats.Subscription, e error) {})
+	defer nc.Close()
+
+	blockChan := make(chan struct{})
+	sub, err := nc.Subscribe("foo", func(_ *nats.Msg) {
+		<-blockChan
+	})
+	if err != nil {
+		t.Fatalf("Error subscribing: %v", err)
+	}
+	sub.SetPendingLimits(10, 1024)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Matching current status returns right away.
+	status, err := sub.WaitForStatus(ctx, nats.SubscriptionActive)
+	if err != nil || status != nats.SubscriptionActive {
+		t.Fatalf("Expected %v, got %v - %v", nats.SubscriptionActive, status, err)
+	}
+	if _, err := sub.WaitForStatus(ctx); err != nats.ErrInvalidArg {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+	}
+
+	// Times out if the status is not reached.
+	shortCtx, shortCancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer shortCancel()
+	status, err = sub.WaitForStatus(shortCtx, nats.SubscriptionClosed)
+	if err != context.DeadlineExceeded || status != nats.SubscriptionActive {
+		t.Fatalf("Expected %v, got %v - %v", context.DeadlineExceeded, status, err)
+	}
+
+	go func() {
+		for i := 0; i < 11; i++ {
+			nc.Publish("foo", []byte("Hello"))
+		}
+	}()
+	status, err = sub.WaitForStatus(ctx, nats.SubscriptionSlowConsumer, nats.SubscriptionClosed)
+	if err != nil || status != nats.SubscriptionSlowConsumer {
+		t.Fatalf("Expected %v, got %v - %v", nats.SubscriptionSlowConsumer, status, err)
+	}
+	close(blockChan)
+
+	go sub.Drain()
+	status, err = sub.WaitForStatus(ctx, nats.SubscriptionClosed)
+	if err != nil || status != nats.SubscriptionClosed {
+		t.Fatalf("Expected %v, got %v - %v", nats.SubscriptionClosed, status, err)
+	}
+	if _, err := sub.WaitForStatus(ctx, nats.SubscriptionActive); err != nats.ErrBadSubscription {
+		t.Fatalf("Expected %v, got %v", nats.ErrBadSubscription, err)
+	}
+	// Closing the subscription releases a blocked call.
+	sub, err = nc.SubscribeSync("bar")
+	if err != nil {
+		t.Fatalf("Error subscribing: %v", err)
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := sub.WaitForStatus(context.Background(), nats.SubscriptionSlowConsumer)
+		errCh <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	sub.Unsubscribe()
+	select {
+	case err := <-errCh:
+		if err != nats.ErrBadSubscription {
+			t.Fatalf("Expected %v, got %v", nats.ErrBadSubscription, err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("WaitForStatus did not return after the subscription was closed")
+	}
+}
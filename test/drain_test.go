@@ -14,8 +14,11 @@
 package test
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"reflect"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -236,6 +239,151 @@ func TestDrainSlowSubscriber(t *testing.T) {
 	})
 }
 
+func TestDrainHandler(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	for _, test := range []struct {
+		name         string
+		withCallback bool
+	}{
+		{"instead of callback", false},
+		{"with callback", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var received, drained []string
+			started := make(chan struct{}, 1)
+			release := make(chan struct{})
+			sub, err := nc.Subscribe("foo", func(m *nats.Msg) {
+				mu.Lock()
+				received = append(received, string(m.Data))
+				first := len(received) == 1
+				mu.Unlock()
+				if first {
+					started <- struct{}{}
+					<-release
+				}
+			})
+			if err != nil {
+				t.Fatalf("Error creating subscription; %v", err)
+			}
+			sub.SetDrainHandlerEx(func(m *nats.Msg) {
+				mu.Lock()
+				drained = append(drained, string(m.Data))
+				mu.Unlock()
+			}, test.withCallback)
+			statusCh := sub.StatusChanged(nats.SubscriptionDraining)
+			done := make(chan struct{})
+			sub.SetClosedHandler(func(_ string) { close(done) })
+
+			total := 10
+			for i := 0; i < total; i++ {
+				nc.Publish("foo", []byte(strconv.Itoa(i)))
+			}
+			nc.Flush()
+			select {
+			case <-started:
+			case <-time.After(2 * time.Second):
+				t.Fatal("Callback was not invoked")
+			}
+
+			// The message being processed was delivered before the drain.
+			if err := sub.Drain(); err != nil {
+				t.Fatalf("Error on drain: %v", err)
+			}
+			WaitOnChannel(t, statusCh, nats.SubscriptionDraining)
+			close(release)
+			select {
+			case <-done:
+			case <-time.After(2 * time.Second):
+				t.Fatal("Subscription was not drained")
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			var expected []string
+			for i := 1; i < total; i++ {
+				expected = append(expected, strconv.Itoa(i))
+			}
+			if !reflect.DeepEqual(drained, expected) {
+				t.Fatalf("Expected drain handler to get %v, got %v", expected, drained)
+			}
+			expectedReceived := []string{"0"}
+			if test.withCallback {
+				expectedReceived = append(expectedReceived, expected...)
+			}
+			if !reflect.DeepEqual(received, expectedReceived) {
+				t.Fatalf("Expected callback to get %v, got %v", expectedReceived, received)
+			}
+		})
+	}
+}
+
+func TestDrainWaitForReplies(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+	rc := NewDefaultConnection(t)
+	defer rc.Close()
+
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	sub, err := nc.SubscribeWithOpts("svc", func(m *nats.Msg) {
+		started <- struct{}{}
+		<-release
+		m.Respond([]byte("reply"))
+	}, nats.DrainWaitForReplies())
+	if err != nil {
+		t.Fatalf("Error creating subscription; %v", err)
+	}
+
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := js.Subscribe("foo", func(_ *nats.Msg) {}, nats.DrainWaitForReplies()); !errors.Is(err, nats.ErrInvalidArg) {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+	}
+	done := make(chan struct{})
+	sub.SetClosedHandler(func(_ string) { close(done) })
+	nc.Flush()
+
+	errCh := make(chan error, 1)
+	go func() {
+		resp, err := rc.Request("svc", []byte("req"), 2*time.Second)
+		if err == nil && string(resp.Data) != "reply" {
+			err = fmt.Errorf("unexpected reply %q", resp.Data)
+		}
+		errCh <- err
+	}()
+	select {
+	case <-started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Callback was not invoked")
+	}
+
+	// Drain while the callback is about to reply.
+	if err := sub.Drain(); err != nil {
+		t.Fatalf("Error on drain: %v", err)
+	}
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Subscription was not drained")
+	}
+	// The reply was flushed before the subscription was closed.
+	nc.Close()
+
+	if err := <-errCh; err != nil {
+		t.Fatalf("Error on request: %v", err)
+	}
+}
+
 func TestDrainConnection(t *testing.T) {
 	s := RunDefaultServer()
 	defer s.Shutdown()
@@ -339,6 +487,71 @@ func TestDrainConnection(t *testing.T) {
 	}
 }
 
+func TestDrainConnectionProgress(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	done := make(chan bool, 1)
+	var mu sync.Mutex
+	var progress [][2]int
+
+	nc, err := nats.Connect(nats.DefaultURL,
+		nats.ClosedHandler(func(_ *nats.Conn) { done <- true }),
+		nats.DrainProgressHandler(func(remaining, total int) {
+			mu.Lock()
+			progress = append(progress, [2]int{remaining, total})
+			mu.Unlock()
+		}))
+	if err != nil {
+		t.Fatalf("Failed to create default connection: %v", err)
+	}
+	defer nc.Close()
+
+	if r, tot := nc.DrainProgress(); r != 0 || tot != 0 {
+		t.Fatalf("Expected no progress before drain, got %d/%d", r, tot)
+	}
+
+	for _, subj := range []string{"foo", "bar"} {
+		if _, err := nc.Subscribe(subj, func(_ *nats.Msg) {}); err != nil {
+			t.Fatalf("Error on subscribe: %v", err)
+		}
+	}
+	if _, err := nc.Subscribe("baz", func(_ *nats.Msg) {
+		time.Sleep(50 * time.Millisecond)
+	}); err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		nc.Publish("baz", []byte("hello"))
+	}
+	nc.Flush()
+
+	if err := nc.Drain(); err != nil {
+		t.Fatalf("Error on drain: %v", err)
+	}
+	waitFor(t, time.Second, 5*time.Millisecond, func() error {
+		if r, tot := nc.DrainProgress(); tot != 3 || r == 0 {
+			return fmt.Errorf("Expected drain in progress of 3 subscriptions, got %d/%d", r, tot)
+		}
+		return nil
+	})
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Timeout waiting for closed state for connection")
+	}
+	if r, tot := nc.DrainProgress(); r != 0 || tot != 3 {
+		t.Fatalf("Expected drain to be complete, got %d/%d", r, tot)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	expected := [][2]int{{2, 3}, {1, 3}, {0, 3}}
+	if !reflect.DeepEqual(progress, expected) {
+		t.Fatalf("Expected progress %v, got %v", expected, progress)
+	}
+}
+
 func TestDrainConnectionAutoUnsub(t *testing.T) {
 	s := RunDefaultServer()
 	defer s.Shutdown()
@@ -455,6 +668,96 @@ func TestDrainConnLastError(t *testing.T) {
 	wg.Wait()
 }
 
+func TestCloseGracefully(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	slowSub := func(t *testing.T, nc *nats.Conn, delay time.Duration, received *int32) {
+		t.Helper()
+		if _, err := nc.Subscribe("foo", func(_ *nats.Msg) {
+			time.Sleep(delay)
+			atomic.AddInt32(received, 1)
+		}); err != nil {
+			t.Fatalf("Error on subscribe: %v", err)
+		}
+		for i := 0; i < 10; i++ {
+			nc.Publish("foo", nil)
+		}
+		nc.Flush()
+	}
+
+	t.Run("graceful", func(t *testing.T) {
+		nc := NewDefaultConnection(t)
+		defer nc.Close()
+		checker := NewDefaultConnection(t)
+		defer checker.Close()
+		sub, err := checker.SubscribeSync("bar")
+		if err != nil {
+			t.Fatalf("Error on subscribe: %v", err)
+		}
+		checker.Flush()
+
+		var received int32
+		slowSub(t, nc, 10*time.Millisecond, &received)
+		// Pending publishes are flushed as well.
+		nc.Publish("bar", []byte("bye"))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := nc.CloseGracefully(ctx); err != nil {
+			t.Fatalf("Expected graceful close, got %v", err)
+		}
+		if !nc.IsClosed() {
+			t.Fatal("Expected connection to be closed")
+		}
+		if n := atomic.LoadInt32(&received); n != 10 {
+			t.Fatalf("Expected 10 messages delivered, got %v", n)
+		}
+		if _, err := sub.NextMsg(time.Second); err != nil {
+			t.Fatalf("Expected publish to be flushed: %v", err)
+		}
+		if err := nc.CloseGracefully(ctx); err != nats.ErrConnectionClosed {
+			t.Fatalf("Expected %v, got %v", nats.ErrConnectionClosed, err)
+		}
+	})
+
+	t.Run("context forced", func(t *testing.T) {
+		nc := NewDefaultConnection(t)
+		defer nc.Close()
+
+		var received int32
+		slowSub(t, nc, 50*time.Millisecond, &received)
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		if err := nc.CloseGracefully(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Expected %v, got %v", context.DeadlineExceeded, err)
+		}
+		if !nc.IsClosed() {
+			t.Fatal("Expected connection to be closed")
+		}
+		if n := atomic.LoadInt32(&received); n == 10 {
+			t.Fatal("Expected draining to be interrupted")
+		}
+	})
+
+	t.Run("drain timeout", func(t *testing.T) {
+		nc, err := nats.Connect(nats.DefaultURL, nats.DrainTimeout(100*time.Millisecond))
+		if err != nil {
+			t.Fatalf("Error connecting: %v", err)
+		}
+		defer nc.Close()
+
+		var received int32
+		slowSub(t, nc, 50*time.Millisecond, &received)
+		if err := nc.CloseGracefully(context.Background()); !errors.Is(err, nats.ErrDrainTimeout) {
+			t.Fatalf("Expected %v, got %v", nats.ErrDrainTimeout, err)
+		}
+		if !nc.IsClosed() {
+			t.Fatal("Expected connection to be closed")
+		}
+	})
+}
+
 func TestDrainConnDuringReconnect(t *testing.T) {
 	s := RunDefaultServer()
 	defer s.Shutdown()
@@ -494,3 +797,68 @@ func TestDrainConnDuringReconnect(t *testing.T) {
 		t.Fatalf("Timeout waiting for closed state for connection")
 	}
 }
+
+func TestSubscriptionDrainWithContext(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	received := int32(0)
+	expected := int32(50)
+	release := make(chan struct{})
+	sub, err := nc.Subscribe("foo", func(_ *nats.Msg) {
+		<-release
+		atomic.AddInt32(&received, 1)
+	})
+	if err != nil {
+		t.Fatalf("Error creating subscription; %v", err)
+	}
+	for i := int32(0); i < expected; i++ {
+		nc.Publish("foo", []byte("Don't forget about me"))
+	}
+	nc.Flush()
+
+	// Callback is blocked, so the drain can't complete in time.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := sub.DrainWithContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	if !sub.IsDraining() {
+		t.Fatalf("Expected drain to be still in progress")
+	}
+
+	// Calling again once the callback is released waits for completion.
+	close(release)
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := sub.DrainWithContext(ctx); err != nil {
+		t.Fatalf("Unexpected error draining: %v", err)
+	}
+	if r := atomic.LoadInt32(&received); r != expected {
+		t.Fatalf("Did not receive all messages: %d of %d", r, expected)
+	}
+	if sub.IsValid() {
+		t.Fatalf("Expected subscription to be closed")
+	}
+	// Draining a closed subscription returns right away.
+	if err := sub.DrainWithContext(ctx); err != nil {
+		t.Fatalf("Unexpected error draining: %v", err)
+	}
+
+	// Connection closed while draining.
+	block := make(chan struct{})
+	defer close(block)
+	sub, err = nc.Subscribe("bar", func(_ *nats.Msg) { <-block })
+	if err != nil {
+		t.Fatalf("Error creating subscription; %v", err)
+	}
+	nc.Publish("bar", nil)
+	nc.Publish("bar", nil)
+	nc.Flush()
+	time.AfterFunc(100*time.Millisecond, nc.Close)
+	if err := sub.DrainWithContext(ctx); err != nats.ErrConnectionClosed {
+		t.Fatalf("Expected %v, got %v", nats.ErrConnectionClosed, err)
+	}
+}
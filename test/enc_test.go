@@ -18,6 +18,7 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -1104,3 +1105,40 @@ func TestEncodedContextInvalid(t *testing.T) {
 		t.Errorf("Expected request to fail with invalid context: %s", err)
 	}
 }
+
+func TestEncUseInterceptors(t *testing.T) {
+	s := RunServerOnPort(TEST_PORT)
+	defer s.Shutdown()
+
+	ec := NewDefaultEConn(t)
+	defer ec.Close()
+
+	var intercepted atomic.Int32
+	ec.Conn.Use(func(next nats.MsgHandler) nats.MsgHandler {
+		return func(m *nats.Msg) {
+			intercepted.Add(1)
+			next(m)
+		}
+	})
+
+	received := make(chan string, 2)
+	if _, err := ec.Subscribe("foo", func(s string) { received <- s }); err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	ch := make(chan string, 1)
+	if _, err := ec.BindRecvChan("bar", ch); err != nil {
+		t.Fatalf("Error on bind: %v", err)
+	}
+	ec.Publish("foo", "hello")
+	ec.Publish("bar", "world")
+	for _, c := range []chan string{received, ch} {
+		select {
+		case <-c:
+		case <-time.After(time.Second):
+			t.Fatal("Did not receive message")
+		}
+	}
+	if n := intercepted.Load(); n != 2 {
+		t.Fatalf("Expected 2 intercepted messages, got %d", n)
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"reflect"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -525,3 +526,71 @@ func TestMsgHeadersCasePreserving(t *testing.T) {
 		}
 	})
 }
+
+func TestMsgForwardLoopDetection(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	loopCh := make(chan *nats.Msg, 1)
+	nc, err := nats.Connect(s.ClientURL(),
+		nats.LoopDetection("Relay-Hops", 3),
+		nats.LoopDetectedHandler(func(m *nats.Msg) {
+			loopCh <- m
+		}))
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	// Two relays forwarding to each other create a loop.
+	var forwarded int32
+	for _, subjs := range [][2]string{{"relay.a", "relay.b"}, {"relay.b", "relay.a"}} {
+		to := subjs[1]
+		sub, err := nc.Subscribe(subjs[0], func(m *nats.Msg) {
+			atomic.AddInt32(&forwarded, 1)
+			if err := m.Forward(to); err != nil {
+				t.Errorf("Error forwarding: %v", err)
+			}
+		})
+		if err != nil {
+			t.Fatalf("Error subscribing: %v", err)
+		}
+		defer sub.Unsubscribe()
+	}
+	nc.Flush()
+
+	msg := nats.NewMsg("relay.a")
+	msg.Header.Set("Foo", "bar")
+	msg.Data = []byte("loop")
+	if err := nc.PublishMsg(msg); err != nil {
+		t.Fatalf("Error publishing: %v", err)
+	}
+
+	select {
+	case m := <-loopCh:
+		if hops := m.Header.Get("Relay-Hops"); hops != "4" {
+			t.Fatalf("Expected hop count of 4, got %q", hops)
+		}
+		if m.Header.Get("Foo") != "bar" || string(m.Data) != "loop" {
+			t.Fatalf("Unexpected message content: %+v", m)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Loop detected handler was not invoked")
+	}
+	if n := atomic.LoadInt32(&forwarded); n != 4 {
+		t.Fatalf("Expected message to be forwarded 4 times, got %d", n)
+	}
+	if n := nc.LoopDropped(); n != 1 {
+		t.Fatalf("Expected 1 message dropped by loop detection, got %d", n)
+	}
+
+	if _, err := nats.Connect(s.ClientURL(), nats.LoopDetection("", 3)); err != nats.ErrInvalidArg {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+	}
+	if _, err := nats.Connect(s.ClientURL(), nats.LoopDetection("Relay-Hops", 0)); err != nats.ErrInvalidArg {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+	}
+	if err := (&nats.Msg{}).Forward("foo"); err != nats.ErrMsgNotBound {
+		t.Fatalf("Expected %v, got %v", nats.ErrMsgNotBound, err)
+	}
+}
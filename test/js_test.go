@@ -14,6 +14,7 @@
 package test
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
 	"encoding/json"
@@ -460,6 +461,117 @@ func TestPublishWithTTL(t *testing.T) {
 	}
 }
 
+func TestPublishWithTTLHeaderFormat(t *testing.T) {
+	srv := RunBasicJetStreamServer()
+	defer shutdownJSServerAndRemoveStorage(t, srv)
+	nc, js := jsClient(t, srv)
+	defer nc.Close()
+
+	_, err := js.AddStream(&nats.StreamConfig{
+		Name: "foo", Subjects: []string{"FOO.*"}, AllowMsgTTL: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if _, err := js.Publish("FOO.1", []byte("msg"), nats.MsgTTL(0)); err == nil {
+		t.Fatal("Expected error for zero TTL")
+	}
+	if _, err := js.PublishAsync("FOO.1", []byte("msg"), nats.MsgTTL(-time.Second)); err == nil {
+		t.Fatal("Expected error for negative TTL")
+	}
+
+	// Check the header as it is sent on the wire.
+	sub, err := nc.SubscribeSync("FOO.*")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	if _, err := js.Publish("FOO.1", []byte("msg"), nats.MsgTTL(90*time.Second)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	m, err := sub.NextMsg(time.Second)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ttl := m.Header.Get(nats.MsgTTLHdr); ttl != "1m30s" {
+		t.Fatalf("Expected TTL header to be 1m30s; got: %q", ttl)
+	}
+}
+
+func TestPublishCompressed(t *testing.T) {
+	srv := RunBasicJetStreamServer()
+	defer shutdownJSServerAndRemoveStorage(t, srv)
+	nc, js := jsClient(t, srv)
+	defer nc.Close()
+
+	if _, err := js.AddStream(&nats.StreamConfig{Name: "foo", Subjects: []string{"FOO.*"}}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	msgs := make(chan *nats.Msg, 2)
+	sub, err := nc.SubscribeWithOpts("FOO.*", func(m *nats.Msg) { msgs <- m }, nats.AutoDecompress())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	data := bytes.Repeat([]byte("compressible "), 100)
+	// The published message is left untouched, so it can be reused.
+	m := nats.NewMsg("FOO.1")
+	m.Data = data
+	for i := 0; i < 2; i++ {
+		if _, err := js.PublishMsg(m, nats.Compress(nats.CompressGzip)); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !bytes.Equal(m.Data, data) || m.Header.Get(nats.CompressionHdr) != "" {
+			t.Fatal("Published message was modified")
+		}
+		select {
+		case <-msgs:
+		case <-time.After(time.Second):
+			t.Fatal("Did not receive message")
+		}
+	}
+	if _, err := js.Publish("FOO.1", data, nats.Compress(nats.CompressGzip)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	am := nats.NewMsg("FOO.2")
+	am.Data = data
+	paf, err := js.PublishMsgAsync(am, nats.Compress(nats.CompressS2))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !bytes.Equal(am.Data, data) || am.Header.Get(nats.CompressionHdr) != "" {
+		t.Fatal("Published message was modified")
+	}
+	select {
+	case <-paf.Ok():
+	case err := <-paf.Err():
+		t.Fatalf("Unexpected error: %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("Did not receive ack")
+	}
+
+	// Stored compressed, and decompressed by the subscription.
+	for _, subj := range []string{"FOO.1", "FOO.2"} {
+		rm, err := js.GetLastMsg("foo", subj)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if rm.Header.Get(nats.CompressionHdr) == "" || len(rm.Data) >= len(data) {
+			t.Fatalf("Expected compressed message, got %d bytes with headers %v", len(rm.Data), rm.Header)
+		}
+		select {
+		case m := <-msgs:
+			if !bytes.Equal(m.Data, data) {
+				t.Fatalf("Unexpected payload: %q", m.Data)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Did not receive message")
+		}
+	}
+}
+
 func TestMsgDeleteMarkerMaxAge(t *testing.T) {
 	srv := RunBasicJetStreamServer()
 	defer shutdownJSServerAndRemoveStorage(t, srv)
@@ -1072,6 +1184,47 @@ func TestJetStreamSubscribe(t *testing.T) {
 	}
 }
 
+func TestJetStreamSubscribeSetMsgHandler(t *testing.T) {
+	s := RunBasicJetStreamServer()
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	nc, js := jsClient(t, s)
+	defer nc.Close()
+
+	if _, err := js.AddStream(&nats.StreamConfig{Name: "TEST", Subjects: []string{"foo"}}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	msgs := make(chan *nats.Msg, 10)
+	sub, err := js.Subscribe("foo", func(m *nats.Msg) { msgs <- m })
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	// The callback can't be replaced, that would bypass auto acks.
+	if err := sub.SetMsgHandler(func(m *nats.Msg) {}); err != nats.ErrTypeSubscription {
+		t.Fatalf("Expected %v, got %v", nats.ErrTypeSubscription, err)
+	}
+	if _, err := js.Publish("foo", []byte("hello")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	select {
+	case <-msgs:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Did not receive message")
+	}
+	checkFor(t, 2*time.Second, 15*time.Millisecond, func() error {
+		ci, err := sub.ConsumerInfo()
+		if err != nil {
+			return err
+		}
+		if ci.NumAckPending != 0 || ci.AckFloor.Consumer != 1 {
+			return fmt.Errorf("Expected message to be acked, got %+v", ci)
+		}
+		return nil
+	})
+}
+
 func TestJetStreamSubscribe_SkipConsumerLookup(t *testing.T) {
 	s := RunBasicJetStreamServer()
 	defer shutdownJSServerAndRemoveStorage(t, s)
@@ -533,6 +533,48 @@ func TestKeyValueWatch(t *testing.T) {
 	})
 }
 
+func TestKeyValueWatchNotIntercepted(t *testing.T) {
+	s := RunBasicJetStreamServer()
+	defer shutdownJSServerAndRemoveStorage(t, s)
+
+	nc, js := jsClient(t, s)
+	defer nc.Close()
+
+	// Drop every message of the wrapped subscriptions.
+	nc.Use(func(next nats.MsgHandler) nats.MsgHandler {
+		return func(m *nats.Msg) {}
+	})
+
+	kv, err := js.CreateKeyValue(&nats.KeyValueConfig{Bucket: "WATCH"})
+	expectOk(t, err)
+	_, err = kv.Put("name", []byte("derek"))
+	expectOk(t, err)
+
+	watcher, err := kv.WatchAll()
+	expectOk(t, err)
+	defer watcher.Stop()
+
+	select {
+	case e := <-watcher.Updates():
+		if e == nil || e.Key() != "name" {
+			t.Fatalf("Unexpected update: %+v", e)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Did not receive update")
+	}
+
+	// The JetStream async publish replies are not wrapped either.
+	paf, err := js.PublishAsync("$KV.WATCH.age", []byte("22"))
+	expectOk(t, err)
+	select {
+	case <-paf.Ok():
+	case err := <-paf.Err():
+		t.Fatalf("Error on publish: %v", err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Did not receive ack")
+	}
+}
+
 func TestKeyValueWatchContext(t *testing.T) {
 	s := RunBasicJetStreamServer()
 	defer shutdownJSServerAndRemoveStorage(t, s)
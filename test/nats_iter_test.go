@@ -16,9 +16,11 @@
 package test
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -334,3 +336,229 @@ func TestQueueSubscribeIterator(t *testing.T) {
 		}
 	})
 }
+
+func TestRequestMany(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer nc.Close()
+
+	for i := 0; i < 5; i++ {
+		id := i
+		if _, err := nc.Subscribe("svc", func(m *nats.Msg) {
+			m.Respond([]byte(fmt.Sprintf("%d", id)))
+		}); err != nil {
+			t.Fatalf("Error on subscribe: %v", err)
+		}
+	}
+	nc.Flush()
+
+	// Responses are received by the subscription shared with Request,
+	// create it first so that it is not reported as a leak.
+	if _, err := nc.Request("svc", nil, time.Second); err != nil {
+		t.Fatalf("Error on request: %v", err)
+	}
+
+	collect := func(seq func(func(*nats.Msg, error) bool)) (int, error) {
+		t.Helper()
+		received := 0
+		for _, err := range seq {
+			if err != nil {
+				return received, err
+			}
+			received++
+		}
+		return received, nil
+	}
+
+	t.Run("max wait", func(t *testing.T) {
+		base := getStableNumGoroutine(t)
+		start := time.Now()
+		received, err := collect(nc.RequestMany("svc", nil, nats.RequestManyMaxWait(200*time.Millisecond)))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if received != 5 {
+			t.Fatalf("Expected 5 responses, got %d", received)
+		}
+		if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+			t.Fatalf("Expected to wait for max wait, got %v", elapsed)
+		}
+		checkNoGoroutineLeak(t, base, "RequestMany")
+	})
+
+	t.Run("max messages", func(t *testing.T) {
+		start := time.Now()
+		received, err := collect(nc.RequestMany("svc", nil, nats.RequestManyMaxMsgs(3), nats.RequestManyMaxWait(5*time.Second)))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if received != 3 {
+			t.Fatalf("Expected 3 responses, got %d", received)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("Expected to return once max messages was reached, got %v", elapsed)
+		}
+	})
+
+	t.Run("stall", func(t *testing.T) {
+		start := time.Now()
+		received, err := collect(nc.RequestMany("svc", nil, nats.RequestManyStall(100*time.Millisecond), nats.RequestManyMaxWait(5*time.Second)))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if received != 5 {
+			t.Fatalf("Expected 5 responses, got %d", received)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("Expected to return after stall, got %v", elapsed)
+		}
+	})
+
+	t.Run("sentinel", func(t *testing.T) {
+		// Responders may reply in any order, so stop on the third response.
+		var seen int
+		received, err := collect(nc.RequestMany("svc", nil,
+			nats.RequestManySentinel(func(m *nats.Msg) bool { seen++; return seen == 3 })))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if received != 2 {
+			t.Fatalf("Expected 2 responses, got %d", received)
+		}
+	})
+
+	t.Run("stop iterating", func(t *testing.T) {
+		base := getStableNumGoroutine(t)
+		subs := nc.NumSubscriptions()
+		for range nc.RequestMany("svc", nil) {
+			break
+		}
+		checkNoGoroutineLeak(t, base, "RequestMany")
+		if n := nc.NumSubscriptions(); n != subs {
+			t.Fatalf("Expected %d subscriptions, got %d", subs, n)
+		}
+	})
+
+	t.Run("shared response subscription", func(t *testing.T) {
+		// The 5 responders and the response subscription.
+		if n := nc.NumSubscriptions(); n != 6 {
+			t.Fatalf("Expected 6 subscriptions, got %d", n)
+		}
+		// Requests in flight at the same time get their own responses.
+		var wg sync.WaitGroup
+		errs := make(chan error, 10)
+		for i := 0; i < 5; i++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				received, err := collect(nc.RequestMany("svc", nil, nats.RequestManyMaxMsgs(5), nats.RequestManyMaxWait(5*time.Second)))
+				if err == nil && received != 5 {
+					err = fmt.Errorf("expected 5 responses, got %d", received)
+				}
+				errs <- err
+			}()
+			go func() {
+				defer wg.Done()
+				_, err := nc.Request("svc", nil, 5*time.Second)
+				errs <- err
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+		}
+		if n := nc.NumSubscriptions(); n != 6 {
+			t.Fatalf("Expected 6 subscriptions, got %d", n)
+		}
+	})
+
+	t.Run("context canceled", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		received, err := collect(nc.RequestManyWithContext(ctx, "svc", nil, nats.RequestManyMaxWait(5*time.Second)))
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Expected %v, got %v", context.DeadlineExceeded, err)
+		}
+		if received != 5 {
+			t.Fatalf("Expected 5 responses, got %d", received)
+		}
+	})
+
+	t.Run("no responders", func(t *testing.T) {
+		_, err := collect(nc.RequestMany("nobody", nil))
+		if !errors.Is(err, nats.ErrNoResponders) {
+			t.Fatalf("Expected %v, got %v", nats.ErrNoResponders, err)
+		}
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		_, err := collect(nc.RequestMany("svc", nil, nats.RequestManyMaxMsgs(0)))
+		if !errors.Is(err, nats.ErrInvalidArg) {
+			t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+		}
+	})
+}
+
+func TestRequestWithResponderInfo(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer nc.Close()
+
+	for i := 0; i < 3; i++ {
+		id := fmt.Sprintf("svc-%d", i)
+		delay := time.Duration(i) * 20 * time.Millisecond
+		if _, err := nc.Subscribe("svc", func(m *nats.Msg) {
+			time.Sleep(delay)
+			resp := nats.NewMsg(m.Reply)
+			resp.Header.Set(nats.ResponderHdr, id)
+			m.RespondMsg(resp)
+		}); err != nil {
+			t.Fatalf("Error on subscribe: %v", err)
+		}
+	}
+	nc.Flush()
+
+	// Single round trip with Request.
+	msg, err := nc.Request("svc", nil, time.Second)
+	if err != nil {
+		t.Fatalf("Error on request: %v", err)
+	}
+	if r := msg.Responder(); !strings.HasPrefix(r, "svc-") {
+		t.Fatalf("Expected a responder identity, got %q", r)
+	}
+
+	responders := make(map[string]time.Duration)
+	for ri, err := range nc.RequestWithResponderInfo("svc", nil, nats.RequestManyMaxMsgs(3)) {
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if ri.Msg == nil || ri.Msg.Responder() != ri.Responder {
+			t.Fatalf("Expected the response message, got %+v", ri)
+		}
+		responders[ri.Responder] = ri.Latency
+	}
+	if len(responders) != 3 {
+		t.Fatalf("Expected 3 responders, got %v", responders)
+	}
+	if responders["svc-2"] < 40*time.Millisecond {
+		t.Fatalf("Expected latency of slowest responder to be at least 40ms, got %v", responders["svc-2"])
+	}
+
+	// Responses without the header have no responder identity.
+	if (&nats.Msg{}).Responder() != "" {
+		t.Fatalf("Expected empty responder")
+	}
+}
@@ -909,6 +909,43 @@ func TestGetRTT(t *testing.T) {
 	}
 }
 
+func TestRTTStats(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.ClientURL(), nats.PingInterval(20*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Expected to connect to server, got %v", err)
+	}
+	defer nc.Close()
+
+	if last, min, max, avg := nc.RTTStats(); last != 0 || min != 0 || max != 0 || avg != 0 {
+		t.Fatalf("Expected no stats before any ping, got %v %v %v %v", last, min, max, avg)
+	}
+
+	// Keepalive pings update the stats without calling RTT or Flush.
+	waitFor(t, time.Second, 15*time.Millisecond, func() error {
+		if last, _, _, _ := nc.RTTStats(); last == 0 {
+			return fmt.Errorf("No round trip recorded yet")
+		}
+		return nil
+	})
+
+	// Run past the size of the history.
+	for i := 0; i < 50; i++ {
+		if err := nc.Flush(); err != nil {
+			t.Fatalf("Error on flush: %v", err)
+		}
+	}
+	last, min, max, avg := nc.RTTStats()
+	if last <= 0 || last > time.Second {
+		t.Fatalf("Unexpected last RTT: %v", last)
+	}
+	if min > last || max < last || avg < min || avg > max {
+		t.Fatalf("Inconsistent stats: last=%v min=%v max=%v avg=%v", last, min, max, avg)
+	}
+}
+
 func TestGetClientIP(t *testing.T) {
 	s := RunServerOnPort(-1)
 	defer s.Shutdown()
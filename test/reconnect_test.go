@@ -14,11 +14,13 @@
 package test
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
 	"net"
 	"net/url"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -656,6 +658,354 @@ func TestReconnectBufSize(t *testing.T) {
 	nc.Buffered()
 }
 
+func TestTryPublishHighWater(t *testing.T) {
+	s := RunDefaultServer()
+	defer func() { s.Shutdown() }()
+
+	dch := make(chan bool, 1)
+	rch := make(chan bool, 1)
+	nc, err := nats.Connect(nats.DefaultURL,
+		nats.PendingBufferHighWater(64),
+		nats.ReconnectWait(50*time.Millisecond),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, _ error) { dch <- true }),
+		nats.ReconnectHandler(func(_ *nats.Conn) { rch <- true }))
+	if err != nil {
+		t.Fatalf("Should have connected ok: %v", err)
+	}
+	defer nc.Close()
+
+	if _, err := nats.Connect(nats.DefaultURL, nats.PendingBufferHighWater(0)); !errors.Is(err, nats.ErrInvalidArg) {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+	}
+
+	// Nothing gets flushed while disconnected.
+	s.Shutdown()
+	if e := Wait(dch); e != nil {
+		t.Fatal("DisconnectedErrCB should have been triggered")
+	}
+
+	msg := []byte("food") // 4 bytes payload, total proto is 17 bytes
+	for i := 0; i < 3; i++ {
+		if err := nc.TryPublish("foo", msg); err != nil {
+			t.Fatalf("Failed to publish message %d: %v", i, err)
+		}
+	}
+	if err := nc.TryPublish("foo", msg); !errors.Is(err, nats.ErrOutboundBufferFull) {
+		t.Fatalf("Expected %v, got %v", nats.ErrOutboundBufferFull, err)
+	}
+	if n, _ := nc.Buffered(); n != 51 {
+		t.Fatalf("Expected 51 bytes buffered, got %d", n)
+	}
+	// Regular publish still buffers.
+	if err := nc.Publish("foo", msg); err != nil {
+		t.Fatalf("Failed to publish message: %v", err)
+	}
+
+	s = RunDefaultServer()
+	if e := Wait(rch); e != nil {
+		t.Fatal("ReconnectedCB should have been triggered")
+	}
+	if err := nc.Flush(); err != nil {
+		t.Fatalf("Error during flush: %v", err)
+	}
+	// A message larger than the mark goes through when nothing is buffered.
+	if err := nc.TryPublish("foo", make([]byte, 128)); err != nil {
+		t.Fatalf("Failed to publish large message: %v", err)
+	}
+	if err := nc.Flush(); err != nil {
+		t.Fatalf("Error during flush: %v", err)
+	}
+	if err := nc.TryPublish("foo", msg); err != nil {
+		t.Fatalf("Failed to publish message: %v", err)
+	}
+	nc.Close()
+	if err := nc.TryPublish("foo", msg); err != nats.ErrConnectionClosed {
+		t.Fatalf("Expected %v, got %v", nats.ErrConnectionClosed, err)
+	}
+}
+
+func TestOutboxDir(t *testing.T) {
+	s := RunDefaultServer()
+	defer func() { s.Shutdown() }()
+
+	dir := t.TempDir()
+	dch := make(chan bool, 1)
+	nc, err := nats.Connect(nats.DefaultURL,
+		nats.OutboxDir(dir),
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(50*time.Millisecond),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, _ error) { dch <- true }))
+	if err != nil {
+		t.Fatalf("Should have connected ok: %v", err)
+	}
+	defer nc.Close()
+
+	s.Shutdown()
+	if e := Wait(dch); e != nil {
+		t.Fatal("DisconnectedErrCB should have been triggered")
+	}
+	for i := 1; i <= 5; i++ {
+		m := nats.NewMsg("foo")
+		m.Header.Set("Seq", strconv.Itoa(i))
+		m.Data = []byte(fmt.Sprintf("msg %d", i))
+		if err := nc.PublishMsg(m); err != nil {
+			t.Fatalf("Error on publish: %v", err)
+		}
+	}
+	if count, bytes := nc.OutboxPending(); count != 5 || bytes == 0 {
+		t.Fatalf("Expected 5 messages in outbox, got %d (%d bytes)", count, bytes)
+	}
+	// Simulate the process exiting while disconnected.
+	nc.Close()
+
+	// A new "process" that can't connect yet queues more messages after
+	// the ones of the previous one.
+	rch := make(chan bool, 1)
+	nc, err = nats.Connect(nats.DefaultURL,
+		nats.OutboxDir(dir),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(50*time.Millisecond),
+		nats.ConnectHandler(func(_ *nats.Conn) { rch <- true }))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer nc.Close()
+	if count, _ := nc.OutboxPending(); count != 5 {
+		t.Fatalf("Expected 5 messages in outbox, got %d", count)
+	}
+	sub, err := nc.SubscribeSync("foo")
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	for i := 6; i <= 10; i++ {
+		if err := nc.Publish("foo", []byte(fmt.Sprintf("msg %d", i))); err != nil {
+			t.Fatalf("Error on publish: %v", err)
+		}
+	}
+	if count, _ := nc.OutboxPending(); count != 10 {
+		t.Fatalf("Expected 10 messages in outbox, got %d", count)
+	}
+
+	s = RunDefaultServer()
+	if e := Wait(rch); e != nil {
+		t.Fatal("ConnectedCB should have been triggered")
+	}
+	for i := 1; i <= 10; i++ {
+		m, err := sub.NextMsg(time.Second)
+		if err != nil {
+			t.Fatalf("Error receiving message %d: %v", i, err)
+		}
+		if string(m.Data) != fmt.Sprintf("msg %d", i) {
+			t.Fatalf("Expected message %d, got %q", i, m.Data)
+		}
+		if i <= 5 && m.Header.Get("Seq") != strconv.Itoa(i) {
+			t.Fatalf("Unexpected headers: %v", m.Header)
+		}
+	}
+	if count, bytes := nc.OutboxPending(); count != 0 || bytes != 0 {
+		t.Fatalf("Expected empty outbox, got %d (%d bytes)", count, bytes)
+	}
+	// Once connected, messages are not stored.
+	if err := nc.Publish("foo", []byte("direct")); err != nil {
+		t.Fatalf("Error on publish: %v", err)
+	}
+	if _, err := sub.NextMsg(time.Second); err != nil {
+		t.Fatalf("Error receiving message: %v", err)
+	}
+	if count, _ := nc.OutboxPending(); count != 0 {
+		t.Fatalf("Expected empty outbox, got %d", count)
+	}
+}
+
+func TestOutboxMaxBytes(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	if _, err := nats.Connect(nats.DefaultURL, nats.OutboxMaxBytes(0)); !errors.Is(err, nats.ErrInvalidArg) {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+	}
+
+	dir := t.TempDir()
+	dch := make(chan bool, 1)
+	nc, err := nats.Connect(nats.DefaultURL,
+		nats.OutboxDir(dir),
+		nats.OutboxMaxBytes(64),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, _ error) { dch <- true }))
+	if err != nil {
+		t.Fatalf("Should have connected ok: %v", err)
+	}
+	defer nc.Close()
+
+	s.Shutdown()
+	if e := Wait(dch); e != nil {
+		t.Fatal("DisconnectedErrCB should have been triggered")
+	}
+	msg := []byte("food") // 17 bytes protocol, 21 bytes frame
+	for i := 0; i < 3; i++ {
+		if err := nc.Publish("foo", msg); err != nil {
+			t.Fatalf("Failed to publish message: %v", err)
+		}
+	}
+	if err := nc.Publish("foo", msg); err != nats.ErrReconnectBufExceeded {
+		t.Fatalf("Expected %v, got %v", nats.ErrReconnectBufExceeded, err)
+	}
+	if count, bytes := nc.OutboxPending(); count != 3 || bytes != 63 {
+		t.Fatalf("Expected 3 messages in outbox, got %d (%d bytes)", count, bytes)
+	}
+	nc.Close()
+
+	// Messages are sent on connect by the next connection using the outbox.
+	s = RunDefaultServer()
+	defer s.Shutdown()
+	nc2 := NewDefaultConnection(t)
+	defer nc2.Close()
+	sub, err := nc2.SubscribeSync("foo")
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	nc2.Flush()
+	nc, err = nats.Connect(nats.DefaultURL, nats.OutboxDir(dir))
+	if err != nil {
+		t.Fatalf("Should have connected ok: %v", err)
+	}
+	defer nc.Close()
+	for i := 0; i < 3; i++ {
+		if _, err := sub.NextMsg(time.Second); err != nil {
+			t.Fatalf("Error receiving message %d: %v", i, err)
+		}
+	}
+	if count, _ := nc.OutboxPending(); count != 0 {
+		t.Fatalf("Expected empty outbox, got %d", count)
+	}
+	// The replay is accounted for in the write stats.
+	if flushes, written, coalesced := nc.WriteStats(); flushes == 0 || written < 51 || coalesced < 3 {
+		t.Fatalf("Unexpected write stats: %d flushes, %d bytes, %d messages", flushes, written, coalesced)
+	}
+}
+
+func TestOutboxReplayedInChunks(t *testing.T) {
+	s := RunDefaultServer()
+	defer func() { s.Shutdown() }()
+
+	dir := t.TempDir()
+	dch := make(chan bool, 1)
+	rch := make(chan bool, 1)
+	nc, err := nats.Connect(nats.DefaultURL,
+		nats.OutboxDir(dir),
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(50*time.Millisecond),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, _ error) { dch <- true }),
+		nats.ReconnectHandler(func(_ *nats.Conn) { rch <- true }))
+	if err != nil {
+		t.Fatalf("Should have connected ok: %v", err)
+	}
+	defer nc.Close()
+	sub, err := nc.SubscribeSync("foo")
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	nc.Flush()
+
+	s.Shutdown()
+	if e := Wait(dch); e != nil {
+		t.Fatal("DisconnectedErrCB should have been triggered")
+	}
+	// Enough messages for the replay to take several chunks.
+	payload := make([]byte, 1024)
+	const stored = 200
+	for i := 0; i < stored; i++ {
+		if err := nc.Publish("foo", append([]byte(strconv.Itoa(i)+":"), payload...)); err != nil {
+			t.Fatalf("Error on publish: %v", err)
+		}
+	}
+
+	s = RunDefaultServer()
+	if e := Wait(rch); e != nil {
+		t.Fatal("ReconnectedCB should have been triggered")
+	}
+	// Messages published while the outbox is replayed are sent after it.
+	const total = stored + 50
+	for i := stored; i < total; i++ {
+		if err := nc.Publish("foo", append([]byte(strconv.Itoa(i)+":"), payload...)); err != nil {
+			t.Fatalf("Error on publish: %v", err)
+		}
+	}
+	for i := 0; i < total; i++ {
+		m, err := sub.NextMsg(2 * time.Second)
+		if err != nil {
+			t.Fatalf("Error receiving message %d: %v", i, err)
+		}
+		if seq, _, _ := strings.Cut(string(m.Data), ":"); seq != strconv.Itoa(i) {
+			t.Fatalf("Expected message %d, got %s", i, seq)
+		}
+	}
+	if count, bytes := nc.OutboxPending(); count != 0 || bytes != 0 {
+		t.Fatalf("Expected empty outbox, got %d (%d bytes)", count, bytes)
+	}
+}
+
+func TestReconnectBufReplayedInOrder(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	dch := make(chan bool, 2)
+	rch := make(chan bool, 2)
+	nc, err := nats.Connect(nats.DefaultURL,
+		nats.ReconnectBufSize(1024),
+		nats.ReconnectWait(50*time.Millisecond),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, _ error) { dch <- true }),
+		nats.ReconnectHandler(func(_ *nats.Conn) { rch <- true }))
+	if err != nil {
+		t.Fatalf("Should have connected ok: %v", err)
+	}
+	defer nc.Close()
+
+	sub, err := nc.SubscribeSync("foo")
+	if err != nil {
+		t.Fatalf("Error subscribing: %v", err)
+	}
+	nc.Flush()
+
+	s.Shutdown()
+	if e := Wait(dch); e != nil {
+		t.Fatal("DisconnectedErrCB should have been triggered")
+	}
+
+	total := 10
+	for i := 0; i < total; i++ {
+		if err := nc.Publish("foo", []byte(fmt.Sprintf("%d", i))); err != nil {
+			t.Fatalf("Failed to publish message: %v", err)
+		}
+	}
+	// Exceeding the buffer is reported to the publisher.
+	nc.Publish("bar", make([]byte, 1024))
+	if err := nc.Publish("bar", nil); err != nats.ErrReconnectBufExceeded {
+		t.Fatalf("Expected %v, got %v", nats.ErrReconnectBufExceeded, err)
+	}
+
+	s = RunDefaultServer()
+	defer s.Shutdown()
+	if e := Wait(rch); e != nil {
+		t.Fatal("Should have reconnected")
+	}
+
+	for i := 0; i < total; i++ {
+		m, err := sub.NextMsg(2 * time.Second)
+		if err != nil {
+			t.Fatalf("Error getting message %d: %v", i, err)
+		}
+		if string(m.Data) != fmt.Sprintf("%d", i) {
+			t.Fatalf("Expected message %d, got %q", i, m.Data)
+		}
+	}
+	if _, err := sub.NextMsg(100 * time.Millisecond); err != nats.ErrTimeout {
+		t.Fatalf("Expected no more messages, got %v", err)
+	}
+}
+
 // When a cluster is fronted by a single DNS name (desired) but communicates IPs to clients (also desired),
 // and we use TLS, we want to make sure we do the right thing connecting to an IP directly for TLS to work.
 // The reason this may happen is that the cluster has a single DNS name and a single certificate, but the cluster
@@ -882,6 +1232,81 @@ func TestAuthExpiredReconnect(t *testing.T) {
 	nc.Close()
 }
 
+func TestReconnectCredentialsCB(t *testing.T) {
+	ts := runTrustServer()
+	defer ts.Shutdown()
+
+	akp, err := nkeys.FromSeed(aSeed)
+	if err != nil {
+		t.Fatalf("Error creating account key pair: %v", err)
+	}
+	// A different user for each connect attempt.
+	var mu sync.Mutex
+	var users []string
+	credsCB := func() (string, nats.SignatureHandler, error) {
+		ukp, err := nkeys.CreateUser()
+		if err != nil {
+			return "", nil, err
+		}
+		upub, _ := ukp.PublicKey()
+		claims := jwt.NewUserClaims(upub)
+		ujwt, err := claims.Encode(akp)
+		if err != nil {
+			return "", nil, err
+		}
+		mu.Lock()
+		users = append(users, upub)
+		mu.Unlock()
+		return ujwt, ukp.Sign, nil
+	}
+	authUser := func(s *server.Server) string {
+		t.Helper()
+		connz, err := s.Connz(&server.ConnzOptions{Username: true})
+		if err != nil || len(connz.Conns) != 1 {
+			t.Fatalf("Unexpected connz: %+v, %v", connz, err)
+		}
+		return connz.Conns[0].AuthorizedUser
+	}
+
+	if _, err := nats.Connect(ts.ClientURL(), nats.ReconnectCredentialsCB(credsCB), nats.UserJWT(func() (string, error) {
+		return uJWT, nil
+	}, func([]byte) ([]byte, error) { return nil, nil })); !errors.Is(err, nats.ErrInvalidArg) {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+	}
+
+	rch := make(chan struct{}, 1)
+	nc, err := nats.Connect(ts.ClientURL(),
+		nats.ReconnectCredentialsCB(credsCB),
+		nats.ReconnectWait(50*time.Millisecond),
+		nats.ReconnectHandler(func(_ *nats.Conn) { rch <- struct{}{} }))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer nc.Close()
+
+	mu.Lock()
+	first := users[len(users)-1]
+	mu.Unlock()
+	if user := authUser(ts); user != first {
+		t.Fatalf("Expected user %q, got %q", first, user)
+	}
+
+	ts.Shutdown()
+	ts = runTrustServer()
+	defer ts.Shutdown()
+	WaitOnChannel(t, rch, struct{}{})
+
+	mu.Lock()
+	last := users[len(users)-1]
+	mu.Unlock()
+	if last == first {
+		t.Fatal("Expected new credentials on reconnect")
+	}
+	if user := authUser(ts); user != last {
+		t.Fatalf("Expected user %q, got %q", last, user)
+	}
+}
+
 func TestForceReconnect(t *testing.T) {
 	s := RunDefaultServer()
 
@@ -1141,3 +1566,218 @@ func TestAuthExpiredForceReconnect(t *testing.T) {
 	WaitOnChannel(t, newStatus, nats.RECONNECTING)
 	WaitOnChannel(t, newStatus, nats.CONNECTED)
 }
+
+func TestLameDuckModeReconnect(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	for _, reconnect := range []bool{false, true} {
+		t.Run(fmt.Sprintf("reconnect=%v", reconnect), func(t *testing.T) {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("Could not listen on an ephemeral port: %v", err)
+			}
+			defer l.Close()
+
+			// Fake server entering lame duck mode once the client is connected.
+			done := make(chan struct{})
+			defer close(done)
+			go func() {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				conn.Write([]byte("INFO {\"server_id\":\"ldm\"}\r\n"))
+				br := bufio.NewReader(conn)
+				br.ReadLine()
+				br.ReadLine()
+				conn.Write([]byte("PONG\r\n"))
+				time.Sleep(100 * time.Millisecond)
+				conn.Write([]byte("INFO {\"ldm\":true}\r\n"))
+				// Keep answering pings.
+				for {
+					line, _, err := br.ReadLine()
+					if err != nil {
+						return
+					}
+					if string(line) == "PING" {
+						conn.Write([]byte("PONG\r\n"))
+					}
+				}
+			}()
+
+			ldmURL := fmt.Sprintf("nats://%s", l.Addr().String())
+			ldmCh := make(chan bool, 1)
+			rch := make(chan bool, 1)
+			opts := []nats.Option{
+				nats.DontRandomize(),
+				nats.LameDuckModeHandler(func(_ *nats.Conn) { ldmCh <- true }),
+				nats.ReconnectHandler(func(_ *nats.Conn) { rch <- true }),
+			}
+			if reconnect {
+				opts = append(opts, nats.ReconnectOnLameDuck(200*time.Millisecond))
+			}
+			nc, err := nats.Connect(ldmURL+","+nats.DefaultURL, opts...)
+			if err != nil {
+				t.Fatalf("Error on connect: %v", err)
+			}
+			defer nc.Close()
+			if url := nc.ConnectedUrl(); url != ldmURL {
+				t.Fatalf("Expected to be connected to %q, got %q", ldmURL, url)
+			}
+
+			WaitOnChannel(t, ldmCh, true)
+			if !reconnect {
+				// The client waits to be disconnected by the server.
+				select {
+				case <-rch:
+					t.Fatal("Did not expect to reconnect")
+				case <-time.After(500 * time.Millisecond):
+				}
+				if url := nc.ConnectedUrl(); url != ldmURL {
+					t.Fatalf("Expected to be connected to %q, got %q", ldmURL, url)
+				}
+				return
+			}
+			// The fake server keeps the connection open, the client moves on its own.
+			WaitOnChannel(t, rch, true)
+			if url := nc.ConnectedUrl(); url != nats.DefaultURL {
+				t.Fatalf("Expected to be connected to %q, got %q", nats.DefaultURL, url)
+			}
+		})
+	}
+
+	if _, err := nats.Connect(nats.DefaultURL, nats.ReconnectOnLameDuck(-time.Second)); !errors.Is(err, nats.ErrInvalidArg) {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+	}
+}
+
+func TestResubscribeComplete(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	dch := make(chan bool, 1)
+	nc, err := nats.Connect(nats.DefaultURL,
+		nats.ReconnectWait(50*time.Millisecond),
+		nats.ReconnectJitter(0, 0),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, _ error) { dch <- true }))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer nc.Close()
+
+	for i := 0; i < 10; i++ {
+		subj := fmt.Sprintf("svc.%d", i)
+		if _, err := nc.Subscribe(subj, func(m *nats.Msg) { m.Respond([]byte("ok")) }); err != nil {
+			t.Fatalf("Error on subscribe: %v", err)
+		}
+	}
+	nc.Flush()
+
+	select {
+	case <-nc.ResubscribeComplete():
+	default:
+		t.Fatal("Channel should be closed when connected")
+	}
+
+	s.Shutdown()
+	WaitOnChannel(t, dch, true)
+	ch := nc.ResubscribeComplete()
+	select {
+	case <-ch:
+		t.Fatal("Channel should not be closed while reconnecting")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	s = RunDefaultServer()
+	defer s.Shutdown()
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Channel should have been closed after reconnect")
+	}
+
+	// Interest of all responders is restored.
+	rc := NewDefaultConnection(t)
+	defer rc.Close()
+	for i := 0; i < 10; i++ {
+		if _, err := rc.Request(fmt.Sprintf("svc.%d", i), nil, time.Second); err != nil {
+			t.Fatalf("Error on request %d: %v", i, err)
+		}
+	}
+
+	// Closing the connection does not leave anyone waiting.
+	s.Shutdown()
+	WaitOnChannel(t, dch, true)
+	ch = nc.ResubscribeComplete()
+	nc.Close()
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatal("Channel should have been closed when the connection closed")
+	}
+}
+
+func TestHoldDeliveryDuringReconnect(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	dch := make(chan bool, 1)
+	rch := make(chan bool, 1)
+	nc, err := nats.Connect(nats.DefaultURL,
+		nats.HoldDeliveryDuringReconnect(),
+		nats.ReconnectWait(50*time.Millisecond),
+		nats.ReconnectJitter(0, 0),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, _ error) { dch <- true }),
+		nats.ReconnectHandler(func(_ *nats.Conn) { rch <- true }))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer nc.Close()
+
+	block := make(chan struct{})
+	received := make(chan int, 20)
+	var count int
+	if _, err := nc.Subscribe("foo", func(m *nats.Msg) {
+		count++
+		if count == 1 {
+			<-block
+		}
+		n, _ := strconv.Atoi(string(m.Data))
+		received <- n
+	}); err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		nc.Publish("foo", []byte(strconv.Itoa(i)))
+	}
+	nc.Flush()
+
+	// Messages after the first one are pending when the server goes away.
+	s.Shutdown()
+	WaitOnChannel(t, dch, true)
+	close(block)
+	if n := <-received; n != 0 {
+		t.Fatalf("Expected message 0, got %d", n)
+	}
+	select {
+	case n := <-received:
+		t.Fatalf("No message should be delivered while reconnecting, got %d", n)
+	case <-time.After(250 * time.Millisecond):
+	}
+
+	s = RunDefaultServer()
+	defer s.Shutdown()
+	WaitOnChannel(t, rch, true)
+	for i := 1; i < 10; i++ {
+		select {
+		case n := <-received:
+			if n != i {
+				t.Fatalf("Expected message %d, got %d", i, n)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timeout waiting for message %d", i)
+		}
+	}
+}
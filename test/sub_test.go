@@ -14,9 +14,15 @@
 package test
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"reflect"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -72,6 +78,87 @@ func TestServerAutoUnsub(t *testing.T) {
 	checkNoGoroutineLeak(t, base, "AutoUnsubscribe() limit reached")
 }
 
+func TestSubscriptionClosedReason(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	reasons := make(chan nats.ClosedReason, 10)
+	handler := func(subject string, reason nats.ClosedReason) {
+		reasons <- reason
+	}
+	check := func(sub *nats.Subscription, expected nats.ClosedReason) {
+		t.Helper()
+		select {
+		case r := <-reasons:
+			if r != expected {
+				t.Fatalf("Expected handler reason %v, got %v", expected, r)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("Closed handler was not invoked")
+		}
+		if r, err := sub.ClosedReason(); err != nil || r != expected {
+			t.Fatalf("Expected reason %v, got %v - %v", expected, r, err)
+		}
+	}
+
+	// Async subscription reaching its AutoUnsubscribe limit.
+	sub, err := nc.Subscribe("foo", func(_ *nats.Msg) {})
+	if err != nil {
+		t.Fatalf("Error subscribing: %v", err)
+	}
+	sub.SetClosedHandlerEx(handler)
+	if r, err := sub.ClosedReason(); err != nil || r != nats.ClosedReasonNone {
+		t.Fatalf("Expected reason %v, got %v - %v", nats.ClosedReasonNone, r, err)
+	}
+	sub.AutoUnsubscribe(2)
+	nc.Publish("foo", nil)
+	nc.Publish("foo", nil)
+	check(sub, nats.ClosedReasonMaxMessages)
+
+	// Sync subscription reaching its AutoUnsubscribe limit.
+	sub, err = nc.SubscribeSync("foo")
+	if err != nil {
+		t.Fatalf("Error subscribing: %v", err)
+	}
+	sub.SetClosedHandlerEx(handler)
+	sub.AutoUnsubscribe(1)
+	nc.Publish("foo", nil)
+	if _, err := sub.NextMsg(time.Second); err != nil {
+		t.Fatalf("Error on next msg: %v", err)
+	}
+	check(sub, nats.ClosedReasonMaxMessages)
+
+	// Explicit unsubscribe.
+	sub, err = nc.SubscribeSync("foo")
+	if err != nil {
+		t.Fatalf("Error subscribing: %v", err)
+	}
+	sub.SetClosedHandlerEx(handler)
+	sub.Unsubscribe()
+	check(sub, nats.ClosedReasonUnsubscribe)
+
+	// Drain.
+	sub, err = nc.Subscribe("foo", func(_ *nats.Msg) {})
+	if err != nil {
+		t.Fatalf("Error subscribing: %v", err)
+	}
+	sub.SetClosedHandlerEx(handler)
+	sub.Drain()
+	check(sub, nats.ClosedReasonDrain)
+
+	// Connection closed.
+	sub, err = nc.Subscribe("foo", func(_ *nats.Msg) {})
+	if err != nil {
+		t.Fatalf("Error subscribing: %v", err)
+	}
+	sub.SetClosedHandlerEx(handler)
+	nc.Close()
+	check(sub, nats.ClosedReasonConnectionClosed)
+}
+
 func TestClientSyncAutoUnsub(t *testing.T) {
 	s := RunDefaultServer()
 	defer s.Shutdown()
@@ -196,6 +283,395 @@ func TestAutoUnsubAndReconnect(t *testing.T) {
 	}
 }
 
+func TestAutoUnsubscribeBytes(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	rch := make(chan bool)
+	nc, err := nats.Connect(nats.DefaultURL,
+		nats.ReconnectWait(50*time.Millisecond),
+		nats.ReconnectJitter(0, 0),
+		nats.ReconnectHandler(func(_ *nats.Conn) { rch <- true }))
+	if err != nil {
+		t.Fatalf("Unable to connect: %v", err)
+	}
+	defer nc.Close()
+
+	// Payload sizes cycle through 10, 20 and 30 bytes.
+	publish := func(subj string, count int) {
+		t.Helper()
+		for i := 0; i < count; i++ {
+			nc.Publish(subj, make([]byte, 10*(1+i%3)))
+		}
+		nc.Flush()
+	}
+
+	t.Run("async", func(t *testing.T) {
+		var received, bytes int32
+		reason := make(chan nats.ClosedReason, 1)
+		sub, err := nc.Subscribe("foo", func(m *nats.Msg) {
+			atomic.AddInt32(&received, 1)
+			atomic.AddInt32(&bytes, int32(len(m.Data)))
+		})
+		if err != nil {
+			t.Fatalf("Failed to subscribe: %v", err)
+		}
+		sub.SetClosedHandlerEx(func(_ string, r nats.ClosedReason) { reason <- r })
+		if err := sub.AutoUnsubscribeBytes(0); err != nats.ErrInvalidArg {
+			t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+		}
+		if err := sub.AutoUnsubscribeBytes(125); err != nil {
+			t.Fatalf("Error on auto unsubscribe: %v", err)
+		}
+
+		// 60 bytes, then restart the server.
+		publish("foo", 3)
+		s.Shutdown()
+		s = RunDefaultServer()
+		if err := Wait(rch); err != nil {
+			t.Fatal("Failed to get the reconnect cb")
+		}
+
+		// The limit is reached with the 3rd message of the next batch:
+		// 60 + 10 + 20 + 30 = 120, then 130 with the 4th message.
+		publish("foo", 30)
+		select {
+		case r := <-reason:
+			if r != nats.ClosedReasonMaxBytes {
+				t.Fatalf("Expected reason %v, got %v", nats.ClosedReasonMaxBytes, r)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Subscription was not closed")
+		}
+		if r, b := atomic.LoadInt32(&received), atomic.LoadInt32(&bytes); r != 7 || b != 130 {
+			t.Fatalf("Expected 7 messages and 130 bytes, got %d and %d", r, b)
+		}
+		if n := nc.NumSubscriptions(); n != 0 {
+			t.Fatalf("Expected no subscription, got %d", n)
+		}
+	})
+	defer s.Shutdown()
+
+	t.Run("sync", func(t *testing.T) {
+		sub, err := nc.SubscribeSync("bar")
+		if err != nil {
+			t.Fatalf("Failed to subscribe: %v", err)
+		}
+		// Combined with a count limit, whichever comes first.
+		sub.AutoUnsubscribe(100)
+		sub.AutoUnsubscribeBytes(50)
+		publish("bar", 10)
+		var bytes int
+		for i := 0; i < 3; i++ {
+			m, err := sub.NextMsg(time.Second)
+			if err != nil {
+				t.Fatalf("Error on next msg: %v", err)
+			}
+			bytes += len(m.Data)
+		}
+		if bytes != 60 {
+			t.Fatalf("Expected 60 bytes, got %d", bytes)
+		}
+		if _, err := sub.NextMsg(100 * time.Millisecond); err != nats.ErrMaxMessages {
+			t.Fatalf("Expected %v, got %v", nats.ErrMaxMessages, err)
+		}
+		if r, _ := sub.ClosedReason(); r != nats.ClosedReasonMaxBytes {
+			t.Fatalf("Expected reason %v, got %v", nats.ClosedReasonMaxBytes, r)
+		}
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		sub, err := nc.SubscribeSync("baz")
+		if err != nil {
+			t.Fatalf("Failed to subscribe: %v", err)
+		}
+		sub.AutoUnsubscribeBytes(25)
+		publish("baz", 10)
+		waitFor(t, time.Second, 15*time.Millisecond, func() error {
+			if n, _, _ := sub.Pending(); n != 10 {
+				return fmt.Errorf("Expected 10 pending messages, got %d", n)
+			}
+			return nil
+		})
+		msgs, err := sub.NextBatch(10, time.Second)
+		if err != nil {
+			t.Fatalf("Error on next batch: %v", err)
+		}
+		if len(msgs) != 2 {
+			t.Fatalf("Expected batch to stop at the limit with 2 messages, got %d", len(msgs))
+		}
+		if sub.IsValid() {
+			t.Fatal("Expected subscription to be removed")
+		}
+		// The messages past the limit are not left accounted as pending.
+		if n := nc.PendingBytesTotal(); n != 0 {
+			t.Fatalf("Expected no pending bytes, got %d", n)
+		}
+	})
+
+	t.Run("chan", func(t *testing.T) {
+		sub, err := nc.ChanSubscribe("bat", make(chan *nats.Msg, 1))
+		if err != nil {
+			t.Fatalf("Failed to subscribe: %v", err)
+		}
+		if err := sub.AutoUnsubscribeBytes(10); err != nats.ErrTypeSubscription {
+			t.Fatalf("Expected %v, got %v", nats.ErrTypeSubscription, err)
+		}
+	})
+}
+
+func TestSubscriptionFlush(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+	pub := NewDefaultConnection(t)
+	defer pub.Close()
+
+	sub, err := nc.SubscribeSync("foo")
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	if err := sub.AutoUnsubscribe(1); err != nil {
+		t.Fatalf("Error on auto unsubscribe: %v", err)
+	}
+	if err := sub.Flush(time.Second); err != nil {
+		t.Fatalf("Error on flush: %v", err)
+	}
+	// Interest is registered, a publish from another connection is received.
+	if err := pub.Publish("foo", []byte("hello")); err != nil {
+		t.Fatalf("Error on publish: %v", err)
+	}
+	if _, err := sub.NextMsg(time.Second); err != nil {
+		t.Fatalf("Error receiving message: %v", err)
+	}
+
+	if err := sub.Flush(time.Second); err != nats.ErrBadSubscription {
+		t.Fatalf("Expected error %v, got %v", nats.ErrBadSubscription, err)
+	}
+	var nilSub *nats.Subscription
+	if err := nilSub.Flush(time.Second); err != nats.ErrBadSubscription {
+		t.Fatalf("Expected error %v, got %v", nats.ErrBadSubscription, err)
+	}
+}
+
+func TestAutoUnsubscribeAfter(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	base := getStableNumGoroutine(t)
+
+	t.Run("deadline", func(t *testing.T) {
+		var received int32
+		reason := make(chan nats.ClosedReason, 2)
+		sub, err := nc.Subscribe("foo", func(_ *nats.Msg) {
+			atomic.AddInt32(&received, 1)
+		})
+		if err != nil {
+			t.Fatalf("Failed to subscribe: %v", err)
+		}
+		sub.SetClosedHandlerEx(func(_ string, r nats.ClosedReason) { reason <- r })
+		if err := sub.AutoUnsubscribeAfter(0); err != nats.ErrInvalidArg {
+			t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+		}
+		if err := sub.AutoUnsubscribeAfter(100 * time.Millisecond); err != nil {
+			t.Fatalf("Error on auto unsubscribe: %v", err)
+		}
+		for i := 0; i < 10; i++ {
+			nc.Publish("foo", []byte("hello"))
+		}
+		nc.Flush()
+
+		select {
+		case r := <-reason:
+			if r != nats.ClosedReasonTimeout {
+				t.Fatalf("Expected reason %v, got %v", nats.ClosedReasonTimeout, r)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Subscription was not closed")
+		}
+		if sub.IsValid() {
+			t.Fatal("Expected subscription to be invalid")
+		}
+		if r := atomic.LoadInt32(&received); r != 10 {
+			t.Fatalf("Expected 10 messages, got %d", r)
+		}
+		if err := sub.AutoUnsubscribeAfter(time.Second); err != nats.ErrBadSubscription {
+			t.Fatalf("Expected %v, got %v", nats.ErrBadSubscription, err)
+		}
+		if n := nc.NumSubscriptions(); n != 0 {
+			t.Fatalf("Expected no subscriptions, got %d", n)
+		}
+	})
+
+	t.Run("unsubscribe before deadline", func(t *testing.T) {
+		reason := make(chan nats.ClosedReason, 2)
+		sub, err := nc.SubscribeSync("foo")
+		if err != nil {
+			t.Fatalf("Failed to subscribe: %v", err)
+		}
+		sub.SetClosedHandlerEx(func(_ string, r nats.ClosedReason) { reason <- r })
+		// The second call replaces the first deadline.
+		if err := sub.AutoUnsubscribeAfter(50 * time.Millisecond); err != nil {
+			t.Fatalf("Error on auto unsubscribe: %v", err)
+		}
+		if err := sub.AutoUnsubscribeAfter(100 * time.Millisecond); err != nil {
+			t.Fatalf("Error on auto unsubscribe: %v", err)
+		}
+		if err := sub.Unsubscribe(); err != nil {
+			t.Fatalf("Error on unsubscribe: %v", err)
+		}
+		if r := <-reason; r != nats.ClosedReasonUnsubscribe {
+			t.Fatalf("Expected reason %v, got %v", nats.ClosedReasonUnsubscribe, r)
+		}
+		select {
+		case r := <-reason:
+			t.Fatalf("Unexpected closed handler call with reason %v", r)
+		case <-time.After(200 * time.Millisecond):
+		}
+	})
+
+	checkNoGoroutineLeak(t, base, "auto unsubscribe after deadline")
+}
+
+func TestSubscribeEndMarker(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	isEnd := func(m *nats.Msg) bool { return m.Header.Get("Done") != "" }
+	for _, test := range []struct {
+		name     string
+		opt      nats.SubOpt
+		expected []string
+	}{
+		{"delivered", nats.EndMarker(isEnd), []string{"0", "1", "2", "end"}},
+		{"not delivered", nats.EndMarkerEx(isEnd, false), []string{"0", "1", "2"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var received []string
+			sub, err := nc.SubscribeWithOpts("foo", func(m *nats.Msg) {
+				mu.Lock()
+				received = append(received, string(m.Data))
+				mu.Unlock()
+			}, test.opt)
+			if err != nil {
+				t.Fatalf("Error on subscribe: %v", err)
+			}
+			reasons := make(chan nats.ClosedReason, 1)
+			sub.SetClosedHandlerEx(func(_ string, reason nats.ClosedReason) {
+				reasons <- reason
+			})
+
+			for i := 0; i < 3; i++ {
+				nc.Publish("foo", []byte(strconv.Itoa(i)))
+			}
+			end := nats.NewMsg("foo")
+			end.Header.Set("Done", "true")
+			end.Data = []byte("end")
+			nc.PublishMsg(end)
+			// Discarded.
+			nc.Publish("foo", []byte("after"))
+			nc.Flush()
+
+			WaitOnChannel(t, reasons, nats.ClosedReasonEndMarker)
+			mu.Lock()
+			defer mu.Unlock()
+			if !reflect.DeepEqual(received, test.expected) {
+				t.Fatalf("Expected %v, got %v", test.expected, received)
+			}
+			if sub.IsValid() {
+				t.Fatal("Expected subscription to be closed")
+			}
+		})
+	}
+
+	if _, err := nc.SubscribeWithOpts("foo", func(_ *nats.Msg) {}, nats.EndMarker(nil)); err != nats.ErrInvalidArg {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+	}
+
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := js.Subscribe("foo", func(_ *nats.Msg) {}, nats.EndMarkerEx(func(_ *nats.Msg) bool { return true }, false)); !errors.Is(err, nats.ErrInvalidArg) {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+	}
+}
+
+func TestSubscribeMaxMsgAge(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	if _, err := nc.SubscribeWithOpts("foo", func(_ *nats.Msg) {}, nats.MaxMsgAge(0)); !errors.Is(err, nats.ErrInvalidArg) {
+		t.Fatalf("Expected error %v, got %v", nats.ErrInvalidArg, err)
+	}
+
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := js.Subscribe("foo", func(_ *nats.Msg) {}, nats.MaxMsgAge(time.Second)); !errors.Is(err, nats.ErrInvalidArg) {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+	}
+
+	block := make(chan struct{})
+	received := make(chan string, 20)
+	sub, err := nc.SubscribeWithOpts("foo", func(m *nats.Msg) {
+		if string(m.Data) == "first" {
+			<-block
+		}
+		received <- string(m.Data)
+	}, nats.MaxMsgAge(100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+
+	nc.Publish("foo", []byte("first"))
+	for i := 0; i < 10; i++ {
+		nc.Publish("foo", []byte("stale"))
+	}
+	nc.Flush()
+	// Messages wait in the pending buffer until they are too old.
+	time.Sleep(250 * time.Millisecond)
+	close(block)
+
+	if m := <-received; m != "first" {
+		t.Fatalf("Expected first message, got %q", m)
+	}
+	nc.Publish("foo", []byte("fresh"))
+	nc.Flush()
+	select {
+	case m := <-received:
+		if m != "fresh" {
+			t.Fatalf("Expected fresh message, got %q", m)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Did not receive fresh message")
+	}
+
+	stale, err := sub.StaleDropped()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if stale != 10 {
+		t.Fatalf("Expected 10 stale messages, got %d", stale)
+	}
+	if delivered, _ := sub.Delivered(); delivered != 2 {
+		t.Fatalf("Expected 2 delivered messages, got %d", delivered)
+	}
+}
+
 func TestAutoUnsubWithParallelNextMsgCalls(t *testing.T) {
 	s := RunDefaultServer()
 	defer s.Shutdown()
@@ -610,40 +1086,254 @@ func TestAsyncErrHandler(t *testing.T) {
 	}
 }
 
-func TestAsyncErrHandlerChanSubscription(t *testing.T) {
+func TestQuietErrors(t *testing.T) {
 	s := RunDefaultServer()
 	defer s.Shutdown()
 
-	opts := nats.GetDefaultOptions()
+	// Capture what the default handler would print.
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Error creating pipe: %v", err)
+	}
+	stderr := os.Stderr
+	os.Stderr = w
+	defer func() { os.Stderr = stderr }()
 
-	nc, err := opts.Connect()
+	nc, err := nats.Connect(nats.DefaultURL, nats.QuietErrors())
 	if err != nil {
-		t.Fatalf("Could not connect to server: %v", err)
+		t.Fatalf("Error on connect: %v", err)
 	}
 	defer nc.Close()
 
-	subj := "chan_test"
+	if nc.ErrorHandler() != nil {
+		t.Fatal("Expected no default error handler")
+	}
 
-	limit := 10
-	toSend := 100
+	block := make(chan struct{})
+	defer close(block)
+	slowSub := func(subj string) *nats.Subscription {
+		t.Helper()
+		sub, err := nc.Subscribe(subj, func(_ *nats.Msg) { <-block })
+		if err != nil {
+			t.Fatalf("Error on subscribe: %v", err)
+		}
+		sub.SetPendingLimits(1, -1)
+		for i := 0; i < 10; i++ {
+			nc.Publish(subj, []byte("hello"))
+		}
+		if err := nc.Flush(); err != nil {
+			t.Fatalf("Error on flush: %v", err)
+		}
+		return sub
+	}
 
-	// Create our own channel.
-	mch := make(chan *nats.Msg, limit)
-	sub, err := nc.ChanSubscribe(subj, mch)
-	if err != nil {
-		t.Fatalf("Could not subscribe: %v", err)
+	sub := slowSub("quiet")
+	if d, _ := sub.Dropped(); d == 0 {
+		t.Fatal("Expected messages to be dropped")
 	}
-	ch := make(chan bool)
-	aeCalled := int64(0)
 
-	nc.SetErrorHandler(func(c *nats.Conn, s *nats.Subscription, e error) {
-		atomic.AddInt64(&aeCalled, 1)
-		if !errors.Is(e, nats.ErrSlowConsumer) {
-			t.Fatalf("Did not receive proper error: %v vs %v",
-				e, nats.ErrSlowConsumer)
+	// A handler set afterwards is invoked.
+	errCh := make(chan *nats.Subscription, 1)
+	nc.SetErrorHandler(func(_ *nats.Conn, s *nats.Subscription, err error) {
+		if errors.Is(err, nats.ErrSlowConsumer) {
+			select {
+			case errCh <- s:
+			default:
+			}
 		}
-		// Suppress additional calls
-		if atomic.LoadInt64(&aeCalled) == 1 {
+	})
+	sub = slowSub("handled")
+	select {
+	case esub := <-errCh:
+		if esub != sub {
+			t.Fatal("Error handler invoked for the wrong subscription")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Error handler was not invoked")
+	}
+
+	// Async callbacks are invoked in order, so the first error would
+	// have been printed by now.
+	os.Stderr = stderr
+	w.Close()
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Error reading stderr: %v", err)
+	}
+	if len(out) != 0 {
+		t.Fatalf("Expected nothing printed, got %q", out)
+	}
+}
+
+func TestSlowConsumerErrorDetails(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	errs := make(chan error, 10)
+	nc, err := nats.Connect(nats.DefaultURL, nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) {
+		errs <- err
+	}))
+	if err != nil {
+		t.Fatalf("Could not connect to server: %v", err)
+	}
+	defer nc.Close()
+
+	for _, test := range []struct {
+		name       string
+		msgsLimit  int
+		bytesLimit int
+	}{
+		{"msgs limit", 2, -1},
+		{"bytes limit", -1, 20},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			bch := make(chan struct{})
+			sub, err := nc.Subscribe("foo", func(_ *nats.Msg) { <-bch })
+			if err != nil {
+				t.Fatalf("Could not subscribe: %v", err)
+			}
+			defer sub.Unsubscribe()
+			defer close(bch)
+			sub.SetPendingLimits(test.msgsLimit, test.bytesLimit)
+
+			// First one blocks the callback, then 2 messages of 10 bytes
+			// fill up the pending limits.
+			for i := 0; i < 5; i++ {
+				nc.Publish("foo", []byte("0123456789"))
+			}
+			nc.Flush()
+
+			select {
+			case err := <-errs:
+				if !errors.Is(err, nats.ErrSlowConsumer) {
+					t.Fatalf("Expected %v, got %v", nats.ErrSlowConsumer, err)
+				}
+				var scErr *nats.SlowConsumerError
+				if !errors.As(err, &scErr) {
+					t.Fatalf("Expected a SlowConsumerError, got %T", err)
+				}
+				if scErr.Sub != sub {
+					t.Fatal("Did not receive proper subscription")
+				}
+				if scErr.Dropped != 1 {
+					t.Fatalf("Expected 1 dropped message, got %d", scErr.Dropped)
+				}
+				if scErr.MsgsLimit != (test.msgsLimit > 0) || scErr.BytesLimit != (test.bytesLimit > 0) {
+					t.Fatalf("Unexpected limits hit: %+v", scErr)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("Failed to call async err handler")
+			}
+		})
+	}
+}
+
+func TestMaxPendingBytesTotal(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	errCh := make(chan error, 10)
+	nc, err := nats.Connect(nats.DefaultURL,
+		nats.MaxPendingBytesTotal(1000),
+		nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) {
+			errCh <- err
+		}))
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer nc.Close()
+
+	var subs []*nats.Subscription
+	for _, subj := range []string{"foo", "bar", "baz"} {
+		sub, err := nc.SubscribeSync(subj)
+		if err != nil {
+			t.Fatalf("Error on subscribe: %v", err)
+		}
+		subs = append(subs, sub)
+	}
+	payload := make([]byte, 100)
+	for i := 0; i < 4; i++ {
+		for _, subj := range []string{"foo", "bar", "baz"} {
+			nc.Publish(subj, payload)
+		}
+	}
+	nc.Flush()
+
+	// Each subscription is within its own limits, but 2 of the 12 messages
+	// can't fit in the total.
+	if n := nc.PendingBytesTotal(); n != 1000 {
+		t.Fatalf("Expected 1000 bytes pending, got %v", n)
+	}
+	var dropped int
+	for _, sub := range subs {
+		d, _ := sub.Dropped()
+		dropped += d
+	}
+	if dropped != 2 {
+		t.Fatalf("Expected 2 dropped messages, got %v", dropped)
+	}
+	select {
+	case err := <-errCh:
+		var scErr *nats.SlowConsumerError
+		if !errors.As(err, &scErr) || !scErr.TotalBytesLimit || scErr.BytesLimit || scErr.MsgsLimit {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected a slow consumer error")
+	}
+
+	if _, err := subs[0].NextMsg(time.Second); err != nil {
+		t.Fatalf("Error on next msg: %v", err)
+	}
+	if n := nc.PendingBytesTotal(); n != 900 {
+		t.Fatalf("Expected 900 bytes pending, got %v", n)
+	}
+	for _, sub := range subs {
+		sub.Unsubscribe()
+	}
+	if n := nc.PendingBytesTotal(); n != 0 {
+		t.Fatalf("Expected no bytes pending, got %v", n)
+	}
+
+	if _, err := nats.Connect(nats.DefaultURL, nats.MaxPendingBytesTotal(0)); !errors.Is(err, nats.ErrInvalidArg) {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+	}
+}
+
+func TestAsyncErrHandlerChanSubscription(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	opts := nats.GetDefaultOptions()
+
+	nc, err := opts.Connect()
+	if err != nil {
+		t.Fatalf("Could not connect to server: %v", err)
+	}
+	defer nc.Close()
+
+	subj := "chan_test"
+
+	limit := 10
+	toSend := 100
+
+	// Create our own channel.
+	mch := make(chan *nats.Msg, limit)
+	sub, err := nc.ChanSubscribe(subj, mch)
+	if err != nil {
+		t.Fatalf("Could not subscribe: %v", err)
+	}
+	ch := make(chan bool)
+	aeCalled := int64(0)
+
+	nc.SetErrorHandler(func(c *nats.Conn, s *nats.Subscription, e error) {
+		atomic.AddInt64(&aeCalled, 1)
+		if !errors.Is(e, nats.ErrSlowConsumer) {
+			t.Fatalf("Did not receive proper error: %v vs %v",
+				e, nats.ErrSlowConsumer)
+		}
+		// Suppress additional calls
+		if atomic.LoadInt64(&aeCalled) == 1 {
 			// release the test
 			ch <- true
 		}
@@ -672,6 +1362,149 @@ func TestAsyncErrHandlerChanSubscription(t *testing.T) {
 	}
 }
 
+func TestChanSubscribeWithErrors(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	if _, err := nc.ChanSubscribeWithErrors("foo", make(chan *nats.Msg), nil); err != nats.ErrInvalidArg {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+	}
+
+	limit := 10
+	mch := make(chan *nats.Msg, limit)
+	errCh := make(chan error, 1)
+	sub, err := nc.ChanSubscribeWithErrors("foo", mch, errCh)
+	if err != nil {
+		t.Fatalf("Could not subscribe: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	overflow := func() {
+		t.Helper()
+		for i := 0; i < 2*limit; i++ {
+			nc.Publish("foo", []byte("hello"))
+		}
+		nc.Flush()
+		waitFor(t, time.Second, 15*time.Millisecond, func() error {
+			if len(mch) != limit {
+				return fmt.Errorf("Expected channel to be full")
+			}
+			return nil
+		})
+	}
+
+	overflow()
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, nats.ErrSlowConsumer) {
+			t.Fatalf("Expected %v, got %v", nats.ErrSlowConsumer, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Did not get the slow consumer error")
+	}
+
+	// Consume the messages so that the subscription recovers, and fill
+	// errCh so that the next error is dropped.
+	for i := 0; i < limit; i++ {
+		<-mch
+	}
+	errCh <- errors.New("not consumed")
+	overflow()
+	waitFor(t, time.Second, 15*time.Millisecond, func() error {
+		if n, _ := sub.ErrorsDropped(); n != 1 {
+			return fmt.Errorf("Expected 1 dropped error, got %d", n)
+		}
+		return nil
+	})
+	if d, _ := sub.Dropped(); d != 2*limit {
+		t.Fatalf("Expected %d dropped messages, got %d", 2*limit, d)
+	}
+}
+
+func TestSubscriptionErrHandler(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	connErrs := int32(0)
+	opts := nats.GetDefaultOptions()
+	opts.AsyncErrorCB = func(_ *nats.Conn, _ *nats.Subscription, _ error) {
+		atomic.AddInt32(&connErrs, 1)
+	}
+	nc, err := opts.Connect()
+	if err != nil {
+		t.Fatalf("Could not connect to server: %v\n", err)
+	}
+	defer nc.Close()
+
+	subErrs := make(chan error, 10)
+	subA, err := nc.SubscribeSync("foo")
+	if err != nil {
+		t.Fatalf("Could not subscribe: %v\n", err)
+	}
+	subA.SetPendingLimits(1, -1)
+	if err := subA.SetErrorHandler(func(_ *nats.Conn, s *nats.Subscription, err error) {
+		if s != subA {
+			t.Errorf("Unexpected subscription in handler")
+		}
+		subErrs <- err
+	}); err != nil {
+		t.Fatalf("Error setting handler: %v", err)
+	}
+	// This one has no override and uses the connection's handler.
+	subB, err := nc.SubscribeSync("bar")
+	if err != nil {
+		t.Fatalf("Could not subscribe: %v\n", err)
+	}
+	subB.SetPendingLimits(1, -1)
+
+	for i := 0; i < 5; i++ {
+		nc.Publish("foo", nil)
+		nc.Publish("bar", nil)
+	}
+	nc.Flush()
+
+	select {
+	case err := <-subErrs:
+		if !errors.Is(err, nats.ErrSlowConsumer) {
+			t.Fatalf("Expected %v, got %v", nats.ErrSlowConsumer, err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Subscription error handler was not invoked")
+	}
+	waitFor(t, time.Second, 15*time.Millisecond, func() error {
+		if n := atomic.LoadInt32(&connErrs); n != 1 {
+			return fmt.Errorf("Expected connection handler to be called once, got %d", n)
+		}
+		return nil
+	})
+
+	// Reverting to nil uses the connection's handler again.
+	subA.NextMsg(time.Second)
+	subA.NextMsg(time.Second)
+	subA.SetErrorHandler(nil)
+	for i := 0; i < 5; i++ {
+		nc.Publish("foo", nil)
+	}
+	nc.Flush()
+	waitFor(t, time.Second, 15*time.Millisecond, func() error {
+		if n := atomic.LoadInt32(&connErrs); n != 2 {
+			return fmt.Errorf("Expected connection handler to be called twice, got %d", n)
+		}
+		return nil
+	})
+	if len(subErrs) != 0 {
+		t.Fatalf("Subscription handler should not have been invoked")
+	}
+
+	subA.Unsubscribe()
+	if err := subA.SetErrorHandler(nil); err != nats.ErrBadSubscription {
+		t.Fatalf("Expected %v, got %v", nats.ErrBadSubscription, err)
+	}
+}
+
 // Test to make sure that we can send and async receive messages on
 // different subjects within a callback.
 func TestAsyncSubscriberStarvation(t *testing.T) {
@@ -706,914 +1539,3707 @@ func TestAsyncSubscriberStarvation(t *testing.T) {
 	}
 }
 
-func TestAsyncSubscribersOnClose(t *testing.T) {
+func TestDispatcher(t *testing.T) {
 	s := RunDefaultServer()
 	defer s.Shutdown()
 
 	nc := NewDefaultConnection(t)
 	defer nc.Close()
 
-	toSend := 10
-	callbacks := int32(0)
-	ch := make(chan bool, toSend)
+	base := getStableNumGoroutine(t)
 
-	nc.Subscribe("foo", func(_ *nats.Msg) {
-		atomic.AddInt32(&callbacks, 1)
-		<-ch
-	})
+	d := nc.NewDispatcher()
+	toSend := 100
+	var inflight int32
+	var mu sync.Mutex
+	var received []string
+	done := make(chan struct{})
+	cb := func(m *nats.Msg) {
+		if n := atomic.AddInt32(&inflight, 1); n != 1 {
+			t.Errorf("Callbacks running concurrently: %v", n)
+		}
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		received = append(received, m.Subject+":"+string(m.Data))
+		if len(received) == toSend {
+			close(done)
+		}
+		mu.Unlock()
+		atomic.AddInt32(&inflight, -1)
+	}
+	foo, err := d.Subscribe("foo", cb)
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	if _, err := d.QueueSubscribe("bar", "q", cb); err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	if foo.Type() != nats.AsyncSubscription {
+		t.Fatalf("Expected an async subscription, got %v", foo.Type())
+	}
 
+	var expected []string
 	for i := 0; i < toSend; i++ {
-		nc.Publish("foo", []byte("Hello World!"))
+		subj := "foo"
+		if i%3 == 0 {
+			subj = "bar"
+		}
+		expected = append(expected, subj+":"+strconv.Itoa(i))
+		nc.Publish(subj, []byte(strconv.Itoa(i)))
 	}
 	nc.Flush()
-	time.Sleep(10 * time.Millisecond)
-	nc.Close()
 
-	// Release callbacks
-	for i := 1; i < toSend; i++ {
-		ch <- true
+	barrier := make(chan struct{})
+	if err := nc.Barrier(func() { close(barrier) }); err != nil {
+		t.Fatalf("Error on barrier: %v", err)
+	}
+	select {
+	case <-barrier:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Barrier was not invoked")
+	}
+	WaitOnChannel(t, done, struct{}{})
+	mu.Lock()
+	if !reflect.DeepEqual(received, expected) {
+		t.Fatalf("Messages not delivered in order: %v", received)
 	}
+	mu.Unlock()
 
-	// Wait for some time.
-	time.Sleep(10 * time.Millisecond)
-	seen := atomic.LoadInt32(&callbacks)
-	if seen != 1 {
-		t.Fatalf("Expected only one callback, received %d callbacks", seen)
+	if err := d.Close(); err != nil {
+		t.Fatalf("Error closing dispatcher: %v", err)
+	}
+	if foo.IsValid() {
+		t.Fatal("Expected subscription to be closed")
 	}
+	if _, err := d.Subscribe("baz", cb); err != nats.ErrDispatcherClosed {
+		t.Fatalf("Expected %v, got %v", nats.ErrDispatcherClosed, err)
+	}
+	checkNoGoroutineLeak(t, base, "closing the dispatcher")
 }
 
-func TestNextMsgCallOnAsyncSub(t *testing.T) {
+func TestDispatcherDrain(t *testing.T) {
 	s := RunDefaultServer()
 	defer s.Shutdown()
 
 	nc := NewDefaultConnection(t)
 	defer nc.Close()
-	sub, err := nc.Subscribe("foo", func(_ *nats.Msg) {
-	})
+
+	base := getStableNumGoroutine(t)
+
+	d := nc.NewDispatcher()
+	var count int32
+	cb := func(_ *nats.Msg) {
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&count, 1)
+	}
+	foo, err := d.Subscribe("foo", cb)
 	if err != nil {
-		t.Fatal("Failed to subscribe: ", err)
+		t.Fatalf("Error on subscribe: %v", err)
 	}
-	_, err = sub.NextMsg(time.Second)
-	if err == nil {
-		t.Fatal("Expected an error call NextMsg() on AsyncSubscriber")
+	bar, err := d.Subscribe("bar", cb)
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		nc.Publish("foo", nil)
+		nc.Publish("bar", nil)
+	}
+	nc.Flush()
+
+	if err := d.Drain(); err != nil {
+		t.Fatalf("Error draining dispatcher: %v", err)
 	}
+	if _, err := d.Subscribe("baz", cb); err != nats.ErrDispatcherClosed {
+		t.Fatalf("Expected %v, got %v", nats.ErrDispatcherClosed, err)
+	}
+	waitFor(t, 2*time.Second, 10*time.Millisecond, func() error {
+		if foo.IsValid() || bar.IsValid() {
+			return errors.New("subscriptions still valid")
+		}
+		return nil
+	})
+	if n := atomic.LoadInt32(&count); n != 20 {
+		t.Fatalf("Expected 20 messages delivered, got %v", n)
+	}
+	checkNoGoroutineLeak(t, base, "draining the dispatcher")
 }
 
-func TestNextMsgCallOnClosedSub(t *testing.T) {
+func TestDispatcherSlowConsumer(t *testing.T) {
 	s := RunDefaultServer()
 	defer s.Shutdown()
 
-	nc := NewDefaultConnection(t)
-	defer nc.Close()
-	sub, err := nc.SubscribeSync("foo")
+	errCh := make(chan error, 10)
+	nc, err := nats.Connect(nats.DefaultURL, nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) {
+		errCh <- err
+	}))
 	if err != nil {
-		t.Fatal("Failed to subscribe: ", err)
+		t.Fatalf("Error connecting: %v", err)
 	}
+	defer nc.Close()
 
-	if err = sub.Unsubscribe(); err != nil {
-		t.Fatal("Unsubscribe failed with err:", err)
+	d := nc.NewDispatcher()
+	defer d.Close()
+	block := make(chan struct{})
+	if _, err := d.Subscribe("foo", func(_ *nats.Msg) { <-block }); err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
 	}
-
-	_, err = sub.NextMsg(time.Second)
-	if err == nil {
-		t.Fatal("Expected an error calling NextMsg() on closed subscription")
-	} else if err != nats.ErrBadSubscription {
-		t.Fatalf("Expected '%v', but got: '%v'", nats.ErrBadSubscription, err.Error())
+	bar, err := d.Subscribe("bar", func(_ *nats.Msg) {})
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
 	}
+	bar.SetPendingLimits(2, -1)
 
-	sub, err = nc.SubscribeSync("foo")
-	if err != nil {
-		t.Fatal("Failed to subscribe: ", err)
+	// The first callback blocks the dispatcher, messages for bar pile up.
+	nc.Publish("foo", nil)
+	for i := 0; i < 5; i++ {
+		nc.Publish("bar", nil)
 	}
-	wg := sync.WaitGroup{}
-	wg.Add(1)
-	go func() {
-		time.Sleep(100 * time.Millisecond)
-		sub.Unsubscribe()
-		wg.Done()
-	}()
-
-	if _, err := sub.NextMsg(time.Second); err == nil || err != nats.ErrBadSubscription {
-		t.Fatalf("Expected '%v', but got: '%v'", nats.ErrBadSubscription, err.Error())
+	nc.Flush()
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, nats.ErrSlowConsumer) {
+			t.Fatalf("Expected %v, got %v", nats.ErrSlowConsumer, err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected a slow consumer error")
+	}
+	if dropped, _ := bar.Dropped(); dropped != 3 {
+		t.Fatalf("Expected 3 dropped messages, got %v", dropped)
 	}
+	close(block)
+	waitFor(t, time.Second, 10*time.Millisecond, func() error {
+		if n, _, _ := bar.Pending(); n != 0 {
+			return fmt.Errorf("still %v pending", n)
+		}
+		return nil
+	})
 }
 
-func TestChanSubscriber(t *testing.T) {
+func TestConnUse(t *testing.T) {
 	s := RunDefaultServer()
 	defer s.Shutdown()
 
 	nc := NewDefaultConnection(t)
 	defer nc.Close()
 
-	// Create our own channel.
-	ch := make(chan *nats.Msg, 128)
-
-	// Channel is mandatory
-	if _, err := nc.ChanSubscribe("foo", nil); err == nil {
-		t.Fatal("Creating subscription without channel should have failed")
+	var mu sync.Mutex
+	var calls []string
+	tag := func(name string) nats.MsgInterceptor {
+		return func(next nats.MsgHandler) nats.MsgHandler {
+			return func(m *nats.Msg) {
+				mu.Lock()
+				calls = append(calls, name)
+				mu.Unlock()
+				m.Data = append(m.Data, name...)
+				next(m)
+			}
+		}
 	}
+	nc.Use(tag("1"))
+	nc.Use(tag("2"))
 
-	_, err := nc.ChanSubscribe("foo", ch)
+	ch := make(chan string, 1)
+	if _, err := nc.Subscribe("foo", func(m *nats.Msg) {
+		ch <- string(m.Data)
+	}); err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	ssub, err := nc.SubscribeSync("foo")
 	if err != nil {
-		t.Fatal("Failed to subscribe: ", err)
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	if err := nc.Publish("foo", []byte("msg-")); err != nil {
+		t.Fatalf("Error on publish: %v", err)
 	}
 
-	// Send some messages to ourselves.
-	total := 100
-	for i := 0; i < total; i++ {
-		nc.Publish("foo", []byte("Hello"))
+	select {
+	case data := <-ch:
+		if data != "msg-12" {
+			t.Fatalf("Expected modified message %q, got %q", "msg-12", data)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Did not receive message")
 	}
+	mu.Lock()
+	if !reflect.DeepEqual(calls, []string{"1", "2"}) {
+		t.Fatalf("Unexpected interceptor calls: %v", calls)
+	}
+	mu.Unlock()
 
-	received := 0
-	tm := time.NewTimer(5 * time.Second)
-	defer tm.Stop()
+	// Synchronous subscriptions are not intercepted.
+	m, err := ssub.NextMsg(time.Second)
+	if err != nil {
+		t.Fatalf("Error on next msg: %v", err)
+	}
+	if string(m.Data) != "msg-" {
+		t.Fatalf("Expected unmodified message, got %q", m.Data)
+	}
+	mu.Lock()
+	if len(calls) != 2 {
+		t.Fatalf("Unexpected interceptor calls: %v", calls)
+	}
+	mu.Unlock()
+}
 
-	// Go ahead and receive
-	for {
-		select {
-		case _, ok := <-ch:
-			if !ok {
-				t.Fatalf("Got an error reading from channel")
+func TestConnUseSkipsInternalSubscriptions(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	// Drop every message of the wrapped subscriptions.
+	nc.Use(func(next nats.MsgHandler) nats.MsgHandler {
+		return func(m *nats.Msg) {}
+	})
+
+	if _, err := nc.Subscribe("foo", func(m *nats.Msg) {
+		m.Respond([]byte("reply"))
+	}); err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	// The responder is wrapped too, so reply from a synchronous one.
+	rsub, err := nc.SubscribeSync("baz")
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	go func() {
+		for {
+			m, err := rsub.NextMsg(time.Second)
+			if err != nil {
+				return
 			}
-		case <-tm.C:
-			t.Fatalf("Timed out waiting on messages")
+			m.Respond([]byte("reply"))
 		}
-		received++
-		if received >= total {
-			return
+	}()
+
+	// The request and pipeline reply subscriptions are not wrapped.
+	if _, err := nc.Request("baz", []byte("req"), time.Second); err != nil {
+		t.Fatalf("Error on request: %v", err)
+	}
+	p, err := nc.NewRequestPipeline(1)
+	if err != nil {
+		t.Fatalf("Error creating pipeline: %v", err)
+	}
+	defer p.Close()
+	ch, err := p.Submit("baz", []byte("req"))
+	if err != nil {
+		t.Fatalf("Error on submit: %v", err)
+	}
+	select {
+	case m := <-ch:
+		if m == nil || string(m.Data) != "reply" {
+			t.Fatalf("Unexpected reply: %+v", m)
 		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Did not receive reply")
+	}
+
+	// While the user one is.
+	if _, err := nc.Request("foo", []byte("req"), 250*time.Millisecond); err != nats.ErrTimeout {
+		t.Fatalf("Expected timeout, got %v", err)
 	}
 }
 
-func TestChanQueueSubscriber(t *testing.T) {
+func TestAsyncSubscribersOnClose(t *testing.T) {
 	s := RunDefaultServer()
 	defer s.Shutdown()
 
 	nc := NewDefaultConnection(t)
 	defer nc.Close()
 
-	// Create our own channel.
-	ch1 := make(chan *nats.Msg, 64)
-	ch2 := make(chan *nats.Msg, 64)
+	toSend := 10
+	callbacks := int32(0)
+	ch := make(chan bool, toSend)
 
-	nc.ChanQueueSubscribe("foo", "bar", ch1)
-	nc.ChanQueueSubscribe("foo", "bar", ch2)
+	nc.Subscribe("foo", func(_ *nats.Msg) {
+		atomic.AddInt32(&callbacks, 1)
+		<-ch
+	})
 
-	// Send some messages to ourselves.
-	total := 100
-	for i := 0; i < total; i++ {
-		nc.Publish("foo", []byte("Hello"))
+	for i := 0; i < toSend; i++ {
+		nc.Publish("foo", []byte("Hello World!"))
 	}
+	nc.Flush()
+	time.Sleep(10 * time.Millisecond)
+	nc.Close()
 
-	received := 0
-	tm := time.NewTimer(5 * time.Second)
-	defer tm.Stop()
-
-	chk := func(ok bool) {
-		if !ok {
-			t.Fatalf("Got an error reading from channel")
-		} else {
-			received++
-		}
+	// Release callbacks
+	for i := 1; i < toSend; i++ {
+		ch <- true
 	}
 
-	// Go ahead and receive
-	for {
-		select {
-		case _, ok := <-ch1:
-			chk(ok)
-		case _, ok := <-ch2:
-			chk(ok)
-		case <-tm.C:
-			t.Fatalf("Timed out waiting on messages")
-		}
-		if received >= total {
-			return
-		}
+	// Wait for some time.
+	time.Sleep(10 * time.Millisecond)
+	seen := atomic.LoadInt32(&callbacks)
+	if seen != 1 {
+		t.Fatalf("Expected only one callback, received %d callbacks", seen)
 	}
 }
 
-func TestChanSubscriberPendingLimits(t *testing.T) {
+func TestSubscribeConcurrency(t *testing.T) {
 	s := RunDefaultServer()
 	defer s.Shutdown()
 
 	nc := NewDefaultConnection(t)
 	defer nc.Close()
-	ncp := NewDefaultConnection(t)
-	defer ncp.Close()
 
-	// There was a defect that prevented to receive more than
-	// the default pending message limit. Trying to send more
-	// than this limit.
-	pending := 1000
-	total := pending + 100
-
-	for typeSubs := 0; typeSubs < 3; typeSubs++ {
+	if _, err := nc.SubscribeWithOpts("foo", func(_ *nats.Msg) {}, nats.SubscribeConcurrency(0)); err != nats.ErrInvalidArg {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+	}
 
-		func() {
-			// Create our own channel.
-			ch := make(chan *nats.Msg, total)
+	t.Run("parallel callbacks", func(t *testing.T) {
+		base := getStableNumGoroutine(t)
 
-			var err error
-			var sub *nats.Subscription
-			switch typeSubs {
-			case 0:
-				sub, err = nc.ChanSubscribe("foo", ch)
-				if err := sub.SetPendingLimits(pending, -1); err == nil {
-					t.Fatalf("Expected an error setting pending limits")
-				}
-			case 1:
-				sub, err = nc.ChanQueueSubscribe("foo", "bar", ch)
-				if err := sub.SetPendingLimits(pending, -1); err == nil {
-					t.Fatalf("Expected an error setting pending limits")
-				}
-			case 2:
-				sub, err = nc.QueueSubscribeSyncWithChan("foo", "bar", ch)
-				if err := sub.SetPendingLimits(pending, -1); err == nil {
-					t.Fatalf("Expected an error setting pending limits")
+		var running, maxRunning, received int32
+		release := make(chan struct{})
+		sub, err := nc.SubscribeWithOpts("foo", func(_ *nats.Msg) {
+			r := atomic.AddInt32(&running, 1)
+			for {
+				m := atomic.LoadInt32(&maxRunning)
+				if r <= m || atomic.CompareAndSwapInt32(&maxRunning, m, r) {
+					break
 				}
 			}
-			if err != nil {
-				t.Fatalf("Unexpected error on subscribe: %v", err)
+			<-release
+			atomic.AddInt32(&running, -1)
+			atomic.AddInt32(&received, 1)
+		}, nats.SubscribeConcurrency(4))
+		if err != nil {
+			t.Fatalf("Error on subscribe: %v", err)
+		}
+		for i := 0; i < 20; i++ {
+			nc.Publish("foo", nil)
+		}
+		nc.Flush()
+		waitFor(t, 2*time.Second, 15*time.Millisecond, func() error {
+			if r := atomic.LoadInt32(&running); r != 4 {
+				return fmt.Errorf("Expected 4 running callbacks, got %d", r)
 			}
-			defer sub.Unsubscribe()
-			nc.Flush()
+			return nil
+		})
+		close(release)
+		waitFor(t, 2*time.Second, 15*time.Millisecond, func() error {
+			if r := atomic.LoadInt32(&received); r != 20 {
+				return fmt.Errorf("Expected 20 messages, got %d", r)
+			}
+			return nil
+		})
+		if m := atomic.LoadInt32(&maxRunning); m != 4 {
+			t.Fatalf("Expected at most 4 running callbacks, got %d", m)
+		}
+		if n, _, _ := sub.Pending(); n != 0 {
+			t.Fatalf("Expected no pending messages, got %d", n)
+		}
+		sub.Unsubscribe()
+		checkNoGoroutineLeak(t, base, "Unsubscribe()")
+	})
 
-			// Send some messages
-			for i := 0; i < total; i++ {
-				if err := ncp.Publish("foo", []byte("Hello")); err != nil {
-					t.Fatalf("Unexpected error on publish: %v", err)
-				}
+	t.Run("ordered per key", func(t *testing.T) {
+		var mu sync.Mutex
+		last := make(map[string]int)
+		received := int32(0)
+		sub, err := nc.SubscribeWithOpts("bar.*", func(m *nats.Msg) {
+			seq, _ := strconv.Atoi(string(m.Data))
+			mu.Lock()
+			if prev, ok := last[m.Subject]; ok && seq != prev+1 {
+				t.Errorf("Out of order message on %q: %d after %d", m.Subject, seq, prev)
+			}
+			last[m.Subject] = seq
+			mu.Unlock()
+			atomic.AddInt32(&received, 1)
+		}, nats.SubscribeConcurrency(3), nats.SubscribeConcurrencyKey(func(m *nats.Msg) string {
+			return m.Subject
+		}))
+		if err != nil {
+			t.Fatalf("Error on subscribe: %v", err)
+		}
+		defer sub.Unsubscribe()
+		for i := 0; i < 100; i++ {
+			for _, k := range []string{"a", "b", "c", "d"} {
+				nc.Publish("bar."+k, []byte(strconv.Itoa(i)))
+			}
+		}
+		nc.Flush()
+		waitFor(t, 2*time.Second, 15*time.Millisecond, func() error {
+			if r := atomic.LoadInt32(&received); r != 400 {
+				return fmt.Errorf("Expected 400 messages, got %d", r)
 			}
+			return nil
+		})
+	})
 
-			received := 0
-			tm := time.NewTimer(10 * time.Second)
-			defer tm.Stop()
+	t.Run("drain and max", func(t *testing.T) {
+		received := int32(0)
+		sub, err := nc.SubscribeWithOpts("baz", func(_ *nats.Msg) {
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt32(&received, 1)
+		}, nats.SubscribeConcurrency(4))
+		if err != nil {
+			t.Fatalf("Error on subscribe: %v", err)
+		}
+		closed := sub.StatusChanged(nats.SubscriptionClosed)
+		for i := 0; i < 50; i++ {
+			nc.Publish("baz", nil)
+		}
+		nc.Flush()
+		if err := sub.Drain(); err != nil {
+			t.Fatalf("Error on drain: %v", err)
+		}
+		WaitOnChannel(t, closed, nats.SubscriptionClosed)
+		if r := atomic.LoadInt32(&received); r != 50 {
+			t.Fatalf("Expected all 50 messages before drain completed, got %d", r)
+		}
 
-			chk := func(ok bool) {
-				if !ok {
-					t.Fatalf("Got an error reading from channel")
-				} else {
-					received++
-				}
-			}
+		atomic.StoreInt32(&received, 0)
+		sub, err = nc.QueueSubscribeWithOpts("baz", "q", func(_ *nats.Msg) {
+			atomic.AddInt32(&received, 1)
+		}, nats.SubscribeConcurrency(4))
+		if err != nil {
+			t.Fatalf("Error on subscribe: %v", err)
+		}
+		sub.AutoUnsubscribe(10)
+		for i := 0; i < 50; i++ {
+			nc.Publish("baz", nil)
+		}
+		nc.Flush()
+		time.Sleep(100 * time.Millisecond)
+		if r := atomic.LoadInt32(&received); r != 10 {
+			t.Fatalf("Expected 10 messages, got %d", r)
+		}
+	})
+}
 
-			// Go ahead and receive
-			for {
-				select {
-				case _, ok := <-ch:
-					chk(ok)
-					if received >= total {
-						return
-					}
-				case <-tm.C:
-					t.Fatalf("Timed out waiting on messages for test %d, received %d", typeSubs, received)
-				}
-			}
-		}()
+func TestSubscribeWithOptsJetStreamOptions(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	for _, opt := range []nats.SubOpt{
+		nats.Durable("dur"),
+		nats.ManualAck(),
+		nats.BindStream("TEST"),
+		nats.OrderedConsumer(),
+		nats.DeliverNew(),
+		nats.Context(context.Background()),
+	} {
+		if _, err := nc.SubscribeWithOpts("foo", func(_ *nats.Msg) {}, opt); !errors.Is(err, nats.ErrInvalidArg) {
+			t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+		}
+		if _, err := nc.QueueSubscribeWithOpts("foo", "q", func(_ *nats.Msg) {}, opt); !errors.Is(err, nats.ErrInvalidArg) {
+			t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+		}
+	}
+	if n := nc.NumSubscriptions(); n != 0 {
+		t.Fatalf("Expected no subscription, got %d", n)
 	}
 }
 
-func TestQueueChanQueueSubscriber(t *testing.T) {
+func TestSubscribeDeduplicateBy(t *testing.T) {
 	s := RunDefaultServer()
 	defer s.Shutdown()
 
 	nc := NewDefaultConnection(t)
 	defer nc.Close()
 
-	// Create our own channel.
+	if _, err := nc.SubscribeWithOpts("foo", func(_ *nats.Msg) {}, nats.DeduplicateBy("", time.Second)); err != nats.ErrInvalidArg {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+	}
+
+	var mu sync.Mutex
+	var received []string
+	sub, err := nc.SubscribeWithOpts("foo", func(m *nats.Msg) {
+		mu.Lock()
+		received = append(received, string(m.Data))
+		mu.Unlock()
+	}, nats.DeduplicateBy("Msg-Id", 250*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	publish := func(id, data string) {
+		t.Helper()
+		m := nats.NewMsg("foo")
+		if id != "" {
+			m.Header.Set("Msg-Id", id)
+		}
+		m.Data = []byte(data)
+		if err := nc.PublishMsg(m); err != nil {
+			t.Fatalf("Error on publish: %v", err)
+		}
+	}
+	check := func(expected []string, dups int) {
+		t.Helper()
+		waitFor(t, time.Second, 15*time.Millisecond, func() error {
+			mu.Lock()
+			defer mu.Unlock()
+			if !reflect.DeepEqual(received, expected) {
+				return fmt.Errorf("Expected %v, got %v", expected, received)
+			}
+			return nil
+		})
+		if n, _ := sub.Deduplicated(); n != dups {
+			t.Fatalf("Expected %d duplicates, got %d", dups, n)
+		}
+	}
+
+	publish("1", "a")
+	publish("1", "b")
+	publish("2", "c")
+	publish("2", "d")
+	// Messages without the header are never dropped.
+	publish("", "e")
+	publish("", "f")
+	nc.Flush()
+	check([]string{"a", "c", "e", "f"}, 2)
+
+	// Once the window has rolled over, the same value is accepted again.
+	time.Sleep(300 * time.Millisecond)
+	publish("1", "g")
+	publish("1", "h")
+	nc.Flush()
+	check([]string{"a", "c", "e", "f", "g"}, 3)
+}
+
+func TestSubscribeNoEcho(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+	nc2 := NewDefaultConnection(t)
+	defer nc2.Close()
+
+	echoCh := make(chan *nats.Msg, 10)
+	echoSub, err := nc.ChanSubscribe("foo", echoCh)
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	defer echoSub.Unsubscribe()
+
+	noEchoCh := make(chan *nats.Msg, 10)
+	noEchoSub, err := nc.SubscribeWithOpts("foo", func(m *nats.Msg) {
+		noEchoCh <- m
+	}, nats.SubNoEcho())
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	defer noEchoSub.Unsubscribe()
+	if err := nc.Flush(); err != nil {
+		t.Fatalf("Error on flush: %v", err)
+	}
+
+	if err := nc.Publish("foo", []byte("own")); err != nil {
+		t.Fatalf("Error on publish: %v", err)
+	}
+	m := nats.NewMsg("foo")
+	m.Header.Set("X", "y")
+	m.Data = []byte("own with headers")
+	if err := nc.PublishMsg(m); err != nil {
+		t.Fatalf("Error on publish: %v", err)
+	}
+	nc.Flush()
+	for _, data := range []string{"other 1", "other 2"} {
+		if err := nc2.Publish("foo", []byte(data)); err != nil {
+			t.Fatalf("Error on publish: %v", err)
+		}
+	}
+	nc2.Flush()
+
+	receive := func(ch chan *nats.Msg, expected ...string) []*nats.Msg {
+		t.Helper()
+		var msgs []*nats.Msg
+		for _, data := range expected {
+			select {
+			case m := <-ch:
+				if string(m.Data) != data {
+					t.Fatalf("Expected %q, got %q", data, m.Data)
+				}
+				msgs = append(msgs, m)
+			case <-time.After(time.Second):
+				t.Fatalf("Did not receive %q", data)
+			}
+		}
+		select {
+		case m := <-ch:
+			t.Fatalf("Unexpected message %q", m.Data)
+		case <-time.After(100 * time.Millisecond):
+		}
+		return msgs
+	}
+	receive(noEchoCh, "other 1", "other 2")
+	msgs := receive(echoCh, "own", "own with headers", "other 1", "other 2")
+	if msgs[1].Header.Get("X") != "y" || msgs[1].Header.Get(nats.EchoIDHdr) == "" {
+		t.Fatalf("Unexpected headers: %v", msgs[1].Header)
+	}
+	if h := msgs[2].Header.Get(nats.EchoIDHdr); h != "" {
+		t.Fatalf("Expected no echo header on other connection's messages, got %q", h)
+	}
+
+	// Messages are not tagged anymore once the last SubNoEcho
+	// subscription is gone.
+	if err := noEchoSub.Unsubscribe(); err != nil {
+		t.Fatalf("Error on unsubscribe: %v", err)
+	}
+	if err := nc.Publish("foo", []byte("untagged")); err != nil {
+		t.Fatalf("Error on publish: %v", err)
+	}
+	nc.Flush()
+	msgs = receive(echoCh, "untagged")
+	if msgs[0].Header != nil {
+		t.Fatalf("Expected no headers, got %v", msgs[0].Header)
+	}
+
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := js.Subscribe("foo", func(_ *nats.Msg) {}, nats.SubNoEcho()); !errors.Is(err, nats.ErrInvalidArg) {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+	}
+}
+
+func TestSubscribeAutoDecompress(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	errCh := make(chan error, 10)
+	nc, err := nats.Connect(nats.DefaultURL, nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) {
+		errCh <- err
+	}))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer nc.Close()
+
+	decCh := make(chan *nats.Msg, 10)
+	if _, err := nc.SubscribeWithOpts("foo", func(m *nats.Msg) { decCh <- m }, nats.AutoDecompress()); err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	rawCh := make(chan *nats.Msg, 10)
+	if _, err := nc.ChanSubscribe("foo", rawCh); err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	nc.Flush()
+
+	next := func(ch chan *nats.Msg) *nats.Msg {
+		t.Helper()
+		select {
+		case m := <-ch:
+			return m
+		case <-time.After(time.Second):
+			t.Fatal("Did not receive message")
+		}
+		return nil
+	}
+
+	if err := nc.PublishMsgWithOpts(nats.NewMsg("foo"), nats.Compress("zip")); !errors.Is(err, nats.ErrInvalidArg) {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+	}
+
+	data := []byte(strings.Repeat(`{"name":"value","count":12345}`, 100))
+	for _, algo := range []nats.CompressAlgo{nats.CompressGzip, nats.CompressS2, nats.CompressSnappy} {
+		t.Run(string(algo), func(t *testing.T) {
+			m := nats.NewMsg("foo")
+			m.Header.Set("X", "y")
+			m.Data = data
+			if err := nc.PublishMsgWithOpts(m, nats.Compress(algo)); err != nil {
+				t.Fatalf("Error on publish: %v", err)
+			}
+			if !bytes.Equal(m.Data, data) || m.Header.Get(nats.CompressionHdr) != "" {
+				t.Fatal("Published message was modified")
+			}
+
+			raw := next(rawCh)
+			if raw.Header.Get(nats.CompressionHdr) != string(algo) {
+				t.Fatalf("Unexpected headers: %v", raw.Header)
+			}
+			if len(raw.Data) >= len(data) {
+				t.Fatalf("Expected payload to be compressed, got %d bytes", len(raw.Data))
+			}
+
+			dec := next(decCh)
+			if !bytes.Equal(dec.Data, data) {
+				t.Fatalf("Unexpected payload: %q", dec.Data)
+			}
+			if dec.Header.Get("X") != "y" || dec.Header.Get(nats.CompressionHdr) != "" {
+				t.Fatalf("Unexpected headers: %v", dec.Header)
+			}
+		})
+	}
+
+	// Unknown algorithms and uncompressed messages pass through.
+	m := nats.NewMsg("foo")
+	m.Header.Set(nats.CompressionHdr, "zstd")
+	m.Data = []byte("unknown")
+	nc.PublishMsg(m)
+	nc.Publish("foo", []byte("plain"))
+	if m := next(decCh); string(m.Data) != "unknown" || m.Header.Get(nats.CompressionHdr) != "zstd" {
+		t.Fatalf("Unexpected message: %+v", m)
+	}
+	if m := next(decCh); string(m.Data) != "plain" {
+		t.Fatalf("Unexpected message: %+v", m)
+	}
+
+	// Bad payloads are reported and not delivered.
+	m = nats.NewMsg("foo")
+	m.Header.Set(nats.CompressionHdr, string(nats.CompressGzip))
+	m.Data = []byte("not gzip")
+	nc.PublishMsg(m)
+	nc.Publish("foo", []byte("after"))
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, nats.ErrDecompression) {
+			t.Fatalf("Expected %v, got %v", nats.ErrDecompression, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Did not get the decompression error")
+	}
+	if m := next(decCh); string(m.Data) != "after" {
+		t.Fatalf("Unexpected message: %+v", m)
+	}
+
+	// Payloads decompressing past the limit are reported and not delivered.
+	limCh := make(chan *nats.Msg, 10)
+	limSub, err := nc.SubscribeWithOpts("bar", func(m *nats.Msg) { limCh <- m }, nats.AutoDecompress(), nats.MaxDecompressedSize(len(data)))
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	defer limSub.Unsubscribe()
+	big := append(data, 'x')
+	for _, algo := range []nats.CompressAlgo{nats.CompressGzip, nats.CompressS2, nats.CompressSnappy} {
+		for _, payload := range [][]byte{data, big} {
+			m := nats.NewMsg("bar")
+			m.Data = payload
+			if err := nc.PublishMsgWithOpts(m, nats.Compress(algo)); err != nil {
+				t.Fatalf("Error on publish: %v", err)
+			}
+		}
+		if m := next(limCh); !bytes.Equal(m.Data, data) {
+			t.Fatalf("Unexpected payload of %d bytes", len(m.Data))
+		}
+		select {
+		case err := <-errCh:
+			if !errors.Is(err, nats.ErrDecompression) {
+				t.Fatalf("Expected %v, got %v", nats.ErrDecompression, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Did not get the decompression error for %s", algo)
+		}
+	}
+	if _, err := nc.SubscribeWithOpts("bar", func(*nats.Msg) {}, nats.MaxDecompressedSize(0)); !errors.Is(err, nats.ErrInvalidArg) {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+	}
+
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := js.Subscribe("foo", func(_ *nats.Msg) {}, nats.AutoDecompress()); !errors.Is(err, nats.ErrInvalidArg) {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+	}
+}
+
+func TestSubscribeCapture(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	if _, err := nc.SubscribeWithOpts("foo", func(_ *nats.Msg) {}, nats.SubscribeCapture(0)); err != nats.ErrInvalidArg {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+	}
+
+	// Disabled by default.
+	sub, err := nc.Subscribe("bar", func(_ *nats.Msg) {})
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	if msgs := sub.Captured(); msgs != nil {
+		t.Fatalf("Expected nothing captured, got %v", msgs)
+	}
+
+	received := int32(0)
+	sub, err = nc.SubscribeWithOpts("foo", func(_ *nats.Msg) {
+		atomic.AddInt32(&received, 1)
+	}, nats.SubscribeCapture(5))
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+
+	check := func(expected ...string) {
+		t.Helper()
+		msgs := sub.Captured()
+		got := make([]string, 0, len(msgs))
+		for _, m := range msgs {
+			got = append(got, string(m.Data))
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Fatalf("Expected %v, got %v", expected, got)
+		}
+	}
+	publish := func(from, to int) {
+		t.Helper()
+		for i := from; i < to; i++ {
+			nc.Publish("foo", []byte(strconv.Itoa(i)))
+		}
+		nc.Flush()
+		waitFor(t, time.Second, 15*time.Millisecond, func() error {
+			if r := atomic.LoadInt32(&received); r != int32(to) {
+				return fmt.Errorf("Expected %d messages, got %d", to, r)
+			}
+			return nil
+		})
+	}
+
+	publish(0, 3)
+	check("0", "1", "2")
+	publish(3, 12)
+	check("7", "8", "9", "10", "11")
+
+	// Still available once the subscription is closed.
+	sub.Unsubscribe()
+	check("7", "8", "9", "10", "11")
+}
+
+func TestCallbackPanicHandler(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	type recovered struct {
+		sub *nats.Subscription
+		val any
+	}
+	panics := make(chan recovered, 10)
+	nc, err := nats.Connect(nats.DefaultURL, nats.CallbackPanicHandler(func(sub *nats.Subscription, r any) {
+		panics <- recovered{sub, r}
+	}))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer nc.Close()
+
+	for _, concurrency := range []int{1, 4} {
+		t.Run(fmt.Sprintf("concurrency %d", concurrency), func(t *testing.T) {
+			received := int32(0)
+			sub, err := nc.SubscribeWithOpts("foo", func(m *nats.Msg) {
+				if string(m.Data) == "panic" {
+					panic("boom")
+				}
+				atomic.AddInt32(&received, 1)
+			}, nats.SubscribeConcurrency(concurrency))
+			if err != nil {
+				t.Fatalf("Error on subscribe: %v", err)
+			}
+			defer sub.Unsubscribe()
+
+			nc.Publish("foo", []byte("ok"))
+			nc.Publish("foo", []byte("panic"))
+			nc.Publish("foo", []byte("ok"))
+			nc.Flush()
+
+			select {
+			case r := <-panics:
+				if r.sub != sub {
+					t.Fatalf("Expected the subscription that panicked")
+				}
+				if r.val != "boom" {
+					t.Fatalf("Expected recovered value 'boom', got %v", r.val)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("Panic handler was not invoked")
+			}
+			waitFor(t, time.Second, 15*time.Millisecond, func() error {
+				if r := atomic.LoadInt32(&received); r != 2 {
+					return fmt.Errorf("Expected 2 messages, got %d", r)
+				}
+				if n, _, _ := sub.Pending(); n != 0 {
+					return fmt.Errorf("Expected no pending messages, got %d", n)
+				}
+				return nil
+			})
+		})
+	}
+}
+
+func TestNextMsgCallOnAsyncSub(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+	sub, err := nc.Subscribe("foo", func(_ *nats.Msg) {
+	})
+	if err != nil {
+		t.Fatal("Failed to subscribe: ", err)
+	}
+	_, err = sub.NextMsg(time.Second)
+	if err == nil {
+		t.Fatal("Expected an error call NextMsg() on AsyncSubscriber")
+	}
+}
+
+func TestNextBatch(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	sub, err := nc.SubscribeSync("foo")
+	if err != nil {
+		t.Fatal("Failed to subscribe: ", err)
+	}
+	if _, err := sub.NextBatch(0, time.Second); err != nats.ErrInvalidArg {
+		t.Fatalf("Expected '%v', but got: '%v'", nats.ErrInvalidArg, err)
+	}
+	if _, err := sub.NextBatch(10, 50*time.Millisecond); err != nats.ErrTimeout {
+		t.Fatalf("Expected '%v', but got: '%v'", nats.ErrTimeout, err)
+	}
+
+	for i := 0; i < 25; i++ {
+		nc.Publish("foo", []byte(fmt.Sprintf("%d", i)))
+	}
+	nc.Flush()
+
+	var got []*nats.Msg
+	for len(got) < 25 {
+		msgs, err := sub.NextBatch(10, time.Second)
+		if err != nil {
+			t.Fatalf("Error on NextBatch: %v", err)
+		}
+		if len(msgs) > 10 {
+			t.Fatalf("Expected at most 10 messages, got %d", len(msgs))
+		}
+		got = append(got, msgs...)
+	}
+	for i, m := range got {
+		if string(m.Data) != fmt.Sprintf("%d", i) {
+			t.Fatalf("Unexpected message at %d: %q", i, m.Data)
+		}
+	}
+	if n, _, _ := sub.Pending(); n != 0 {
+		t.Fatalf("Expected no pending messages, got %d", n)
+	}
+	if d, _ := sub.Delivered(); d != 25 {
+		t.Fatalf("Expected 25 delivered, got %d", d)
+	}
+
+	// Waits for the first message to arrive.
+	time.AfterFunc(100*time.Millisecond, func() { nc.Publish("foo", []byte("late")) })
+	msgs, err := sub.NextBatch(10, time.Second)
+	if err != nil || len(msgs) != 1 || string(msgs[0].Data) != "late" {
+		t.Fatalf("Unexpected result: %v - %v", msgs, err)
+	}
+
+	// Batch does not go past the AutoUnsubscribe limit.
+	sub, err = nc.SubscribeSync("bar")
+	if err != nil {
+		t.Fatal("Failed to subscribe: ", err)
+	}
+	if err := sub.AutoUnsubscribe(5); err != nil {
+		t.Fatalf("Error on AutoUnsubscribe: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		nc.Publish("bar", nil)
+	}
+	nc.Flush()
+	if msgs, err := sub.NextBatch(10, time.Second); err != nil || len(msgs) != 3 {
+		t.Fatalf("Expected 3 messages, got %d - %v", len(msgs), err)
+	}
+	for i := 0; i < 5; i++ {
+		nc.Publish("bar", nil)
+	}
+	nc.Flush()
+	if msgs, err := sub.NextBatch(10, time.Second); err != nil || len(msgs) != 2 {
+		t.Fatalf("Expected 2 messages, got %d - %v", len(msgs), err)
+	}
+	if _, err := sub.NextBatch(10, time.Second); err != nats.ErrMaxMessages {
+		t.Fatalf("Expected '%v', but got: '%v'", nats.ErrMaxMessages, err)
+	}
+
+	// Slow consumer is reported like NextMsg does.
+	sub, err = nc.SubscribeSync("baz")
+	if err != nil {
+		t.Fatal("Failed to subscribe: ", err)
+	}
+	sub.SetPendingLimits(2, -1)
+	for i := 0; i < 5; i++ {
+		nc.Publish("baz", nil)
+	}
+	nc.Flush()
+	if _, err := sub.NextBatch(10, time.Second); err != nats.ErrSlowConsumer {
+		t.Fatalf("Expected '%v', but got: '%v'", nats.ErrSlowConsumer, err)
+	}
+	if msgs, err := sub.NextBatch(10, time.Second); err != nil || len(msgs) != 2 {
+		t.Fatalf("Expected 2 messages, got %d - %v", len(msgs), err)
+	}
+
+	sub.Unsubscribe()
+	if _, err := sub.NextBatch(10, time.Second); err != nats.ErrBadSubscription {
+		t.Fatalf("Expected '%v', but got: '%v'", nats.ErrBadSubscription, err)
+	}
+}
+
+func TestNextMsgCallOnClosedSub(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+	sub, err := nc.SubscribeSync("foo")
+	if err != nil {
+		t.Fatal("Failed to subscribe: ", err)
+	}
+
+	if err = sub.Unsubscribe(); err != nil {
+		t.Fatal("Unsubscribe failed with err:", err)
+	}
+
+	_, err = sub.NextMsg(time.Second)
+	if err == nil {
+		t.Fatal("Expected an error calling NextMsg() on closed subscription")
+	} else if err != nats.ErrBadSubscription {
+		t.Fatalf("Expected '%v', but got: '%v'", nats.ErrBadSubscription, err.Error())
+	}
+
+	sub, err = nc.SubscribeSync("foo")
+	if err != nil {
+		t.Fatal("Failed to subscribe: ", err)
+	}
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		sub.Unsubscribe()
+		wg.Done()
+	}()
+
+	if _, err := sub.NextMsg(time.Second); err == nil || err != nats.ErrBadSubscription {
+		t.Fatalf("Expected '%v', but got: '%v'", nats.ErrBadSubscription, err.Error())
+	}
+}
+
+func TestNextMsgErrors(t *testing.T) {
+	conf := createConfFile(t, []byte(`
+	listen: 127.0.0.1:-1
+	authorization: {
+		users = [
+			{
+				user: test
+				password: test
+				permissions: {
+					subscribe: {
+						deny: "denied"
+					}
+				}
+			}
+		]
+	}
+`))
+	defer os.Remove(conf)
+
+	s, _ := RunServerWithConfig(conf)
+	defer s.Shutdown()
+
+	connect := func(t *testing.T) *nats.Conn {
+		t.Helper()
+		nc, err := nats.Connect(s.ClientURL(),
+			nats.UserInfo("test", "test"),
+			nats.PermissionErrOnSubscribe(true),
+			nats.ErrorHandler(func(*nats.Conn, *nats.Subscription, error) {}))
+		if err != nil {
+			t.Fatalf("Error on connect: %v", err)
+		}
+		return nc
+	}
+	nc := connect(t)
+	defer nc.Close()
+
+	check := func(t *testing.T, sub *nats.Subscription, expected error) {
+		t.Helper()
+		if _, err := sub.NextMsg(100 * time.Millisecond); !errors.Is(err, expected) {
+			t.Fatalf("Expected %v, got %v", expected, err)
+		}
+	}
+	publish := func(subj string, n int) {
+		for i := 0; i < n; i++ {
+			nc.Publish(subj, []byte("hello"))
+		}
+		nc.Flush()
+	}
+
+	t.Run("timeout", func(t *testing.T) {
+		sub, _ := nc.SubscribeSync("timeout")
+		defer sub.Unsubscribe()
+		check(t, sub, nats.ErrTimeout)
+	})
+
+	t.Run("unsubscribed", func(t *testing.T) {
+		sub, _ := nc.SubscribeSync("unsub")
+		sub.Unsubscribe()
+		check(t, sub, nats.ErrBadSubscription)
+		if _, err := sub.NextMsg(time.Millisecond); errors.Is(err, nats.ErrSubscriptionDrained) {
+			t.Fatalf("Unsubscribed subscription should not report %v", nats.ErrSubscriptionDrained)
+		}
+	})
+
+	t.Run("drained", func(t *testing.T) {
+		sub, _ := nc.SubscribeSync("drain")
+		nc.Flush()
+		publish("drain", 1)
+		if err := sub.Drain(); err != nil {
+			t.Fatalf("Error on drain: %v", err)
+		}
+		if _, err := sub.NextMsg(time.Second); err != nil {
+			t.Fatalf("Pending message should be returned while draining: %v", err)
+		}
+		waitFor(t, time.Second, 15*time.Millisecond, func() error {
+			if sub.IsValid() {
+				return errors.New("subscription still valid")
+			}
+			return nil
+		})
+		check(t, sub, nats.ErrSubscriptionDrained)
+		check(t, sub, nats.ErrBadSubscription)
+	})
+
+	t.Run("max messages", func(t *testing.T) {
+		sub, _ := nc.SubscribeSync("max")
+		sub.AutoUnsubscribe(1)
+		publish("max", 2)
+		if _, err := sub.NextMsg(time.Second); err != nil {
+			t.Fatalf("Error receiving message: %v", err)
+		}
+		check(t, sub, nats.ErrMaxMessages)
+	})
+
+	t.Run("slow consumer", func(t *testing.T) {
+		sub, _ := nc.SubscribeSync("slow")
+		defer sub.Unsubscribe()
+		sub.SetPendingLimits(1, -1)
+		publish("slow", 5)
+		check(t, sub, nats.ErrSlowConsumer)
+	})
+
+	t.Run("permission violation", func(t *testing.T) {
+		sub, _ := nc.SubscribeSync("denied")
+		defer sub.Unsubscribe()
+		check(t, sub, nats.ErrPermissionViolation)
+	})
+
+	t.Run("connection closed", func(t *testing.T) {
+		nc2 := connect(t)
+		sub, _ := nc2.SubscribeSync("closed")
+		nc2.Close()
+		check(t, sub, nats.ErrConnectionClosed)
+	})
+}
+
+func TestTryNextMsg(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	sub, err := nc.SubscribeSync("foo")
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	if _, err := sub.TryNextMsg(); err != nats.ErrNoMessages {
+		t.Fatalf("Expected %v, got %v", nats.ErrNoMessages, err)
+	}
+
+	for i := 0; i < 3; i++ {
+		nc.Publish("foo", []byte(strconv.Itoa(i)))
+	}
+	nc.Flush()
+	// Limit to 2 messages, with 3 already pending.
+	if err := sub.AutoUnsubscribe(2); err != nil {
+		t.Fatalf("Error on auto unsubscribe: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		m, err := sub.TryNextMsg()
+		if err != nil {
+			t.Fatalf("Error on TryNextMsg: %v", err)
+		}
+		if string(m.Data) != strconv.Itoa(i) {
+			t.Fatalf("Expected message %d, got %q", i, m.Data)
+		}
+	}
+	if _, err := sub.TryNextMsg(); err != nats.ErrMaxMessages {
+		t.Fatalf("Expected %v, got %v", nats.ErrMaxMessages, err)
+	}
+
+	sub, err = nc.SubscribeSync("bar")
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	sub.Unsubscribe()
+	if _, err := sub.TryNextMsg(); err != nats.ErrBadSubscription {
+		t.Fatalf("Expected %v, got %v", nats.ErrBadSubscription, err)
+	}
+
+	sub, err = nc.Subscribe("baz", func(_ *nats.Msg) {})
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	if _, err := sub.TryNextMsg(); err != nats.ErrSyncSubRequired {
+		t.Fatalf("Expected %v, got %v", nats.ErrSyncSubRequired, err)
+	}
+
+	nc.Close()
+	if _, err := sub.TryNextMsg(); err != nats.ErrConnectionClosed {
+		t.Fatalf("Expected %v, got %v", nats.ErrConnectionClosed, err)
+	}
+}
+
+func TestSelectNextMsg(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	subjs := []string{"foo", "bar", "baz"}
+	var subs []*nats.Subscription
+	for _, subj := range subjs {
+		sub, err := nc.SubscribeSync(subj)
+		if err != nil {
+			t.Fatalf("Error on subscribe: %v", err)
+		}
+		subs = append(subs, sub)
+	}
+	if _, _, err := nats.SelectNextMsg(50*time.Millisecond, subs...); err != nats.ErrTimeout {
+		t.Fatalf("Expected %v, got %v", nats.ErrTimeout, err)
+	}
+
+	// Only the first message of the last subscription is delivered.
+	if err := subs[2].AutoUnsubscribe(1); err != nil {
+		t.Fatalf("Error on auto unsubscribe: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		for _, subj := range subjs {
+			nc.Publish(subj, []byte(strconv.Itoa(i)))
+		}
+	}
+	nc.Flush()
+
+	received := make(map[string][]string)
+	for i := 0; i < 7; i++ {
+		sub, m, err := nats.SelectNextMsg(time.Second, subs...)
+		if err != nil {
+			t.Fatalf("Error on select: %v", err)
+		}
+		if m.Sub != sub || m.Subject != sub.Subject {
+			t.Fatalf("Message %q delivered to subscription on %q", m.Subject, sub.Subject)
+		}
+		received[m.Subject] = append(received[m.Subject], string(m.Data))
+	}
+	expected := map[string][]string{
+		"foo": {"0", "1", "2"},
+		"bar": {"0", "1", "2"},
+		"baz": {"0"},
+	}
+	if !reflect.DeepEqual(received, expected) {
+		t.Fatalf("Expected %v, got %v", expected, received)
+	}
+	if _, _, err := nats.SelectNextMsg(50*time.Millisecond, subs...); err != nats.ErrTimeout {
+		t.Fatalf("Expected %v, got %v", nats.ErrTimeout, err)
+	}
+
+	subs[0].Unsubscribe()
+	subs[1].Unsubscribe()
+	if _, _, err := nats.SelectNextMsg(time.Second, subs...); err != nats.ErrBadSubscription {
+		t.Fatalf("Expected %v, got %v", nats.ErrBadSubscription, err)
+	}
+
+	async, err := nc.Subscribe("foo", func(_ *nats.Msg) {})
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	if sub, _, err := nats.SelectNextMsg(time.Second, async); err != nats.ErrSyncSubRequired || sub != async {
+		t.Fatalf("Expected %v, got %v", nats.ErrSyncSubRequired, err)
+	}
+}
+
+func TestChanSubscriber(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	// Create our own channel.
+	ch := make(chan *nats.Msg, 128)
+
+	// Channel is mandatory
+	if _, err := nc.ChanSubscribe("foo", nil); err == nil {
+		t.Fatal("Creating subscription without channel should have failed")
+	}
+
+	_, err := nc.ChanSubscribe("foo", ch)
+	if err != nil {
+		t.Fatal("Failed to subscribe: ", err)
+	}
+
+	// Send some messages to ourselves.
+	total := 100
+	for i := 0; i < total; i++ {
+		nc.Publish("foo", []byte("Hello"))
+	}
+
+	received := 0
+	tm := time.NewTimer(5 * time.Second)
+	defer tm.Stop()
+
+	// Go ahead and receive
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				t.Fatalf("Got an error reading from channel")
+			}
+		case <-tm.C:
+			t.Fatalf("Timed out waiting on messages")
+		}
+		received++
+		if received >= total {
+			return
+		}
+	}
+}
+
+func TestChanSubscribeMulti(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	errCh := make(chan error, 10)
+	nc, err := nats.Connect(nats.DefaultURL, nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) {
+		errCh <- err
+	}))
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer nc.Close()
+
+	if _, err := nc.ChanSubscribeMulti("foo"); err != nats.ErrInvalidArg {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+	}
+	if _, err := nc.ChanSubscribeMulti("foo", make(chan *nats.Msg), nil); err != nats.ErrInvalidArg {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+	}
+
+	fast1 := make(chan *nats.Msg, 64)
+	fast2 := make(chan *nats.Msg, 64)
+	slow := make(chan *nats.Msg, 2)
+	sub, err := nc.ChanSubscribeMulti("foo", fast1, slow, fast2)
+	if err != nil {
+		t.Fatalf("Error subscribing: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	total := 10
+	for i := 0; i < total; i++ {
+		nc.Publish("foo", []byte(fmt.Sprintf("%d", i)))
+	}
+	nc.Flush()
+
+	for _, ch := range []chan *nats.Msg{fast1, fast2} {
+		for i := 0; i < total; i++ {
+			select {
+			case m := <-ch:
+				if string(m.Data) != fmt.Sprintf("%d", i) {
+					t.Fatalf("Unexpected message: %q", m.Data)
+				}
+				if m.ReceivedAt().IsZero() {
+					t.Fatalf("Expected receive time to be set on message %d", i)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("Timed out waiting for message %d", i)
+			}
+		}
+	}
+	if len(slow) != 2 {
+		t.Fatalf("Expected slow channel to have 2 messages, got %d", len(slow))
+	}
+	if d, _ := sub.Dropped(); d != total-2 {
+		t.Fatalf("Expected %d dropped, got %d", total-2, d)
+	}
+
+	// Error handler is invoked once for the slow channel.
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, nats.ErrSlowConsumer) || !strings.Contains(err.Error(), "channel 1") {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Error handler was not invoked")
+	}
+	select {
+	case err := <-errCh:
+		t.Fatalf("Unexpected error: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Slow channel kept the messages it had room for.
+	m1, m2 := <-slow, <-slow
+	if string(m1.Data) != "0" || string(m2.Data) != "1" {
+		t.Fatalf("Unexpected messages on slow channel: %q %q", m1.Data, m2.Data)
+	}
+}
+
+func TestChanQueueSubscriber(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	// Create our own channel.
+	ch1 := make(chan *nats.Msg, 64)
+	ch2 := make(chan *nats.Msg, 64)
+
+	nc.ChanQueueSubscribe("foo", "bar", ch1)
+	nc.ChanQueueSubscribe("foo", "bar", ch2)
+
+	// Send some messages to ourselves.
+	total := 100
+	for i := 0; i < total; i++ {
+		nc.Publish("foo", []byte("Hello"))
+	}
+
+	received := 0
+	tm := time.NewTimer(5 * time.Second)
+	defer tm.Stop()
+
+	chk := func(ok bool) {
+		if !ok {
+			t.Fatalf("Got an error reading from channel")
+		} else {
+			received++
+		}
+	}
+
+	// Go ahead and receive
+	for {
+		select {
+		case _, ok := <-ch1:
+			chk(ok)
+		case _, ok := <-ch2:
+			chk(ok)
+		case <-tm.C:
+			t.Fatalf("Timed out waiting on messages")
+		}
+		if received >= total {
+			return
+		}
+	}
+}
+
+func TestChanSubscriberPendingLimits(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+	ncp := NewDefaultConnection(t)
+	defer ncp.Close()
+
+	// There was a defect that prevented to receive more than
+	// the default pending message limit. Trying to send more
+	// than this limit.
+	pending := 1000
+	total := pending + 100
+
+	for typeSubs := 0; typeSubs < 3; typeSubs++ {
+
+		func() {
+			// Create our own channel.
+			ch := make(chan *nats.Msg, total)
+
+			var err error
+			var sub *nats.Subscription
+			switch typeSubs {
+			case 0:
+				sub, err = nc.ChanSubscribe("foo", ch)
+				if err := sub.SetPendingLimits(pending, -1); err == nil {
+					t.Fatalf("Expected an error setting pending limits")
+				}
+			case 1:
+				sub, err = nc.ChanQueueSubscribe("foo", "bar", ch)
+				if err := sub.SetPendingLimits(pending, -1); err == nil {
+					t.Fatalf("Expected an error setting pending limits")
+				}
+			case 2:
+				sub, err = nc.QueueSubscribeSyncWithChan("foo", "bar", ch)
+				if err := sub.SetPendingLimits(pending, -1); err == nil {
+					t.Fatalf("Expected an error setting pending limits")
+				}
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error on subscribe: %v", err)
+			}
+			defer sub.Unsubscribe()
+			nc.Flush()
+
+			// Send some messages
+			for i := 0; i < total; i++ {
+				if err := ncp.Publish("foo", []byte("Hello")); err != nil {
+					t.Fatalf("Unexpected error on publish: %v", err)
+				}
+			}
+
+			received := 0
+			tm := time.NewTimer(10 * time.Second)
+			defer tm.Stop()
+
+			chk := func(ok bool) {
+				if !ok {
+					t.Fatalf("Got an error reading from channel")
+				} else {
+					received++
+				}
+			}
+
+			// Go ahead and receive
+			for {
+				select {
+				case _, ok := <-ch:
+					chk(ok)
+					if received >= total {
+						return
+					}
+				case <-tm.C:
+					t.Fatalf("Timed out waiting on messages for test %d, received %d", typeSubs, received)
+				}
+			}
+		}()
+	}
+}
+
+func TestQueueChanQueueSubscriber(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	// Create our own channel.
 	ch1 := make(chan *nats.Msg, 64)
 	ch2 := make(chan *nats.Msg, 64)
 
-	nc.QueueSubscribeSyncWithChan("foo", "bar", ch1)
-	nc.QueueSubscribeSyncWithChan("foo", "bar", ch2)
+	nc.QueueSubscribeSyncWithChan("foo", "bar", ch1)
+	nc.QueueSubscribeSyncWithChan("foo", "bar", ch2)
+
+	// Send some messages to ourselves.
+	total := 100
+	for i := 0; i < total; i++ {
+		nc.Publish("foo", []byte("Hello"))
+	}
+
+	recv1 := 0
+	recv2 := 0
+	tm := time.NewTimer(5 * time.Second)
+	defer tm.Stop()
+	runTimer := time.NewTimer(500 * time.Millisecond)
+	defer runTimer.Stop()
+
+	chk := func(ok bool, which int) {
+		if !ok {
+			t.Fatalf("Got an error reading from channel")
+		} else {
+			if which == 1 {
+				recv1++
+			} else {
+				recv2++
+			}
+		}
+	}
+
+	// Go ahead and receive
+recvLoop:
+	for {
+		select {
+		case _, ok := <-ch1:
+			chk(ok, 1)
+		case _, ok := <-ch2:
+			chk(ok, 2)
+		case <-tm.C:
+			t.Fatalf("Timed out waiting on messages")
+		case <-runTimer.C:
+			break recvLoop
+		}
+	}
+
+	if recv1+recv2 > total {
+		t.Fatalf("Received more messages than expected: %v vs %v", (recv1 + recv2), total)
+	}
+}
+
+func TestSubscriptionQueueName(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	check := func(sub *nats.Subscription, err error, queue string) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("Error subscribing: %v", err)
+		}
+		if sub.Queue != queue {
+			t.Fatalf("Expected queue %q, got %q", queue, sub.Queue)
+		}
+	}
+
+	sub, err := nc.QueueSubscribe("foo", "q1", func(_ *nats.Msg) {})
+	check(sub, err, "q1")
+	sub, err = nc.QueueSubscribeSync("foo", "q2")
+	check(sub, err, "q2")
+	sub, err = nc.ChanQueueSubscribe("foo", "q3", make(chan *nats.Msg, 8))
+	check(sub, err, "q3")
+	sub, err = nc.QueueSubscribeSyncWithChan("foo", "q4", make(chan *nats.Msg, 8))
+	check(sub, err, "q4")
+
+	sub, err = nc.Subscribe("foo", func(_ *nats.Msg) {})
+	check(sub, err, "")
+	sub, err = nc.SubscribeSync("foo")
+	check(sub, err, "")
+	sub, err = nc.ChanSubscribe("foo", make(chan *nats.Msg, 8))
+	check(sub, err, "")
+}
+
+func TestUnsubscribeChanOnSubscriber(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	// Override default handler for test.
+	nc.SetErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, _ error) {})
+
+	// Create our own channel.
+	ch := make(chan *nats.Msg, 8)
+	sub, _ := nc.ChanSubscribe("foo", ch)
+
+	// Send some messages to ourselves.
+	total := 100
+	for i := 0; i < total; i++ {
+		nc.Publish("foo", []byte("Hello"))
+	}
+
+	sub.Unsubscribe()
+	for len(ch) > 0 {
+		<-ch
+	}
+	// Make sure we can send to the channel still.
+	// Test that we do not close it.
+	ch <- &nats.Msg{}
+}
+
+func TestCloseChanOnSubscriber(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	// Create our own channel.
+	ch := make(chan *nats.Msg, 8)
+	nc.ChanSubscribe("foo", ch)
+
+	// Send some messages to ourselves.
+	total := 100
+	for i := 0; i < total; i++ {
+		nc.Publish("foo", []byte("Hello"))
+	}
+
+	nc.Close()
+	for len(ch) > 0 {
+		<-ch
+	}
+	// Make sure we can send to the channel still.
+	// Test that we do not close it.
+	ch <- &nats.Msg{}
+}
+
+func TestChanSubscribeManaged(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	// Receives what is left in the channel, failing if it is not closed.
+	drain := func(t *testing.T, ch <-chan *nats.Msg) int {
+		t.Helper()
+		var n int
+		done := make(chan struct{})
+		go func() {
+			for range ch {
+				n++
+			}
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("Channel should have been closed")
+		}
+		return n
+	}
+	publish := func(subj string, n int) {
+		for i := 0; i < n; i++ {
+			nc.Publish(subj, []byte("hello"))
+		}
+		nc.Flush()
+	}
+
+	t.Run("unsubscribe", func(t *testing.T) {
+		sub, ch, err := nc.ChanSubscribeManaged("foo")
+		if err != nil {
+			t.Fatalf("Error on subscribe: %v", err)
+		}
+		publish("foo", 5)
+		waitFor(t, time.Second, 15*time.Millisecond, func() error {
+			if len(ch) != 5 {
+				return fmt.Errorf("expected 5 messages, got %d", len(ch))
+			}
+			return nil
+		})
+		select {
+		case <-time.After(50 * time.Millisecond):
+		case _, ok := <-ch:
+			if !ok {
+				t.Fatal("Channel should not be closed while subscribed")
+			}
+		}
+		sub.Unsubscribe()
+		if n := drain(t, ch); n != 4 {
+			t.Fatalf("Expected 4 remaining messages, got %d", n)
+		}
+		// Unsubscribing again does not close the channel twice.
+		if err := sub.Unsubscribe(); err != nats.ErrBadSubscription {
+			t.Fatalf("Expected %v, got %v", nats.ErrBadSubscription, err)
+		}
+	})
+
+	t.Run("drain", func(t *testing.T) {
+		sub, ch, _ := nc.ChanSubscribeManaged("bar")
+		publish("bar", 3)
+		if err := sub.Drain(); err != nil {
+			t.Fatalf("Error on drain: %v", err)
+		}
+		if n := drain(t, ch); n != 3 {
+			t.Fatalf("Expected 3 messages, got %d", n)
+		}
+	})
+
+	t.Run("connection closed", func(t *testing.T) {
+		nc2 := NewDefaultConnection(t)
+		_, ch, _ := nc2.ChanSubscribeManaged("foo")
+		nc2.Close()
+		if n := drain(t, ch); n != 0 {
+			t.Fatalf("Expected no message, got %d", n)
+		}
+	})
+}
+
+func TestAsyncSubscriptionPending(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	// Send some messages to ourselves.
+	total := 100
+	msg := []byte("0123456789")
+
+	inCb := make(chan bool)
+	block := make(chan bool)
+
+	sub, _ := nc.Subscribe("foo", func(m *nats.Msg) {
+		inCb <- true
+		<-block
+		// Avoid repeated calls to this callback
+		m.Sub.Unsubscribe()
+	})
+	defer sub.Unsubscribe()
+
+	for i := 0; i < total; i++ {
+		nc.Publish("foo", msg)
+	}
+	nc.Flush()
+
+	// Wait that a message is received, so checks are safe
+	if err := Wait(inCb); err != nil {
+		t.Fatal("No message received")
+	}
+
+	// Test old way
+	q, _, _ := sub.Pending()
+	if q != total && q != total-1 {
+		t.Fatalf("Expected %d or %d, got %d", total, total-1, q)
+	}
+
+	// New way, make sure the same and check bytes.
+	m, b, _ := sub.Pending()
+	mlen := len(msg)
+	totalSize := total * mlen
+
+	if m != total && m != total-1 {
+		t.Fatalf("Expected msgs of %d or %d, got %d", total, total-1, m)
+	}
+	if b != totalSize && b != totalSize-mlen {
+		t.Fatalf("Expected bytes of %d or %d, got %d",
+			totalSize, totalSize-mlen, b)
+	}
+
+	// Make sure max has been set. Since we block after the first message is
+	// received, MaxPending should be >= total - 1 and <= total
+	mm, bm, _ := sub.MaxPending()
+	if mm < total-1 || mm > total {
+		t.Fatalf("Expected max msgs (%d) to be between %d and %d",
+			mm, total-1, total)
+	}
+	if bm < totalSize-mlen || bm > totalSize {
+		t.Fatalf("Expected max bytes (%d) to be between %d and %d",
+			bm, totalSize, totalSize-mlen)
+	}
+	// Check that clear works.
+	sub.ClearMaxPending()
+	mm, bm, _ = sub.MaxPending()
+	if mm != 0 {
+		t.Fatalf("Expected max msgs to be 0 vs %d after clearing", mm)
+	}
+	if bm != 0 {
+		t.Fatalf("Expected max bytes to be 0 vs %d after clearing", bm)
+	}
+
+	close(block)
+	sub.Unsubscribe()
+
+	// These calls should fail once the subscription is closed.
+	if _, _, err := sub.Pending(); err == nil {
+		t.Fatal("Calling Pending() on closed subscription should fail")
+	}
+	if _, _, err := sub.MaxPending(); err == nil {
+		t.Fatal("Calling MaxPending() on closed subscription should fail")
+	}
+	if err := sub.ClearMaxPending(); err == nil {
+		t.Fatal("Calling ClearMaxPending() on closed subscription should fail")
+	}
+}
+
+func TestPendingHighWaterEvents(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	sub, err := nc.SubscribeSync("foo")
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	if err := sub.WatchPendingThreshold(0); err != nats.ErrInvalidArg {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+	}
+	for _, threshold := range []int{5, 10} {
+		if err := sub.WatchPendingThreshold(threshold); err != nil {
+			t.Fatalf("Error registering threshold: %v", err)
+		}
+	}
+
+	burst := func(n int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			nc.Publish("foo", []byte("hello"))
+		}
+		nc.Flush()
+		for i := 0; i < n; i++ {
+			if _, err := sub.NextMsg(time.Second); err != nil {
+				t.Fatalf("Error receiving message: %v", err)
+			}
+		}
+	}
+	check := func(threshold int, expected int64) {
+		t.Helper()
+		if n := sub.PendingHighWaterEvents(threshold); n != expected {
+			t.Fatalf("Expected %d events above %d, got %d", expected, threshold, n)
+		}
+	}
+
+	burst(7)
+	check(5, 1)
+	check(10, 0)
+	burst(12)
+	check(5, 2)
+	check(10, 1)
+	// Thresholds that were not registered are not tracked.
+	check(3, 0)
+
+	if err := sub.ClearMaxPending(); err != nil {
+		t.Fatalf("Error clearing max pending: %v", err)
+	}
+	check(5, 0)
+	check(10, 0)
+
+	sub.Unsubscribe()
+	if err := sub.WatchPendingThreshold(5); err != nats.ErrBadSubscription {
+		t.Fatalf("Expected %v, got %v", nats.ErrBadSubscription, err)
+	}
+
+	ch := make(chan *nats.Msg, 10)
+	csub, _ := nc.ChanSubscribe("bar", ch)
+	if err := csub.WatchPendingThreshold(5); err != nats.ErrTypeSubscription {
+		t.Fatalf("Expected %v, got %v", nats.ErrTypeSubscription, err)
+	}
+}
+
+func TestAsyncSubscriptionPendingDrain(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	// Send some messages to ourselves.
+	total := 100
+	msg := []byte("0123456789")
+
+	sub, _ := nc.Subscribe("foo", func(_ *nats.Msg) {})
+	defer sub.Unsubscribe()
+
+	for i := 0; i < total; i++ {
+		nc.Publish("foo", msg)
+	}
+	nc.Flush()
+
+	// Wait for all delivered.
+	waitFor(t, 2*time.Second, 15*time.Millisecond, func() error {
+		if d, _ := sub.Delivered(); d != int64(total) {
+			return fmt.Errorf("Wrong delivered count: %v vs %v", d, total)
+		}
+		m, b, _ := sub.Pending()
+		if m != 0 {
+			return fmt.Errorf("Expected msgs of 0, got %d", m)
+		}
+		if b != 0 {
+			return fmt.Errorf("Expected bytes of 0, got %d", b)
+		}
+		return nil
+	})
+
+	sub.Unsubscribe()
+	if _, err := sub.Delivered(); err == nil {
+		t.Fatal("Calling Delivered() on closed subscription should fail")
+	}
+}
+
+func TestSyncSubscriptionPendingDrain(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	// Send some messages to ourselves.
+	total := 100
+	msg := []byte("0123456789")
+
+	sub, _ := nc.SubscribeSync("foo")
+	defer sub.Unsubscribe()
+
+	for i := 0; i < total; i++ {
+		nc.Publish("foo", msg)
+	}
+	nc.Flush()
+
+	// Wait for all delivered.
+	for d, _ := sub.Delivered(); d != int64(total); d, _ = sub.Delivered() {
+		sub.NextMsg(10 * time.Millisecond)
+	}
+
+	m, b, _ := sub.Pending()
+	if m != 0 {
+		t.Fatalf("Expected msgs of 0, got %d", m)
+	}
+	if b != 0 {
+		t.Fatalf("Expected bytes of 0, got %d", b)
+	}
+
+	sub.Unsubscribe()
+	if _, err := sub.Delivered(); err == nil {
+		t.Fatal("Calling Delivered() on closed subscription should fail")
+	}
+}
+
+func TestSyncSubscriptionPending(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	sub, _ := nc.SubscribeSync("foo")
+	defer sub.Unsubscribe()
+
+	// Send some messages to ourselves.
+	total := 100
+	msg := []byte("0123456789")
+	for i := 0; i < total; i++ {
+		nc.Publish("foo", msg)
+	}
+	nc.Flush()
+
+	// Test old way
+	q, _, _ := sub.Pending()
+	if q != total && q != total-1 {
+		t.Fatalf("Expected %d or %d, got %d", total, total-1, q)
+	}
+
+	// New way, make sure the same and check bytes.
+	m, b, _ := sub.Pending()
+	mlen := len(msg)
+
+	if m != total {
+		t.Fatalf("Expected msgs of %d, got %d", total, m)
+	}
+	if b != total*mlen {
+		t.Fatalf("Expected bytes of %d, got %d", total*mlen, b)
+	}
+
+	// Now drain some down and make sure pending is correct
+	for i := 0; i < total-1; i++ {
+		sub.NextMsg(10 * time.Millisecond)
+	}
+	m, b, _ = sub.Pending()
+	if m != 1 {
+		t.Fatalf("Expected msgs of 1, got %d", m)
+	}
+	if b != mlen {
+		t.Fatalf("Expected bytes of %d, got %d", mlen, b)
+	}
+}
+
+func TestSetPendingLimits(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	// Override default handler for test.
+	nc.SetErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, _ error) {})
+
+	payload := []byte("hello")
+	payloadLen := len(payload)
+	toSend := 100
+
+	var sub *nats.Subscription
+
+	// Check for invalid values
+	invalid := func() error {
+		if err := sub.SetPendingLimits(0, 1); err == nil {
+			return errors.New("Setting limit with 0 should fail")
+		}
+		if err := sub.SetPendingLimits(1, 0); err == nil {
+			return errors.New("Setting limit with 0 should fail")
+		}
+		return nil
+	}
+	// function to send messages
+	send := func(subject string, count int) {
+		for i := 0; i < count; i++ {
+			if err := nc.Publish(subject, payload); err != nil {
+				t.Fatalf("Unexpected error on publish: %v", err)
+			}
+		}
+		nc.Flush()
+	}
+
+	// Check pending vs expected values
+	var limitCount, limitBytes int
+	var expectedCount, expectedBytes int
+	checkPending := func() error {
+		lc, lb, err := sub.PendingLimits()
+		if err != nil {
+			return err
+		}
+		if lc != limitCount || lb != limitBytes {
+			return fmt.Errorf("Unexpected limits, expected %v msgs %v bytes, got %v msgs %v bytes",
+				limitCount, limitBytes, lc, lb)
+		}
+		msgs, bytes, err := sub.Pending()
+		if err != nil {
+			return fmt.Errorf("Unexpected error getting pending counts: %v", err)
+		}
+		if (msgs != expectedCount && msgs != expectedCount-1) ||
+			(bytes != expectedBytes && bytes != expectedBytes-payloadLen) {
+			return fmt.Errorf("Unexpected counts, expected %v msgs %v bytes, got %v msgs %v bytes",
+				expectedCount, expectedBytes, msgs, bytes)
+		}
+		return nil
+	}
+
+	recv := make(chan bool)
+	block := make(chan bool)
+	cb := func(m *nats.Msg) {
+		recv <- true
+		<-block
+		m.Sub.Unsubscribe()
+	}
+	subj := "foo"
+	sub, err := nc.Subscribe(subj, cb)
+	if err != nil {
+		t.Fatalf("Unexpected error on subscribe: %v", err)
+	}
+	defer sub.Unsubscribe()
+	if err := invalid(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	// Check we apply limit only for size
+	limitCount = -1
+	limitBytes = (toSend / 2) * payloadLen
+	if err := sub.SetPendingLimits(limitCount, limitBytes); err != nil {
+		t.Fatalf("Unexpected error setting limits: %v", err)
+	}
+	// Send messages
+	send(subj, toSend)
+	// Wait for message to be received
+	if err := Wait(recv); err != nil {
+		t.Fatal("Did not get our message")
+	}
+	expectedBytes = limitBytes
+	expectedCount = limitBytes / payloadLen
+	if err := checkPending(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	// Release callback
+	block <- true
+
+	subj = "bar"
+	sub, err = nc.Subscribe(subj, cb)
+	if err != nil {
+		t.Fatalf("Unexpected error on subscribe: %v", err)
+	}
+	defer sub.Unsubscribe()
+	// Check we apply limit only for count
+	limitCount = toSend / 4
+	limitBytes = -1
+	if err := sub.SetPendingLimits(limitCount, limitBytes); err != nil {
+		t.Fatalf("Unexpected error setting limits: %v", err)
+	}
+	// Send messages
+	send(subj, toSend)
+	// Wait for message to be received
+	if err := Wait(recv); err != nil {
+		t.Fatal("Did not get our message")
+	}
+	expectedCount = limitCount
+	expectedBytes = limitCount * payloadLen
+	if err := checkPending(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	// Release callback
+	block <- true
+
+	subj = "baz"
+	sub, err = nc.SubscribeSync(subj)
+	if err != nil {
+		t.Fatalf("Unexpected error on subscribe: %v", err)
+	}
+	defer sub.Unsubscribe()
+	if err := invalid(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	// Check we apply limit only for size
+	limitCount = -1
+	limitBytes = (toSend / 2) * payloadLen
+	if err := sub.SetPendingLimits(limitCount, limitBytes); err != nil {
+		t.Fatalf("Unexpected error setting limits: %v", err)
+	}
+	// Send messages
+	send(subj, toSend)
+	expectedBytes = limitBytes
+	expectedCount = limitBytes / payloadLen
+	if err := checkPending(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	sub.Unsubscribe()
+	nc.Flush()
+
+	subj = "boz"
+	sub, err = nc.SubscribeSync(subj)
+	if err != nil {
+		t.Fatalf("Unexpected error on subscribe: %v", err)
+	}
+	defer sub.Unsubscribe()
+	// Check we apply limit only for count
+	limitCount = toSend / 4
+	limitBytes = -1
+	if err := sub.SetPendingLimits(limitCount, limitBytes); err != nil {
+		t.Fatalf("Unexpected error setting limits: %v", err)
+	}
+	// Send messages
+	send(subj, toSend)
+	expectedCount = limitCount
+	expectedBytes = limitCount * payloadLen
+	if err := checkPending(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	sub.Unsubscribe()
+	nc.Flush()
+}
+
+func TestSubscriptionFilter(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	publish := func(subj string, count int) {
+		t.Helper()
+		for i := 0; i < count; i++ {
+			m := nats.NewMsg(subj)
+			if i%2 == 0 {
+				m.Header.Set("Keep", "yes")
+			}
+			if err := nc.PublishMsg(m); err != nil {
+				t.Fatalf("Error on publish: %v", err)
+			}
+		}
+		nc.Flush()
+	}
+
+	t.Run("async", func(t *testing.T) {
+		received, unexpected := int32(0), int32(0)
+		sub, err := nc.Subscribe("foo", func(m *nats.Msg) {
+			if m.Header.Get("Keep") != "yes" {
+				atomic.AddInt32(&unexpected, 1)
+			}
+			atomic.AddInt32(&received, 1)
+		})
+		if err != nil {
+			t.Fatalf("Error on subscribe: %v", err)
+		}
+		defer sub.Unsubscribe()
+		if err := sub.SetFilter(func(m *nats.Msg) bool {
+			// Calling into the subscription must not deadlock.
+			sub.Pending()
+			return m.Header.Get("Keep") == "yes"
+		}); err != nil {
+			t.Fatalf("Error setting filter: %v", err)
+		}
+		publish("foo", 10)
+		waitFor(t, 2*time.Second, 15*time.Millisecond, func() error {
+			if r := atomic.LoadInt32(&received); r != 5 {
+				return fmt.Errorf("Expected 5 messages, got %d", r)
+			}
+			return nil
+		})
+		if f, _ := sub.Filtered(); f != 5 {
+			t.Fatalf("Expected 5 filtered messages, got %d", f)
+		}
+		if u := atomic.LoadInt32(&unexpected); u != 0 {
+			t.Fatalf("Expected only matching messages, got %d unexpected", u)
+		}
+
+		// Removing the filter delivers everything.
+		sub.SetFilter(nil)
+		publish("foo", 10)
+		waitFor(t, 2*time.Second, 15*time.Millisecond, func() error {
+			if r := atomic.LoadInt32(&received); r != 15 {
+				return fmt.Errorf("Expected 15 messages, got %d", r)
+			}
+			return nil
+		})
+		if f, _ := sub.Filtered(); f != 5 {
+			t.Fatalf("Expected 5 filtered messages, got %d", f)
+		}
+	})
+
+	t.Run("sync", func(t *testing.T) {
+		sub, err := nc.SubscribeSync("bar")
+		if err != nil {
+			t.Fatalf("Error on subscribe: %v", err)
+		}
+		sub.SetFilter(func(m *nats.Msg) bool {
+			return m.Header.Get("Keep") == "yes"
+		})
+		// Filtered messages do not count against the pending limits.
+		sub.SetPendingLimits(5, -1)
+		publish("bar", 10)
+		for i := 0; i < 5; i++ {
+			m, err := sub.NextMsg(time.Second)
+			if err != nil {
+				t.Fatalf("Error on next msg: %v", err)
+			}
+			if m.Header.Get("Keep") != "yes" {
+				t.Fatalf("Unexpected message delivered")
+			}
+		}
+		if _, err := sub.NextMsg(100 * time.Millisecond); err != nats.ErrTimeout {
+			t.Fatalf("Expected %v, got %v", nats.ErrTimeout, err)
+		}
+		if d, _ := sub.Dropped(); d != 0 {
+			t.Fatalf("Expected no dropped messages, got %d", d)
+		}
+		if f, _ := sub.Filtered(); f != 5 {
+			t.Fatalf("Expected 5 filtered messages, got %d", f)
+		}
+		sub.Unsubscribe()
+		if _, err := sub.Filtered(); err != nats.ErrBadSubscription {
+			t.Fatalf("Expected %v, got %v", nats.ErrBadSubscription, err)
+		}
+		if err := sub.SetFilter(nil); err != nats.ErrBadSubscription {
+			t.Fatalf("Expected %v, got %v", nats.ErrBadSubscription, err)
+		}
+	})
+}
+
+func TestSubscriptionLastMsgTime(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	sub, err := nc.SubscribeSync("foo")
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	lt, err := sub.LastMsgTime()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !lt.IsZero() {
+		t.Fatalf("Expected zero time before any message, got %v", lt)
+	}
+
+	start := time.Now()
+	nc.Publish("foo", []byte("hello"))
+	nc.Flush()
+	// The time is recorded when the message is queued, not when it is
+	// consumed with NextMsg.
+	waitFor(t, time.Second, 15*time.Millisecond, func() error {
+		if lt, _ := sub.LastMsgTime(); lt.IsZero() {
+			return fmt.Errorf("Last message time not set")
+		}
+		return nil
+	})
+	first, _ := sub.LastMsgTime()
+	if first.Before(start) {
+		t.Fatalf("Expected last message time to be after %v, got %v", start, first)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	nc.Publish("foo", []byte("hello"))
+	nc.Flush()
+	waitFor(t, time.Second, 15*time.Millisecond, func() error {
+		if lt, _ := sub.LastMsgTime(); !lt.After(first) {
+			return fmt.Errorf("Last message time not updated")
+		}
+		return nil
+	})
 
-	// Send some messages to ourselves.
-	total := 100
-	for i := 0; i < total; i++ {
-		nc.Publish("foo", []byte("Hello"))
+	sub.Unsubscribe()
+	if _, err := sub.LastMsgTime(); err != nats.ErrBadSubscription {
+		t.Fatalf("Expected %v, got %v", nats.ErrBadSubscription, err)
 	}
+}
 
-	recv1 := 0
-	recv2 := 0
-	tm := time.NewTimer(5 * time.Second)
-	defer tm.Stop()
-	runTimer := time.NewTimer(500 * time.Millisecond)
-	defer runTimer.Stop()
+func TestSetPendingLimitsDuringDelivery(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
 
-	chk := func(ok bool, which int) {
-		if !ok {
-			t.Fatalf("Got an error reading from channel")
-		} else {
-			if which == 1 {
-				recv1++
-			} else {
-				recv2++
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	// Override default handler for test.
+	nc.SetErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, _ error) {})
+
+	send := func(count int) {
+		for i := 0; i < count; i++ {
+			if err := nc.Publish("foo", []byte("hello")); err != nil {
+				t.Fatalf("Unexpected error on publish: %v", err)
 			}
 		}
+		nc.Flush()
 	}
 
-	// Go ahead and receive
-recvLoop:
-	for {
-		select {
-		case _, ok := <-ch1:
-			chk(ok, 1)
-		case _, ok := <-ch2:
-			chk(ok, 2)
-		case <-tm.C:
-			t.Fatalf("Timed out waiting on messages")
-		case <-runTimer.C:
-			break recvLoop
+	received := int32(0)
+	block := make(chan struct{})
+	sub, err := nc.Subscribe("foo", func(_ *nats.Msg) {
+		<-block
+		atomic.AddInt32(&received, 1)
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error on subscribe: %v", err)
+	}
+	defer sub.Unsubscribe()
+	if err := sub.SetPendingLimits(10, -1); err != nil {
+		t.Fatalf("Unexpected error setting limits: %v", err)
+	}
+
+	// Raise the limits during a burst, nothing should be dropped.
+	if err := sub.SetPendingLimits(100, -1); err != nil {
+		t.Fatalf("Unexpected error setting limits: %v", err)
+	}
+	send(50)
+	if d, _ := sub.Dropped(); d != 0 {
+		t.Fatalf("Expected no dropped messages, got %d", d)
+	}
+
+	// Lower them below what is buffered. Messages already accepted are kept,
+	// but new ones are dropped.
+	if err := sub.SetPendingLimits(10, -1); err != nil {
+		t.Fatalf("Unexpected error setting limits: %v", err)
+	}
+	if msgs, _, _ := sub.Pending(); msgs < 49 {
+		t.Fatalf("Expected buffered messages to be kept, got %d pending", msgs)
+	}
+	send(5)
+	if d, _ := sub.Dropped(); d != 5 {
+		t.Fatalf("Expected 5 dropped messages, got %d", d)
+	}
+
+	// Once pending drains below the new limit, messages are accepted again.
+	close(block)
+	waitFor(t, 2*time.Second, 15*time.Millisecond, func() error {
+		if r := atomic.LoadInt32(&received); r != 50 {
+			return fmt.Errorf("Expected 50 messages, got %d", r)
+		}
+		return nil
+	})
+	send(5)
+	waitFor(t, 2*time.Second, 15*time.Millisecond, func() error {
+		if r := atomic.LoadInt32(&received); r != 55 {
+			return fmt.Errorf("Expected 55 messages, got %d", r)
 		}
+		return nil
+	})
+	if d, _ := sub.Dropped(); d != 5 {
+		t.Fatalf("Expected 5 dropped messages, got %d", d)
 	}
+}
 
-	if recv1+recv2 > total {
-		t.Fatalf("Received more messages than expected: %v vs %v", (recv1 + recv2), total)
+func TestSetPendingMsgsAndBytesLimit(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	sub, err := nc.SubscribeSync("foo")
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	if err := sub.SetPendingLimits(10, 1000); err != nil {
+		t.Fatalf("Error setting limits: %v", err)
+	}
+	check := func(msgs, bytes int) {
+		t.Helper()
+		lm, lb, err := sub.PendingLimits()
+		if err != nil {
+			t.Fatalf("Error getting limits: %v", err)
+		}
+		if lm != msgs || lb != bytes {
+			t.Fatalf("Expected limits %v msgs %v bytes, got %v msgs %v bytes", msgs, bytes, lm, lb)
+		}
+	}
+	if err := sub.SetPendingMsgsLimit(20); err != nil {
+		t.Fatalf("Error setting msgs limit: %v", err)
+	}
+	check(20, 1000)
+	if err := sub.SetPendingBytesLimit(-1); err != nil {
+		t.Fatalf("Error setting bytes limit: %v", err)
+	}
+	check(20, -1)
+	if err := sub.SetPendingMsgsLimit(0); !errors.Is(err, nats.ErrInvalidArg) {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+	}
+	if err := sub.SetPendingBytesLimit(0); !errors.Is(err, nats.ErrInvalidArg) {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+	}
+	check(20, -1)
+
+	chSub, err := nc.ChanSubscribe("bar", make(chan *nats.Msg, 1))
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	if err := chSub.SetPendingMsgsLimit(1); err != nats.ErrTypeSubscription {
+		t.Fatalf("Expected %v, got %v", nats.ErrTypeSubscription, err)
+	}
+	if err := chSub.SetPendingBytesLimit(1); err != nats.ErrTypeSubscription {
+		t.Fatalf("Expected %v, got %v", nats.ErrTypeSubscription, err)
+	}
+
+	sub.Unsubscribe()
+	if err := sub.SetPendingMsgsLimit(1); err != nats.ErrBadSubscription {
+		t.Fatalf("Expected %v, got %v", nats.ErrBadSubscription, err)
+	}
+	if err := sub.SetPendingBytesLimit(1); err != nats.ErrBadSubscription {
+		t.Fatalf("Expected %v, got %v", nats.ErrBadSubscription, err)
 	}
 }
 
-func TestUnsubscribeChanOnSubscriber(t *testing.T) {
+func TestPendingOverflowHandler(t *testing.T) {
 	s := RunDefaultServer()
 	defer s.Shutdown()
 
 	nc := NewDefaultConnection(t)
 	defer nc.Close()
 
-	// Override default handler for test.
-	nc.SetErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, _ error) {})
+	block := make(chan struct{})
+	sub, err := nc.Subscribe("foo", func(_ *nats.Msg) { <-block })
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	sub.SetPendingLimits(5, -1)
 
-	// Create our own channel.
-	ch := make(chan *nats.Msg, 8)
-	sub, _ := nc.ChanSubscribe("foo", ch)
+	var mu sync.Mutex
+	var spilled []string
+	if err := sub.SetPendingOverflowHandler(func(s *nats.Subscription, m *nats.Msg) {
+		if s != sub {
+			t.Errorf("Unexpected subscription in handler")
+		}
+		mu.Lock()
+		spilled = append(spilled, string(m.Data))
+		mu.Unlock()
+	}); err != nil {
+		t.Fatalf("Error setting handler: %v", err)
+	}
 
-	// Send some messages to ourselves.
-	total := 100
+	total := 20
 	for i := 0; i < total; i++ {
-		nc.Publish("foo", []byte("Hello"))
+		nc.Publish("foo", []byte(fmt.Sprintf("%d", i)))
+	}
+	nc.Flush()
+
+	dropped := 0
+	waitFor(t, 2*time.Second, 15*time.Millisecond, func() error {
+		dropped, _ = sub.Dropped()
+		mu.Lock()
+		defer mu.Unlock()
+		if dropped == 0 || len(spilled) != dropped {
+			return fmt.Errorf("Expected %d spilled messages, got %d", dropped, len(spilled))
+		}
+		return nil
+	})
+	if pending, _, _ := sub.Pending(); pending+dropped > total {
+		t.Fatalf("Unexpected pending %d and dropped %d", pending, dropped)
+	}
+
+	// Removing the handler stops calling it.
+	sub.SetPendingOverflowHandler(nil)
+	nc.Publish("foo", []byte("more"))
+	nc.Flush()
+	waitFor(t, time.Second, 15*time.Millisecond, func() error {
+		if d, _ := sub.Dropped(); d != dropped+1 {
+			return fmt.Errorf("Expected %d dropped, got %d", dropped+1, d)
+		}
+		return nil
+	})
+	mu.Lock()
+	if len(spilled) != dropped {
+		t.Fatalf("Handler should not have been invoked, got %d spilled", len(spilled))
 	}
+	mu.Unlock()
+	close(block)
 
 	sub.Unsubscribe()
-	for len(ch) > 0 {
-		<-ch
+	if err := sub.SetPendingOverflowHandler(nil); err != nats.ErrBadSubscription {
+		t.Fatalf("Expected %v, got %v", nats.ErrBadSubscription, err)
 	}
-	// Make sure we can send to the channel still.
-	// Test that we do not close it.
-	ch <- &nats.Msg{}
 }
 
-func TestCloseChanOnSubscriber(t *testing.T) {
+func TestSubscribeDeadLetter(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(nats.DefaultURL, nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, _ error) {}))
+	if err != nil {
+		t.Fatalf("Error on connect: %v", err)
+	}
+	defer nc.Close()
+
+	noop := func(_ *nats.Msg) {}
+	if _, err := nc.SubscribeWithOpts("foo", noop, nats.SubscribeDeadLetter("dlq.*")); !errors.Is(err, nats.ErrBadSubject) {
+		t.Fatalf("Expected %v, got %v", nats.ErrBadSubject, err)
+	}
+	if _, err := nc.SubscribeWithOpts("foo.>", noop, nats.SubscribeDeadLetter("foo.dlq")); !errors.Is(err, nats.ErrInvalidArg) {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+	}
+
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := js.Subscribe("foo", func(_ *nats.Msg) {}, nats.SubscribeDeadLetter("dlq")); !errors.Is(err, nats.ErrInvalidArg) {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+	}
+
+	dlq, err := nc.SubscribeSync("dlq")
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	started := make(chan struct{}, 1)
+	block := make(chan struct{})
+	defer close(block)
+	sub, err := nc.SubscribeWithOpts("foo", func(_ *nats.Msg) {
+		started <- struct{}{}
+		<-block
+	}, nats.SubscribeDeadLetter("dlq"))
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	sub.SetPendingLimits(1, -1)
+
+	nc.Publish("foo", []byte("0"))
+	WaitOnChannel(t, started, struct{}{})
+	// The message being processed counts toward the limit.
+	total := 5
+	for i := 1; i <= total; i++ {
+		nc.Publish("foo", []byte(strconv.Itoa(i)))
+	}
+	nc.Flush()
+	for i := 1; i <= total; i++ {
+		m, err := dlq.NextMsg(time.Second)
+		if err != nil {
+			t.Fatalf("Error on next msg: %v", err)
+		}
+		if string(m.Data) != strconv.Itoa(i) {
+			t.Fatalf("Expected message %d, got %q", i, m.Data)
+		}
+		if reason := m.Header.Get(nats.DeadLetterReasonHdr); reason != nats.DeadLetterPendingMsgs {
+			t.Fatalf("Unexpected reason %q", reason)
+		}
+	}
+	if d, _ := sub.Dropped(); d != total {
+		t.Fatalf("Expected %d dropped messages, got %d", total, d)
+	}
+
+	// Messages already dead lettered are not republished.
+	m := nats.NewMsg("foo")
+	m.Header.Set(nats.DeadLetterReasonHdr, nats.DeadLetterPendingMsgs)
+	nc.PublishMsg(m)
+	nc.Flush()
+	if m, err := dlq.NextMsg(100 * time.Millisecond); err != nats.ErrTimeout {
+		t.Fatalf("Expected no message, got %v, %v", m, err)
+	}
+}
+
+func TestSubscriptionTypes(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	sub, _ := nc.Subscribe("foo", func(_ *nats.Msg) {})
+	defer sub.Unsubscribe()
+	if st := sub.Type(); st != nats.AsyncSubscription {
+		t.Fatalf("Expected AsyncSubscription, got %v", st)
+	}
+	// Check Pending
+	if err := sub.SetPendingLimits(1, 100); err != nil {
+		t.Fatalf("We should be able to SetPendingLimits()")
+	}
+	if _, _, err := sub.Pending(); err != nil {
+		t.Fatalf("We should be able to call Pending()")
+	}
+	sub.Unsubscribe()
+	if err := sub.SetPendingLimits(1, 100); err == nil {
+		t.Fatal("Calling SetPendingLimits() on closed subscription should fail")
+	}
+	if _, _, err := sub.PendingLimits(); err == nil {
+		t.Fatal("Calling PendingLimits() on closed subscription should fail")
+	}
+
+	sub, _ = nc.SubscribeSync("foo")
+	defer sub.Unsubscribe()
+	if st := sub.Type(); st != nats.SyncSubscription {
+		t.Fatalf("Expected SyncSubscription, got %v", st)
+	}
+	// Check Pending
+	if err := sub.SetPendingLimits(1, 100); err != nil {
+		t.Fatalf("We should be able to SetPendingLimits()")
+	}
+	if _, _, err := sub.Pending(); err != nil {
+		t.Fatalf("We should be able to call Pending()")
+	}
+	sub.Unsubscribe()
+	if err := sub.SetPendingLimits(1, 100); err == nil {
+		t.Fatal("Calling SetPendingLimits() on closed subscription should fail")
+	}
+	if _, _, err := sub.PendingLimits(); err == nil {
+		t.Fatal("Calling PendingLimits() on closed subscription should fail")
+	}
+
+	sub, _ = nc.ChanSubscribe("foo", make(chan *nats.Msg))
+	defer sub.Unsubscribe()
+	if st := sub.Type(); st != nats.ChanSubscription {
+		t.Fatalf("Expected ChanSubscription, got %v", st)
+	}
+	// Check Pending
+	if err := sub.SetPendingLimits(1, 100); err == nil {
+		t.Fatalf("We should NOT be able to SetPendingLimits() on ChanSubscriber")
+	}
+	if _, _, err := sub.Pending(); err == nil {
+		t.Fatalf("We should NOT be able to call Pending() on ChanSubscriber")
+	}
+	if _, _, err := sub.MaxPending(); err == nil {
+		t.Fatalf("We should NOT be able to call MaxPending() on ChanSubscriber")
+	}
+	if err := sub.ClearMaxPending(); err == nil {
+		t.Fatalf("We should NOT be able to call ClearMaxPending() on ChanSubscriber")
+	}
+	if _, _, err := sub.PendingLimits(); err == nil {
+		t.Fatalf("We should NOT be able to call PendingLimits() on ChanSubscriber")
+	}
+}
+
+func TestAutoUnsubOnSyncSubCanStillRespond(t *testing.T) {
 	s := RunDefaultServer()
 	defer s.Shutdown()
 
 	nc := NewDefaultConnection(t)
 	defer nc.Close()
 
-	// Create our own channel.
-	ch := make(chan *nats.Msg, 8)
-	nc.ChanSubscribe("foo", ch)
+	subj := nuid.Next()
+	sub, err := nc.SubscribeSync(subj)
+	if err != nil {
+		t.Fatalf("Error susbscribing: %v", err)
+	}
+	// When the single message is delivered, the
+	// auto unsub will reap the subscription removing
+	// the connection, make sure Respond still works.
+	if err := sub.AutoUnsubscribe(1); err != nil {
+		t.Fatalf("Error autounsub: %v", err)
+	}
 
-	// Send some messages to ourselves.
-	total := 100
-	for i := 0; i < total; i++ {
-		nc.Publish("foo", []byte("Hello"))
+	inbox := nats.NewInbox()
+	if err = nc.PublishRequest(subj, inbox, nil); err != nil {
+		t.Fatalf("Error making request: %v", err)
 	}
 
-	nc.Close()
-	for len(ch) > 0 {
-		<-ch
+	m, err := sub.NextMsg(time.Second)
+	if err != nil {
+		t.Fatalf("Error getting next message")
+	}
+	if err := m.Respond(nil); err != nil {
+		t.Fatalf("Error responding: %v", err)
 	}
-	// Make sure we can send to the channel still.
-	// Test that we do not close it.
-	ch <- &nats.Msg{}
 }
 
-func TestAsyncSubscriptionPending(t *testing.T) {
+func TestAutoUnsubOnSyncSubCanStillRespondWithHeaders(t *testing.T) {
 	s := RunDefaultServer()
 	defer s.Shutdown()
 
 	nc := NewDefaultConnection(t)
 	defer nc.Close()
 
-	// Send some messages to ourselves.
-	total := 100
-	msg := []byte("0123456789")
-
-	inCb := make(chan bool)
-	block := make(chan bool)
-
-	sub, _ := nc.Subscribe("foo", func(m *nats.Msg) {
-		inCb <- true
-		<-block
-		// Avoid repeated calls to this callback
-		m.Sub.Unsubscribe()
-	})
-	defer sub.Unsubscribe()
-
-	for i := 0; i < total; i++ {
-		nc.Publish("foo", msg)
+	subj := nuid.Next()
+	sub, err := nc.SubscribeSync(subj)
+	if err != nil {
+		t.Fatalf("Error susbscribing: %v", err)
 	}
-	nc.Flush()
-
-	// Wait that a message is received, so checks are safe
-	if err := Wait(inCb); err != nil {
-		t.Fatal("No message received")
+	if err := sub.AutoUnsubscribe(1); err != nil {
+		t.Fatalf("Error autounsub: %v", err)
 	}
 
-	// Test old way
-	q, _, _ := sub.Pending()
-	if q != total && q != total-1 {
-		t.Fatalf("Expected %d or %d, got %d", total, total-1, q)
+	// A request without a reply subject is rejected.
+	m := nats.NewMsg(subj)
+	m.Header.Set("Foo", "bar")
+	if err := nc.PublishRequestMsg(m); err != nats.ErrMsgNoReply {
+		t.Fatalf("Expected %v, got %v", nats.ErrMsgNoReply, err)
+	}
+	if err := nc.PublishRequestMsg(nil); err != nats.ErrInvalidMsg {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidMsg, err)
 	}
 
-	// New way, make sure the same and check bytes.
-	m, b, _ := sub.Pending()
-	mlen := len(msg)
-	totalSize := total * mlen
-
-	if m != total && m != total-1 {
-		t.Fatalf("Expected msgs of %d or %d, got %d", total, total-1, m)
+	inbox := nats.NewInbox()
+	rsub, err := nc.SubscribeSync(inbox)
+	if err != nil {
+		t.Fatalf("Error susbscribing: %v", err)
 	}
-	if b != totalSize && b != totalSize-mlen {
-		t.Fatalf("Expected bytes of %d or %d, got %d",
-			totalSize, totalSize-mlen, b)
+	m.Reply = inbox
+	if err = nc.PublishRequestMsg(m); err != nil {
+		t.Fatalf("Error making request: %v", err)
 	}
 
-	// Make sure max has been set. Since we block after the first message is
-	// received, MaxPending should be >= total - 1 and <= total
-	mm, bm, _ := sub.MaxPending()
-	if mm < total-1 || mm > total {
-		t.Fatalf("Expected max msgs (%d) to be between %d and %d",
-			mm, total-1, total)
+	rm, err := sub.NextMsg(time.Second)
+	if err != nil {
+		t.Fatalf("Error getting next message")
 	}
-	if bm < totalSize-mlen || bm > totalSize {
-		t.Fatalf("Expected max bytes (%d) to be between %d and %d",
-			bm, totalSize, totalSize-mlen)
+	if v := rm.Header.Get("Foo"); v != "bar" {
+		t.Fatalf("Expected header to be 'bar', got %q", v)
 	}
-	// Check that clear works.
-	sub.ClearMaxPending()
-	mm, bm, _ = sub.MaxPending()
-	if mm != 0 {
-		t.Fatalf("Expected max msgs to be 0 vs %d after clearing", mm)
+	resp := nats.NewMsg(rm.Reply)
+	resp.Header.Set("Foo", "baz")
+	if err := rm.RespondMsg(resp); err != nil {
+		t.Fatalf("Error responding: %v", err)
 	}
-	if bm != 0 {
-		t.Fatalf("Expected max bytes to be 0 vs %d after clearing", bm)
+	rm, err = rsub.NextMsg(time.Second)
+	if err != nil {
+		t.Fatalf("Error getting response: %v", err)
 	}
-
-	close(block)
-	sub.Unsubscribe()
-
-	// These calls should fail once the subscription is closed.
-	if _, _, err := sub.Pending(); err == nil {
-		t.Fatal("Calling Pending() on closed subscription should fail")
+	if v := rm.Header.Get("Foo"); v != "baz" {
+		t.Fatalf("Expected header to be 'baz', got %q", v)
 	}
-	if _, _, err := sub.MaxPending(); err == nil {
-		t.Fatal("Calling MaxPending() on closed subscription should fail")
+
+	// The subscription is gone, so the next request gets no responders.
+	m = nats.NewMsg(subj)
+	m.Reply = inbox
+	m.Header.Set("Foo", "bar")
+	if err = nc.PublishRequestMsg(m); err != nil {
+		t.Fatalf("Error making request: %v", err)
 	}
-	if err := sub.ClearMaxPending(); err == nil {
-		t.Fatal("Calling ClearMaxPending() on closed subscription should fail")
+	if _, err := rsub.NextMsg(time.Second); err != nats.ErrNoResponders {
+		t.Fatalf("Expected %v, got %v", nats.ErrNoResponders, err)
 	}
 }
 
-func TestAsyncSubscriptionPendingDrain(t *testing.T) {
+func TestMsgAckCoreRequest(t *testing.T) {
 	s := RunDefaultServer()
 	defer s.Shutdown()
 
 	nc := NewDefaultConnection(t)
 	defer nc.Close()
 
-	// Send some messages to ourselves.
-	total := 100
-	msg := []byte("0123456789")
-
-	sub, _ := nc.Subscribe("foo", func(_ *nats.Msg) {})
-	defer sub.Unsubscribe()
-
-	for i := 0; i < total; i++ {
-		nc.Publish("foo", msg)
+	sub, err := nc.SubscribeSync("foo")
+	if err != nil {
+		t.Fatalf("Error susbscribing: %v", err)
+	}
+	inbox := nats.NewInbox()
+	rsub, err := nc.SubscribeSync(inbox)
+	if err != nil {
+		t.Fatalf("Error susbscribing: %v", err)
 	}
-	nc.Flush()
 
-	// Wait for all delivered.
-	waitFor(t, 2*time.Second, 15*time.Millisecond, func() error {
-		if d, _ := sub.Delivered(); d != int64(total) {
-			return fmt.Errorf("Wrong delivered count: %v vs %v", d, total)
-		}
-		m, b, _ := sub.Pending()
-		if m != 0 {
-			return fmt.Errorf("Expected msgs of 0, got %d", m)
-		}
-		if b != 0 {
-			return fmt.Errorf("Expected bytes of 0, got %d", b)
-		}
-		return nil
-	})
+	// No reply subject.
+	nc.Publish("foo", []byte("hello"))
+	m, err := sub.NextMsg(time.Second)
+	if err != nil {
+		t.Fatalf("Error getting next message: %v", err)
+	}
+	if err := m.Ack(); err != nats.ErrMsgNoReply {
+		t.Fatalf("Expected %v, got %v", nats.ErrMsgNoReply, err)
+	}
 
-	sub.Unsubscribe()
-	if _, err := sub.Delivered(); err == nil {
-		t.Fatal("Calling Delivered() on closed subscription should fail")
+	nc.PublishRequest("foo", inbox, []byte("hello"))
+	m, err = sub.NextMsg(time.Second)
+	if err != nil {
+		t.Fatalf("Error getting next message: %v", err)
+	}
+	h := nats.Header{}
+	h.Set("Status", "received")
+	if err := m.Ack(nats.AckHeaders(h)); err != nil {
+		t.Fatalf("Error on ack: %v", err)
+	}
+	ack, err := rsub.NextMsg(time.Second)
+	if err != nil {
+		t.Fatalf("Error getting ack: %v", err)
+	}
+	if len(ack.Data) != 0 {
+		t.Fatalf("Expected empty ack, got %q", ack.Data)
+	}
+	if v := ack.Header.Get("Status"); v != "received" {
+		t.Fatalf("Expected ack header to be 'received', got %q", v)
+	}
+	// Only acknowledged once.
+	if err := m.Ack(); err != nats.ErrMsgAlreadyAckd {
+		t.Fatalf("Expected %v, got %v", nats.ErrMsgAlreadyAckd, err)
 	}
 }
 
-func TestSyncSubscriptionPendingDrain(t *testing.T) {
+func TestSubscribe_ClosedHandler(t *testing.T) {
 	s := RunDefaultServer()
 	defer s.Shutdown()
 
 	nc := NewDefaultConnection(t)
 	defer nc.Close()
 
-	// Send some messages to ourselves.
-	total := 100
-	msg := []byte("0123456789")
-
-	sub, _ := nc.SubscribeSync("foo")
-	defer sub.Unsubscribe()
-
-	for i := 0; i < total; i++ {
-		nc.Publish("foo", msg)
+	ch := make(chan string, 1)
+	sub, err := nc.Subscribe("foo", func(_ *nats.Msg) {})
+	if err != nil {
+		t.Fatalf("Error subscribing: %v", err)
 	}
-	nc.Flush()
-
-	// Wait for all delivered.
-	for d, _ := sub.Delivered(); d != int64(total); d, _ = sub.Delivered() {
-		sub.NextMsg(10 * time.Millisecond)
+	sub.SetClosedHandler(func(subj string) {
+		ch <- subj
+	})
+	sub.Unsubscribe()
+	select {
+	case subj := <-ch:
+		if subj != "foo" {
+			t.Fatalf("Expected 'foo', got '%v'", subj)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("Did not receive closed callback")
 	}
 
-	m, b, _ := sub.Pending()
-	if m != 0 {
-		t.Fatalf("Expected msgs of 0, got %d", m)
-	}
-	if b != 0 {
-		t.Fatalf("Expected bytes of 0, got %d", b)
+	sub, err = nc.Subscribe("bar", func(_ *nats.Msg) {})
+	if err != nil {
+		t.Fatalf("Error subscribing: %v", err)
 	}
-
-	sub.Unsubscribe()
-	if _, err := sub.Delivered(); err == nil {
-		t.Fatal("Calling Delivered() on closed subscription should fail")
+	sub.SetClosedHandler(func(subj string) {
+		ch <- subj
+	})
+	sub.Drain()
+	select {
+	case subj := <-ch:
+		if subj != "bar" {
+			t.Fatalf("Expected 'bar', got '%v'", subj)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("Did not receive closed callback")
 	}
 }
 
-func TestSyncSubscriptionPending(t *testing.T) {
+func TestUnsubscribeSubject(t *testing.T) {
 	s := RunDefaultServer()
 	defer s.Shutdown()
 
 	nc := NewDefaultConnection(t)
 	defer nc.Close()
 
-	sub, _ := nc.SubscribeSync("foo")
-	defer sub.Unsubscribe()
+	closed := make(chan string, 10)
+	onClosed := func(subj string) { closed <- subj }
 
-	// Send some messages to ourselves.
-	total := 100
-	msg := []byte("0123456789")
-	for i := 0; i < total; i++ {
-		nc.Publish("foo", msg)
+	var subs []*nats.Subscription
+	asub, err := nc.Subscribe("foo", func(_ *nats.Msg) {})
+	if err != nil {
+		t.Fatalf("Error subscribing: %v", err)
 	}
-	nc.Flush()
-
-	// Test old way
-	q, _, _ := sub.Pending()
-	if q != total && q != total-1 {
-		t.Fatalf("Expected %d or %d, got %d", total, total-1, q)
+	ssub, err := nc.SubscribeSync("foo")
+	if err != nil {
+		t.Fatalf("Error subscribing: %v", err)
+	}
+	csub, err := nc.ChanSubscribe("foo", make(chan *nats.Msg, 1))
+	if err != nil {
+		t.Fatalf("Error subscribing: %v", err)
+	}
+	subs = append(subs, asub, ssub, csub)
+	for _, subj := range []string{"foo.bar", "foo.*", "bar"} {
+		sub, err := nc.SubscribeSync(subj)
+		if err != nil {
+			t.Fatalf("Error subscribing: %v", err)
+		}
+		subs = append(subs, sub)
+	}
+	for _, sub := range subs {
+		sub.SetClosedHandler(onClosed)
 	}
 
-	// New way, make sure the same and check bytes.
-	m, b, _ := sub.Pending()
-	mlen := len(msg)
-
-	if m != total {
-		t.Fatalf("Expected msgs of %d, got %d", total, m)
+	n, err := nc.UnsubscribeSubject("foo")
+	if err != nil {
+		t.Fatalf("Error on unsubscribe: %v", err)
+	}
+	if n != 3 {
+		t.Fatalf("Expected 3 subscriptions removed, got %d", n)
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case subj := <-closed:
+			if subj != "foo" {
+				t.Fatalf("Expected closed handler for 'foo', got %q", subj)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Did not receive closed callback")
+		}
+	}
+	for _, sub := range subs[:3] {
+		if sub.IsValid() {
+			t.Fatalf("Expected subscription to be removed")
+		}
 	}
-	if b != total*mlen {
-		t.Fatalf("Expected bytes of %d, got %d", total*mlen, b)
+	if n := nc.NumSubscriptions(); n != 3 {
+		t.Fatalf("Expected 3 remaining subscriptions, got %d", n)
+	}
+	if n, err := nc.UnsubscribeSubject("foo"); err != nil || n != 0 {
+		t.Fatalf("Expected no subscription removed, got %d, %v", n, err)
 	}
 
-	// Now drain some down and make sure pending is correct
-	for i := 0; i < total-1; i++ {
-		sub.NextMsg(10 * time.Millisecond)
+	// Safe under concurrent subscribe and unsubscribe.
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if sub, err := nc.SubscribeSync("baz"); err == nil && j%2 == 0 {
+					sub.Unsubscribe()
+				}
+				nc.UnsubscribeSubject("baz")
+			}
+		}()
 	}
-	m, b, _ = sub.Pending()
-	if m != 1 {
-		t.Fatalf("Expected msgs of 1, got %d", m)
+	wg.Wait()
+	nc.UnsubscribeSubject("baz")
+	if n := nc.NumSubscriptions(); n != 3 {
+		t.Fatalf("Expected 3 remaining subscriptions, got %d", n)
 	}
-	if b != mlen {
-		t.Fatalf("Expected bytes of %d, got %d", mlen, b)
+
+	nc.Close()
+	if _, err := nc.UnsubscribeSubject("bar"); err != nats.ErrConnectionClosed {
+		t.Fatalf("Expected %v, got %v", nats.ErrConnectionClosed, err)
 	}
 }
 
-func TestSetPendingLimits(t *testing.T) {
+func TestSubscriptions(t *testing.T) {
 	s := RunDefaultServer()
 	defer s.Shutdown()
 
 	nc := NewDefaultConnection(t)
 	defer nc.Close()
 
-	// Override default handler for test.
-	nc.SetErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, _ error) {})
-
-	payload := []byte("hello")
-	payloadLen := len(payload)
-	toSend := 100
-
-	var sub *nats.Subscription
+	// Creates the internal response subscription, which is not returned.
+	nc.Subscribe("svc", func(m *nats.Msg) { m.Respond(nil) })
+	if _, err := nc.Request("svc", nil, time.Second); err != nil {
+		t.Fatalf("Error on request: %v", err)
+	}
 
-	// Check for invalid values
-	invalid := func() error {
-		if err := sub.SetPendingLimits(0, 1); err == nil {
-			return errors.New("Setting limit with 0 should fail")
-		}
-		if err := sub.SetPendingLimits(1, 0); err == nil {
-			return errors.New("Setting limit with 0 should fail")
-		}
-		return nil
+	syncSub, err := nc.SubscribeSync("foo")
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
 	}
-	// function to send messages
-	send := func(subject string, count int) {
-		for i := 0; i < count; i++ {
-			if err := nc.Publish(subject, payload); err != nil {
-				t.Fatalf("Unexpected error on publish: %v", err)
-			}
-		}
-		nc.Flush()
+	if _, err := nc.ChanSubscribe("bar", make(chan *nats.Msg, 1)); err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	if _, err := nc.QueueSubscribe("baz", "q", func(_ *nats.Msg) {}); err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
 	}
 
-	// Check pending vs expected values
-	var limitCount, limitBytes int
-	var expectedCount, expectedBytes int
-	checkPending := func() error {
-		lc, lb, err := sub.PendingLimits()
-		if err != nil {
-			return err
+	type subInfo struct {
+		subj, queue string
+		typ         nats.SubscriptionType
+	}
+	svc := subInfo{"svc", "", nats.AsyncSubscription}
+	foo := subInfo{"foo", "", nats.SyncSubscription}
+	bar := subInfo{"bar", "", nats.ChanSubscription}
+	baz := subInfo{"baz", "q", nats.AsyncSubscription}
+	check := func(expected ...subInfo) {
+		t.Helper()
+		var got []subInfo
+		for _, sub := range nc.Subscriptions() {
+			got = append(got, subInfo{sub.Subject, sub.Queue, sub.Type()})
 		}
-		if lc != limitCount || lb != limitBytes {
-			return fmt.Errorf("Unexpected limits, expected %v msgs %v bytes, got %v msgs %v bytes",
-				limitCount, limitBytes, lc, lb)
+		if !reflect.DeepEqual(got, expected) {
+			t.Fatalf("Expected subscriptions %v, got %v", expected, got)
 		}
-		msgs, bytes, err := sub.Pending()
-		if err != nil {
-			return fmt.Errorf("Unexpected error getting pending counts: %v", err)
+	}
+	check(svc, foo, bar, baz)
+
+	syncSub.Unsubscribe()
+	check(svc, bar, baz)
+
+	// Safe to call while subscribing and unsubscribing concurrently.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			sub, err := nc.SubscribeSync("concurrent")
+			if err != nil {
+				return
+			}
+			sub.Unsubscribe()
 		}
-		if (msgs != expectedCount && msgs != expectedCount-1) ||
-			(bytes != expectedBytes && bytes != expectedBytes-payloadLen) {
-			return fmt.Errorf("Unexpected counts, expected %v msgs %v bytes, got %v msgs %v bytes",
-				expectedCount, expectedBytes, msgs, bytes)
+	}()
+	for i := 0; i < 100; i++ {
+		for _, sub := range nc.Subscriptions() {
+			sub.Pending()
 		}
-		return nil
 	}
+	wg.Wait()
+	check(svc, bar, baz)
 
-	recv := make(chan bool)
-	block := make(chan bool)
-	cb := func(m *nats.Msg) {
-		recv <- true
-		<-block
-		m.Sub.Unsubscribe()
+	nc.Close()
+	if subs := nc.Subscriptions(); len(subs) != 0 {
+		t.Fatalf("Expected no subscriptions, got %d", len(subs))
 	}
-	subj := "foo"
-	sub, err := nc.Subscribe(subj, cb)
+}
+
+func TestSubscriptionIsInternal(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(nats.DefaultURL, nats.UseOldRequestStyle())
 	if err != nil {
-		t.Fatalf("Unexpected error on subscribe: %v", err)
+		t.Fatalf("Error on connect: %v", err)
 	}
-	defer sub.Unsubscribe()
-	if err := invalid(); err != nil {
-		t.Fatalf("%v", err)
+	defer nc.Close()
+
+	internal := func() []string {
+		var subjs []string
+		for _, sub := range nc.Subscriptions() {
+			if sub.IsInternal() {
+				subjs = append(subjs, sub.Subject)
+			}
+		}
+		return subjs
 	}
-	// Check we apply limit only for size
-	limitCount = -1
-	limitBytes = (toSend / 2) * payloadLen
-	if err := sub.SetPendingLimits(limitCount, limitBytes); err != nil {
-		t.Fatalf("Unexpected error setting limits: %v", err)
+
+	var inboxes []string
+	svc, err := nc.Subscribe("svc", func(m *nats.Msg) {
+		// The inbox of the request in progress.
+		inboxes = internal()
+		m.Respond(nil)
+	})
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
 	}
-	// Send messages
-	send(subj, toSend)
-	// Wait for message to be received
-	if err := Wait(recv); err != nil {
-		t.Fatal("Did not get our message")
+	if svc.IsInternal() {
+		t.Fatal("Expected user subscription to not be internal")
 	}
-	expectedBytes = limitBytes
-	expectedCount = limitBytes / payloadLen
-	if err := checkPending(); err != nil {
-		t.Fatalf("%v", err)
+	if _, err := nc.Request("svc", nil, time.Second); err != nil {
+		t.Fatalf("Error on request: %v", err)
+	}
+	if len(inboxes) != 1 || !strings.HasPrefix(inboxes[0], nats.InboxPrefix) {
+		t.Fatalf("Expected the request inbox subscription, got %v", inboxes)
 	}
-	// Release callback
-	block <- true
 
-	subj = "bar"
-	sub, err = nc.Subscribe(subj, cb)
+	js, err := nc.JetStream()
 	if err != nil {
-		t.Fatalf("Unexpected error on subscribe: %v", err)
+		t.Fatalf("Error getting JetStream context: %v", err)
 	}
-	defer sub.Unsubscribe()
-	// Check we apply limit only for count
-	limitCount = toSend / 4
-	limitBytes = -1
-	if err := sub.SetPendingLimits(limitCount, limitBytes); err != nil {
-		t.Fatalf("Unexpected error setting limits: %v", err)
+	// Creates the subscription for the acks.
+	if _, err := js.PublishAsync("foo", nil); err != nil {
+		t.Fatalf("Error on publish: %v", err)
 	}
-	// Send messages
-	send(subj, toSend)
-	// Wait for message to be received
-	if err := Wait(recv); err != nil {
-		t.Fatal("Did not get our message")
+	if subjs := internal(); len(subjs) != 1 || !strings.HasPrefix(subjs[0], nats.InboxPrefix) {
+		t.Fatalf("Expected the async publish acks subscription, got %v", subjs)
 	}
-	expectedCount = limitCount
-	expectedBytes = limitCount * payloadLen
-	if err := checkPending(); err != nil {
-		t.Fatalf("%v", err)
+
+	var nilSub *nats.Subscription
+	if nilSub.IsInternal() {
+		t.Fatal("Expected nil subscription to not be internal")
 	}
-	// Release callback
-	block <- true
+}
 
-	subj = "baz"
-	sub, err = nc.SubscribeSync(subj)
+func TestInboundRateLimit(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	if _, err := nats.Connect(nats.DefaultURL, nats.InboundRateLimit(-1)); !errors.Is(err, nats.ErrInvalidArg) {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+	}
+
+	nc, err := nats.Connect(nats.DefaultURL, nats.InboundRateLimit(50))
 	if err != nil {
-		t.Fatalf("Unexpected error on subscribe: %v", err)
+		t.Fatalf("Error on connect: %v", err)
 	}
-	defer sub.Unsubscribe()
-	if err := invalid(); err != nil {
-		t.Fatalf("%v", err)
+	defer nc.Close()
+
+	ch := make(chan struct{}, 100)
+	var subs []*nats.Subscription
+	for _, subj := range []string{"foo", "bar"} {
+		sub, err := nc.Subscribe(subj, func(_ *nats.Msg) { ch <- struct{}{} })
+		if err != nil {
+			t.Fatalf("Error on subscribe: %v", err)
+		}
+		subs = append(subs, sub)
+	}
+	burst := func(total int) time.Duration {
+		t.Helper()
+		start := time.Now()
+		for i := 0; i < total; i++ {
+			// The limit applies to all subscriptions combined.
+			subj := "foo"
+			if i%2 == 1 {
+				subj = "bar"
+			}
+			nc.Publish(subj, []byte("hello"))
+		}
+		nc.Flush()
+		for i := 0; i < total; i++ {
+			select {
+			case <-ch:
+			case <-time.After(2 * time.Second):
+				t.Fatalf("Received only %d messages", i)
+			}
+		}
+		return time.Since(start)
 	}
-	// Check we apply limit only for size
-	limitCount = -1
-	limitBytes = (toSend / 2) * payloadLen
-	if err := sub.SetPendingLimits(limitCount, limitBytes); err != nil {
-		t.Fatalf("Unexpected error setting limits: %v", err)
+
+	// 25 messages at 50 per second, with bursts of 5, take about 400ms.
+	if d := burst(25); d < 300*time.Millisecond || d > 1500*time.Millisecond {
+		t.Fatalf("Expected delivery to be paced, took %v", d)
 	}
-	// Send messages
-	send(subj, toSend)
-	expectedBytes = limitBytes
-	expectedCount = limitBytes / payloadLen
-	if err := checkPending(); err != nil {
-		t.Fatalf("%v", err)
+
+	if err := nc.SetInboundRateLimit(-1); !errors.Is(err, nats.ErrInvalidArg) {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
+	}
+	if err := nc.SetInboundRateLimit(0); err != nil {
+		t.Fatalf("Error setting rate limit: %v", err)
+	}
+	if d := burst(25); d > 300*time.Millisecond {
+		t.Fatalf("Expected delivery to not be paced, took %v", d)
 	}
-	sub.Unsubscribe()
-	nc.Flush()
 
-	subj = "boz"
-	sub, err = nc.SubscribeSync(subj)
-	if err != nil {
-		t.Fatalf("Unexpected error on subscribe: %v", err)
+	// Internal subscriptions are not limited: the reply to a request is
+	// received while messages are waiting for the limiter.
+	if err := nc.SetInboundRateLimit(10); err != nil {
+		t.Fatalf("Error setting rate limit: %v", err)
 	}
-	defer sub.Unsubscribe()
-	// Check we apply limit only for count
-	limitCount = toSend / 4
-	limitBytes = -1
-	if err := sub.SetPendingLimits(limitCount, limitBytes); err != nil {
-		t.Fatalf("Unexpected error setting limits: %v", err)
+	rsub, err := nc.SubscribeSync("svc")
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
 	}
-	// Send messages
-	send(subj, toSend)
-	expectedCount = limitCount
-	expectedBytes = limitCount * payloadLen
-	if err := checkPending(); err != nil {
-		t.Fatalf("%v", err)
+	go func() {
+		if m, err := rsub.NextMsg(2 * time.Second); err == nil {
+			m.Respond([]byte("ok"))
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		nc.Publish("foo", []byte("hello"))
 	}
-	sub.Unsubscribe()
 	nc.Flush()
+	if _, err := nc.Request("svc", nil, 500*time.Millisecond); err != nil {
+		t.Fatalf("Error on request: %v", err)
+	}
+
+	// Messages waiting for the limiter are not delivered once the
+	// subscription is closed.
+	if err := subs[0].Unsubscribe(); err != nil {
+		t.Fatalf("Error on unsubscribe: %v", err)
+	}
+	received := len(ch)
+	time.Sleep(300 * time.Millisecond)
+	if n := len(ch); n != received {
+		t.Fatalf("Expected no message after unsubscribe, got %d", n-received)
+	}
 }
 
-func TestSubscriptionTypes(t *testing.T) {
+func TestSubscriptionSetMsgHandler(t *testing.T) {
 	s := RunDefaultServer()
 	defer s.Shutdown()
 
 	nc := NewDefaultConnection(t)
 	defer nc.Close()
 
-	sub, _ := nc.Subscribe("foo", func(_ *nats.Msg) {})
-	defer sub.Unsubscribe()
-	if st := sub.Type(); st != nats.AsyncSubscription {
-		t.Fatalf("Expected AsyncSubscription, got %v", st)
+	total, swapAt := 100, 50
+	var old, cur []int
+	done := make(chan struct{})
+	var sub *nats.Subscription
+	newCB := func(m *nats.Msg) {
+		n, _ := strconv.Atoi(string(m.Data))
+		cur = append(cur, n)
+		if len(old)+len(cur) == total {
+			close(done)
+		}
 	}
-	// Check Pending
-	if err := sub.SetPendingLimits(1, 100); err != nil {
-		t.Fatalf("We should be able to SetPendingLimits()")
+	sub, err := nc.Subscribe("foo", func(m *nats.Msg) {
+		n, _ := strconv.Atoi(string(m.Data))
+		old = append(old, n)
+		if n == swapAt {
+			if err := sub.SetMsgHandler(newCB); err != nil {
+				t.Errorf("Error setting handler: %v", err)
+			}
+		}
+	})
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
 	}
-	if _, _, err := sub.Pending(); err != nil {
-		t.Fatalf("We should be able to call Pending()")
+	if err := sub.SetMsgHandler(nil); !errors.Is(err, nats.ErrInvalidArg) {
+		t.Fatalf("Expected %v, got %v", nats.ErrInvalidArg, err)
 	}
-	sub.Unsubscribe()
-	if err := sub.SetPendingLimits(1, 100); err == nil {
-		t.Fatal("Calling SetPendingLimits() on closed subscription should fail")
+	for i := 1; i <= total; i++ {
+		nc.Publish("foo", []byte(strconv.Itoa(i)))
 	}
-	if _, _, err := sub.PendingLimits(); err == nil {
-		t.Fatal("Calling PendingLimits() on closed subscription should fail")
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Did not receive all messages")
 	}
-
-	sub, _ = nc.SubscribeSync("foo")
-	defer sub.Unsubscribe()
-	if st := sub.Type(); st != nats.SyncSubscription {
-		t.Fatalf("Expected SyncSubscription, got %v", st)
+	for i, n := range old {
+		if n != i+1 {
+			t.Fatalf("Unexpected messages for the previous handler: %v", old)
+		}
 	}
-	// Check Pending
-	if err := sub.SetPendingLimits(1, 100); err != nil {
-		t.Fatalf("We should be able to SetPendingLimits()")
+	for i, n := range cur {
+		if n != swapAt+i+1 {
+			t.Fatalf("Unexpected messages for the new handler: %v", cur)
+		}
 	}
-	if _, _, err := sub.Pending(); err != nil {
-		t.Fatalf("We should be able to call Pending()")
+	if len(old) != swapAt {
+		t.Fatalf("Expected %d messages for the previous handler, got %d", swapAt, len(old))
 	}
-	sub.Unsubscribe()
-	if err := sub.SetPendingLimits(1, 100); err == nil {
-		t.Fatal("Calling SetPendingLimits() on closed subscription should fail")
+
+	ssub, err := nc.SubscribeSync("bar")
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	if err := ssub.SetMsgHandler(newCB); err != nats.ErrTypeSubscription {
+		t.Fatalf("Expected %v, got %v", nats.ErrTypeSubscription, err)
+	}
+	csub, err := nc.ChanSubscribe("baz", make(chan *nats.Msg, 1))
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
 	}
-	if _, _, err := sub.PendingLimits(); err == nil {
-		t.Fatal("Calling PendingLimits() on closed subscription should fail")
+	if err := csub.SetMsgHandler(newCB); err != nats.ErrTypeSubscription {
+		t.Fatalf("Expected %v, got %v", nats.ErrTypeSubscription, err)
+	}
+	sub.Unsubscribe()
+	if err := sub.SetMsgHandler(newCB); err != nats.ErrBadSubscription {
+		t.Fatalf("Expected %v, got %v", nats.ErrBadSubscription, err)
 	}
+}
 
-	sub, _ = nc.ChanSubscribe("foo", make(chan *nats.Msg))
-	defer sub.Unsubscribe()
-	if st := sub.Type(); st != nats.ChanSubscription {
-		t.Fatalf("Expected ChanSubscription, got %v", st)
+func TestSubscriptionCallbackLatency(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	done := make(chan struct{})
+	count := 0
+	sub, err := nc.Subscribe("foo", func(m *nats.Msg) {
+		if string(m.Data) == "slow" {
+			time.Sleep(50 * time.Millisecond)
+		}
+		if count++; count == 10 {
+			close(done)
+		}
+	})
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
 	}
-	// Check Pending
-	if err := sub.SetPendingLimits(1, 100); err == nil {
-		t.Fatalf("We should NOT be able to SetPendingLimits() on ChanSubscriber")
+	p50, p99, max, err := sub.CallbackLatency()
+	if err != nil {
+		t.Fatalf("Error getting latency: %v", err)
 	}
-	if _, _, err := sub.Pending(); err == nil {
-		t.Fatalf("We should NOT be able to call Pending() on ChanSubscriber")
+	if p50 != 0 || p99 != 0 || max != 0 {
+		t.Fatalf("Expected no latency, got %v/%v/%v", p50, p99, max)
 	}
-	if _, _, err := sub.MaxPending(); err == nil {
-		t.Fatalf("We should NOT be able to call MaxPending() on ChanSubscriber")
+
+	nc.Publish("foo", []byte("slow"))
+	for i := 0; i < 9; i++ {
+		nc.Publish("foo", []byte("fast"))
 	}
-	if err := sub.ClearMaxPending(); err == nil {
-		t.Fatalf("We should NOT be able to call ClearMaxPending() on ChanSubscriber")
+	WaitOnChannel(t, done, struct{}{})
+	// The last callback may still be timed.
+	waitFor(t, time.Second, 10*time.Millisecond, func() error {
+		p50, p99, max, err = sub.CallbackLatency()
+		if err != nil {
+			return err
+		}
+		if p50 >= 10*time.Millisecond {
+			return fmt.Errorf("unexpected p50 %v", p50)
+		}
+		if max < 50*time.Millisecond || p99 < max*3/4 || p99 > max {
+			return fmt.Errorf("unexpected p99 %v and max %v", p99, max)
+		}
+		return nil
+	})
+
+	syncSub, err := nc.SubscribeSync("bar")
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
 	}
-	if _, _, err := sub.PendingLimits(); err == nil {
-		t.Fatalf("We should NOT be able to call PendingLimits() on ChanSubscriber")
+	if _, _, _, err := syncSub.CallbackLatency(); !errors.Is(err, nats.ErrTypeSubscription) {
+		t.Fatalf("Expected %v, got %v", nats.ErrTypeSubscription, err)
+	}
+	sub.Unsubscribe()
+	if _, _, _, err := sub.CallbackLatency(); !errors.Is(err, nats.ErrBadSubscription) {
+		t.Fatalf("Expected %v, got %v", nats.ErrBadSubscription, err)
 	}
 }
 
-func TestAutoUnsubOnSyncSubCanStillRespond(t *testing.T) {
+func TestMsgReceivedAt(t *testing.T) {
 	s := RunDefaultServer()
 	defer s.Shutdown()
 
 	nc := NewDefaultConnection(t)
 	defer nc.Close()
 
-	subj := nuid.Next()
-	sub, err := nc.SubscribeSync(subj)
-	if err != nil {
-		t.Fatalf("Error susbscribing: %v", err)
+	if ts := (&nats.Msg{Subject: "foo"}).ReceivedAt(); !ts.IsZero() {
+		t.Fatalf("Expected zero time, got %v", ts)
 	}
-	// When the single message is delivered, the
-	// auto unsub will reap the subscription removing
-	// the connection, make sure Respond still works.
-	if err := sub.AutoUnsubscribe(1); err != nil {
-		t.Fatalf("Error autounsub: %v", err)
+
+	delays := make(chan time.Duration, 2)
+	sub, err := nc.Subscribe("foo", func(m *nats.Msg) {
+		delays <- time.Since(m.ReceivedAt())
+		time.Sleep(50 * time.Millisecond)
+	})
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
 	}
+	start := time.Now()
+	nc.Publish("foo", nil)
+	nc.Publish("foo", nil)
+	nc.Flush()
 
-	inbox := nats.NewInbox()
-	if err = nc.PublishRequest(subj, inbox, nil); err != nil {
-		t.Fatalf("Error making request: %v", err)
+	// The second message waits for the first callback to return.
+	first, second := <-delays, <-delays
+	if first >= 40*time.Millisecond || second < 40*time.Millisecond {
+		t.Fatalf("Unexpected queuing delays: %v and %v", first, second)
 	}
+	last, err := sub.LastMsgTime()
+	if err != nil || last.Before(start) {
+		t.Fatalf("Unexpected last message time: %v, %v", last, err)
+	}
+}
 
-	m, err := sub.NextMsg(time.Second)
+func TestSubscriptionPauseResume(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(nats.DefaultURL, nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, _ error) {}))
 	if err != nil {
-		t.Fatalf("Error getting next message")
+		t.Fatalf("Error connecting: %v", err)
 	}
-	if err := m.Respond(nil); err != nil {
-		t.Fatalf("Error responding: %v", err)
+	defer nc.Close()
+
+	publish := func(subj string, count int) {
+		t.Helper()
+		for i := 0; i < count; i++ {
+			nc.Publish(subj, []byte(strconv.Itoa(i)))
+		}
+		if err := nc.Flush(); err != nil {
+			t.Fatalf("Error on flush: %v", err)
+		}
+	}
+
+	t.Run("async", func(t *testing.T) {
+		received := make(chan string, 20)
+		sub, err := nc.Subscribe("foo", func(m *nats.Msg) {
+			received <- string(m.Data)
+		})
+		if err != nil {
+			t.Fatalf("Error on subscribe: %v", err)
+		}
+		defer sub.Unsubscribe()
+		statusCh := sub.StatusChanged(nats.SubscriptionPaused, nats.SubscriptionActive)
+		WaitOnChannel(t, statusCh, nats.SubscriptionActive)
+
+		if err := sub.Pause(); err != nil {
+			t.Fatalf("Error on pause: %v", err)
+		}
+		WaitOnChannel(t, statusCh, nats.SubscriptionPaused)
+		publish("foo", 10)
+		select {
+		case m := <-received:
+			t.Fatalf("Unexpected message delivered while paused: %s", m)
+		case <-time.After(100 * time.Millisecond):
+		}
+		if n, _, _ := sub.Pending(); n != 10 {
+			t.Fatalf("Expected 10 pending messages, got %v", n)
+		}
+
+		if err := sub.Resume(); err != nil {
+			t.Fatalf("Error on resume: %v", err)
+		}
+		WaitOnChannel(t, statusCh, nats.SubscriptionActive)
+		for i := 0; i < 10; i++ {
+			WaitOnChannel(t, received, strconv.Itoa(i))
+		}
+	})
+
+	t.Run("sync", func(t *testing.T) {
+		sub, err := nc.SubscribeSync("bar")
+		if err != nil {
+			t.Fatalf("Error on subscribe: %v", err)
+		}
+		defer sub.Unsubscribe()
+		if err := sub.Pause(); err != nil {
+			t.Fatalf("Error on pause: %v", err)
+		}
+		publish("bar", 2)
+		if _, err := sub.NextMsg(50 * time.Millisecond); err != nats.ErrTimeout {
+			t.Fatalf("Expected %v, got %v", nats.ErrTimeout, err)
+		}
+		time.AfterFunc(100*time.Millisecond, func() { sub.Resume() })
+		start := time.Now()
+		m, err := sub.NextMsg(2 * time.Second)
+		if err != nil {
+			t.Fatalf("Error on next msg: %v", err)
+		}
+		if string(m.Data) != "0" || time.Since(start) < 50*time.Millisecond {
+			t.Fatalf("Unexpected message %q after %v", m.Data, time.Since(start))
+		}
+		if m, err := sub.NextMsg(time.Second); err != nil || string(m.Data) != "1" {
+			t.Fatalf("Unexpected message %v, %v", m, err)
+		}
+	})
+
+	t.Run("slow consumer while paused", func(t *testing.T) {
+		received := make(chan string, 20)
+		sub, err := nc.Subscribe("baz", func(m *nats.Msg) {
+			received <- string(m.Data)
+		})
+		if err != nil {
+			t.Fatalf("Error on subscribe: %v", err)
+		}
+		defer sub.Unsubscribe()
+		sub.SetPendingLimits(5, -1)
+		if err := sub.Pause(); err != nil {
+			t.Fatalf("Error on pause: %v", err)
+		}
+		publish("baz", 10)
+		if dropped, _ := sub.Dropped(); dropped != 5 {
+			t.Fatalf("Expected 5 dropped messages, got %v", dropped)
+		}
+		if err := sub.Resume(); err != nil {
+			t.Fatalf("Error on resume: %v", err)
+		}
+		for i := 0; i < 5; i++ {
+			WaitOnChannel(t, received, strconv.Itoa(i))
+		}
+		select {
+		case m := <-received:
+			t.Fatalf("Unexpected message: %s", m)
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		sub, err := nc.ChanSubscribe("chan", make(chan *nats.Msg, 1))
+		if err != nil {
+			t.Fatalf("Error on subscribe: %v", err)
+		}
+		if err := sub.Pause(); err != nats.ErrTypeSubscription {
+			t.Fatalf("Expected %v, got %v", nats.ErrTypeSubscription, err)
+		}
+		sub.Unsubscribe()
+		if err := sub.Resume(); err != nats.ErrBadSubscription {
+			t.Fatalf("Expected %v, got %v", nats.ErrBadSubscription, err)
+		}
+	})
+}
+
+func TestSubscriptionWaitForInterest(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+	pub := NewDefaultConnection(t)
+	defer pub.Close()
+
+	for i := 0; i < 10; i++ {
+		subj := fmt.Sprintf("foo.%d", i)
+		sub, err := nc.SubscribeSync(subj)
+		if err != nil {
+			t.Fatalf("Error on subscribe: %v", err)
+		}
+		if err := sub.WaitForInterest(time.Second); err != nil {
+			t.Fatalf("Error waiting for interest: %v", err)
+		}
+		// Published right away from another connection, without flushing
+		// the subscribing one.
+		pub.Publish(subj, nil)
+		pub.Flush()
+		if _, err := sub.NextMsg(time.Second); err != nil {
+			t.Fatalf("Message published after WaitForInterest was missed: %v", err)
+		}
+		sub.Unsubscribe()
+		if err := sub.WaitForInterest(time.Second); err != nats.ErrBadSubscription {
+			t.Fatalf("Expected %v, got %v", nats.ErrBadSubscription, err)
+		}
 	}
 }
 
-func TestSubscribe_ClosedHandler(t *testing.T) {
+func TestSubscriptionResubscribe(t *testing.T) {
 	s := RunDefaultServer()
 	defer s.Shutdown()
 
 	nc := NewDefaultConnection(t)
 	defer nc.Close()
 
-	ch := make(chan string, 1)
-	sub, err := nc.Subscribe("foo", func(_ *nats.Msg) {})
+	var mu sync.Mutex
+	var received []string
+	sub, err := nc.Subscribe("foo", func(m *nats.Msg) {
+		mu.Lock()
+		received = append(received, m.Subject)
+		mu.Unlock()
+	})
 	if err != nil {
 		t.Fatalf("Error subscribing: %v", err)
 	}
-	sub.SetClosedHandler(func(subj string) {
-		ch <- subj
-	})
-	sub.Unsubscribe()
-	select {
-	case subj := <-ch:
-		if subj != "foo" {
-			t.Fatalf("Expected 'foo', got '%v'", subj)
+	sub.SetPendingLimits(100, 1024*1024)
+	if err := sub.AutoUnsubscribe(5); err != nil {
+		t.Fatalf("Error on auto unsubscribe: %v", err)
+	}
+
+	nc.Publish("foo", []byte("msg"))
+	nc.Flush()
+	if err := sub.Resubscribe("bar"); err != nil {
+		t.Fatalf("Error on resubscribe: %v", err)
+	}
+	if sub.Subject != "bar" {
+		t.Fatalf("Expected subject to be 'bar', got %q", sub.Subject)
+	}
+	if err := sub.Resubscribe("bar"); err != nil {
+		t.Fatalf("Expected resubscribe to the same subject to be a no-op, got %v", err)
+	}
+	if msgs, bytes, _ := sub.PendingLimits(); msgs != 100 || bytes != 1024*1024 {
+		t.Fatalf("Expected pending limits to be preserved, got %d/%d", msgs, bytes)
+	}
+	if err := sub.Resubscribe("bad subject"); err != nats.ErrBadSubject {
+		t.Fatalf("Expected %v, got %v", nats.ErrBadSubject, err)
+	}
+
+	// Messages on the old subject are no longer received, and the
+	// maximum is counted across both subjects.
+	for i := 0; i < 10; i++ {
+		nc.Publish("foo", []byte("msg"))
+		nc.Publish("bar", []byte("msg"))
+	}
+	nc.Flush()
+	waitFor(t, time.Second, 15*time.Millisecond, func() error {
+		if sub.IsValid() {
+			return fmt.Errorf("Expected subscription to be removed after max messages")
 		}
-	case <-time.After(1 * time.Second):
-		t.Fatal("Did not receive closed callback")
+		return nil
+	})
+	mu.Lock()
+	expected := []string{"foo", "bar", "bar", "bar", "bar"}
+	if !reflect.DeepEqual(received, expected) {
+		mu.Unlock()
+		t.Fatalf("Expected %v, got %v", expected, received)
+	}
+	mu.Unlock()
+	if err := sub.Resubscribe("baz"); err != nats.ErrBadSubscription {
+		t.Fatalf("Expected %v, got %v", nats.ErrBadSubscription, err)
 	}
 
-	sub, err = nc.Subscribe("bar", func(_ *nats.Msg) {})
+	// Sync subscriptions can be moved too.
+	ssub, err := nc.SubscribeSync("foo")
 	if err != nil {
 		t.Fatalf("Error subscribing: %v", err)
 	}
-	sub.SetClosedHandler(func(subj string) {
-		ch <- subj
-	})
-	sub.Drain()
-	select {
-	case subj := <-ch:
-		if subj != "bar" {
-			t.Fatalf("Expected 'bar', got '%v'", subj)
-		}
-	case <-time.After(1 * time.Second):
-		t.Fatal("Did not receive closed callback")
+	if err := ssub.Resubscribe("baz"); err != nil {
+		t.Fatalf("Error on resubscribe: %v", err)
+	}
+	nc.Publish("foo", []byte("msg"))
+	nc.Publish("baz", []byte("msg"))
+	if m, err := ssub.NextMsg(time.Second); err != nil || m.Subject != "baz" {
+		t.Fatalf("Expected message on 'baz', got %v, %v", m, err)
+	}
+	if n := nc.NumSubscriptions(); n != 1 {
+		t.Fatalf("Expected 1 subscription, got %d", n)
+	}
+
+	csub, err := nc.ChanSubscribe("foo", make(chan *nats.Msg, 1))
+	if err != nil {
+		t.Fatalf("Error subscribing: %v", err)
+	}
+	if err := csub.Resubscribe("bar"); err != nats.ErrTypeSubscription {
+		t.Fatalf("Expected %v, got %v", nats.ErrTypeSubscription, err)
 	}
 }
 
@@ -1739,6 +5365,129 @@ func TestSubscriptionEvents(t *testing.T) {
 	})
 }
 
+func TestSlowConsumerHandler(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	// disable slow consumer prints
+	nc.SetErrorHandler(func(c *nats.Conn, s *nats.Subscription, e error) {})
+	defer nc.Close()
+
+	checkEvents := func(t *testing.T, events chan string, expected ...string) {
+		t.Helper()
+		for _, e := range expected {
+			select {
+			case ev := <-events:
+				if ev != e {
+					t.Fatalf("Expected %q event, got %q", e, ev)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("Did not get %q event", e)
+			}
+		}
+		select {
+		case ev := <-events:
+			t.Fatalf("Unexpected %q event", ev)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	t.Run("async", func(t *testing.T) {
+		blockChan := make(chan struct{})
+		sub, err := nc.Subscribe("foo", func(_ *nats.Msg) {
+			<-blockChan
+		})
+		if err != nil {
+			t.Fatalf("Error subscribing: %v", err)
+		}
+		defer sub.Unsubscribe()
+		defer close(blockChan)
+		sub.SetPendingLimits(1, -1)
+
+		events := make(chan string, 100)
+		err = sub.SetSlowConsumerHandler(
+			func(s *nats.Subscription) { events <- "enter" },
+			func(s *nats.Subscription) { events <- "exit" })
+		if err != nil {
+			t.Fatalf("Error setting handler: %v", err)
+		}
+
+		// The first message blocks in the callback.
+		nc.Publish("foo", []byte("Hello"))
+		for i := 0; i < 5; i++ {
+			// Subscription enters slow consumer state, further messages
+			// being dropped without new events.
+			nc.Publish("foo", []byte("Hello"))
+			nc.Publish("foo", []byte("Hello"))
+			nc.Flush()
+			checkEvents(t, events, "enter")
+
+			// Once delivered, the next message is accepted.
+			blockChan <- struct{}{}
+			waitFor(t, time.Second, 10*time.Millisecond, func() error {
+				if n, _, _ := sub.Pending(); n != 0 {
+					return fmt.Errorf("%d messages still pending", n)
+				}
+				return nil
+			})
+			nc.Publish("foo", []byte("Hello"))
+			nc.Flush()
+			checkEvents(t, events, "exit")
+		}
+	})
+
+	t.Run("sync", func(t *testing.T) {
+		sub, err := nc.SubscribeSync("bar")
+		if err != nil {
+			t.Fatalf("Error subscribing: %v", err)
+		}
+		defer sub.Unsubscribe()
+		sub.SetPendingLimits(2, -1)
+
+		events := make(chan string, 100)
+		err = sub.SetSlowConsumerHandler(
+			func(s *nats.Subscription) { events <- "enter:" + s.Subject },
+			func(s *nats.Subscription) { events <- "exit:" + s.Subject })
+		if err != nil {
+			t.Fatalf("Error setting handler: %v", err)
+		}
+
+		for i := 0; i < 5; i++ {
+			for j := 0; j < 3; j++ {
+				nc.Publish("bar", []byte("Hello"))
+			}
+			nc.Flush()
+			checkEvents(t, events, "enter:bar")
+
+			if _, err := sub.NextMsg(time.Second); !errors.Is(err, nats.ErrSlowConsumer) {
+				t.Fatalf("Expected %v, got %v", nats.ErrSlowConsumer, err)
+			}
+			checkEvents(t, events, "exit:bar")
+			for j := 0; j < 2; j++ {
+				if _, err := sub.NextMsg(time.Second); err != nil {
+					t.Fatalf("Error getting message: %v", err)
+				}
+			}
+		}
+
+		// Handlers can be removed.
+		if err := sub.SetSlowConsumerHandler(nil, nil); err != nil {
+			t.Fatalf("Error removing handler: %v", err)
+		}
+		for j := 0; j < 3; j++ {
+			nc.Publish("bar", []byte("Hello"))
+		}
+		nc.Flush()
+		checkEvents(t, events)
+
+		sub.Unsubscribe()
+		if err := sub.SetSlowConsumerHandler(nil, nil); err != nats.ErrBadSubscription {
+			t.Fatalf("Expected %v, got %v", nats.ErrBadSubscription, err)
+		}
+	})
+}
+
 func TestMaxSubscriptionsExceeded(t *testing.T) {
 	conf := createConfFile(t, []byte(`
 	listen: 127.0.0.1:-1